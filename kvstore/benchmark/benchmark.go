@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kvstore/connect"
+)
+
+//Program to measure throughput of server with database(redis format).
+//Every client has its own connection and sends the next request as soon as it gets response to the previous one.
+//Clients go through commands with random keys: set, get, getset and del of two keys(to check commands with several keys).
+//With -pipeline n client sends n requests at once and then reads n replies.
+//
+//Usage: benchmark [-clients 100,1000] [-duration 10s] [-keys 10000] [-pipeline 1] [-tls [-cacert <file>] [-cert <file> -key <file>]]
+//  [addr] [protocol]
+//For every amount of clients prints amount of requests per second and average response time.
+
+const (
+	defaultProtocol = "tcp"
+	defaultAddr     = "127.0.0.1:16998"
+)
+
+type config struct {
+	protocol string
+	addr     string
+	clients  []int              //amounts of concurrent clients to measure with
+	duration time.Duration      //duration of measurement for every amount of clients
+	keys     int                //amount of different keys
+	pipeline int                //amount of requests sent at once, before replies are read
+	tls      connect.TLSOptions //connection over TLS
+}
+
+//result - requests made by one client.
+type result struct {
+	requests     int
+	responseTime time.Duration //total time of waiting for responses
+	err          error
+}
+
+func main() {
+	config := getConfig(os.Args)
+
+	fmt.Printf("%10s %12s %12s %14s\n", "clients", "requests", "requests/s", "avg response")
+	for _, clients := range config.clients {
+		requests, responseTime := benchmark(config, clients)
+
+		average := time.Duration(0)
+		if requests > 0 {
+			average = responseTime / time.Duration(requests)
+		}
+		fmt.Printf("%10d %12d %12.0f %14v\n", clients, requests, float64(requests)/config.duration.Seconds(), average)
+	}
+}
+
+//benchmark - runs clients concurrently for config.duration. Returns amount of requests and total response time.
+func benchmark(config *config, clients int) (int, time.Duration) {
+	conns := make([]net.Conn, clients)
+	for i := range conns {
+		conn, err := connect.Dial(config.protocol, config.addr, &config.tls)
+		ifErrFatal(err)
+		conns[i] = conn
+	}
+
+	results := make(chan result, clients)
+	start := make(chan struct{})
+	wg := &sync.WaitGroup{}
+
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+
+			<-start
+			results <- runClient(conn, rand.New(rand.NewSource(int64(i))), config.keys, config.pipeline, time.Now().Add(config.duration))
+		}(i, conn)
+	}
+
+	close(start)
+	wg.Wait()
+	close(results)
+
+	requests, responseTime := 0, time.Duration(0)
+	for result := range results {
+		logErr(result.err)
+		requests += result.requests
+		responseTime += result.responseTime
+	}
+
+	return requests, responseTime
+}
+
+//runClient - sends requests to conn by pipeline at once until deadline.
+func runClient(conn net.Conn, random *rand.Rand, keys, pipeline int, deadline time.Time) result {
+	res := result{}
+	connReader := bufio.NewReader(conn)
+
+	for i := 0; time.Now().Before(deadline); {
+		var requests []byte
+		for j := 0; j < pipeline; j, i = j+1, i+1 {
+			key := "key:" + strconv.Itoa(random.Intn(keys))
+
+			var request string
+			switch i % 4 {
+			case 0:
+				request = "set " + key + " " + strconv.Itoa(i)
+			case 1:
+				request = "get " + key
+			case 2:
+				request = "getset " + key + " " + strconv.Itoa(i)
+			default:
+				request = "del " + key + " key:" + strconv.Itoa(random.Intn(keys))
+			}
+			requests = append(requests, makeNetstring(request)...)
+		}
+
+		startRequest := time.Now()
+
+		_, err := conn.Write(requests)
+		if err != nil {
+			res.err = fmt.Errorf("ERR: Can't send requests. ERR: %s;", err)
+			return res
+		}
+
+		for j := 0; j < pipeline; j++ {
+			responseLength, err := getResponseLength(connReader)
+			if err != nil {
+				res.err = err
+				return res
+			}
+
+			_, err = io.CopyN(ioutil.Discard, connReader, int64(responseLength))
+			if err != nil {
+				res.err = fmt.Errorf("ERR: No response from server: %s;", err)
+				return res
+			}
+		}
+
+		//every request of the batch waits for the whole batch
+		res.responseTime += time.Since(startRequest) * time.Duration(pipeline)
+		res.requests += pipeline
+	}
+
+	return res
+}
+
+func makeNetstring(s string) string {
+	return strconv.Itoa(len(s)) + ":" + s
+}
+
+func getResponseLength(connReader *bufio.Reader) (int, error) {
+	responseLength, err := connReader.ReadString(':')
+	if err != nil {
+		return -1, fmt.Errorf("ERR: Can't read response lentgth. Have read: %s. Error: %s;", responseLength, err)
+	}
+
+	responseLength = responseLength[0 : len(responseLength)-1]
+
+	result, err := strconv.Atoi(responseLength)
+	if err != nil {
+		return -1, fmt.Errorf("ERR: Bad respoonse length Value: %s;", responseLength)
+	}
+
+	return result, nil
+}
+
+func getConfig(args []string) *config {
+	config := &config{protocol: defaultProtocol, addr: defaultAddr}
+
+	var clients string
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&clients, "clients", "100,1000", "comma separated amounts of concurrent clients.")
+	flags.DurationVar(&config.duration, "duration", 10*time.Second, "duration of measurement for every amount of clients.")
+	flags.IntVar(&config.keys, "keys", 10000, "amount of different keys.")
+	flags.IntVar(&config.pipeline, "pipeline", 1, "amount of requests sent at once, before replies are read.")
+	config.tls.AddFlags(flags)
+	flags.Parse(args[1:])
+	args = flags.Args()
+
+	if config.pipeline <= 0 {
+		log.Fatalf("ERR: Bad pipeline: %d;", config.pipeline)
+	}
+
+	for _, s := range strings.Split(clients, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			log.Fatalf("ERR: Bad amount of clients: %s;", s)
+		}
+		config.clients = append(config.clients, n)
+	}
+
+	if len(args) >= 1 {
+		config.addr = args[0]
+	}
+
+	if len(args) >= 2 {
+		config.protocol = args[1]
+	}
+
+	return config
+}
+
+func ifErrFatal(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func logErr(err error) {
+	if err != nil {
+		log.Println(err)
+	}
+}
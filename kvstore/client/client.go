@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"strconv"
+
+	"kvstore/connect"
 )
 
 const (
@@ -18,12 +22,13 @@ const (
 type config struct {
 	protocol string
 	addr     string
+	tls      connect.TLSOptions //connection over TLS
 }
 
 func main() {
 	config := getConfig(os.Args)
 
-	conn, err := net.Dial(config.protocol, config.addr)
+	conn, err := connect.Dial(config.protocol, config.addr, &config.tls)
 
 	ifErrFatal(err)
 	handleConnection(conn)
@@ -33,9 +38,15 @@ func main() {
 
 func handleConnection(conn net.Conn) {
 	withoutResponseCounter := 0
+	//readers live as long as connection, so data they have buffered is not lost
+	stdinReader := bufio.NewReader(os.Stdin)
+	connReader := bufio.NewReader(conn)
 	for {
 
-		request, err := getRequest()
+		request, err := getRequest(stdinReader)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			log.Println(err)
 			continue
@@ -50,8 +61,6 @@ func handleConnection(conn net.Conn) {
 			log.Printf("ERR: %d bytes were written. ERR: %s;", n, err.Error())
 		}
 
-		connReader := bufio.NewReader(conn)
-
 		responseLength, err := getResponseLength(connReader)
 		if err != nil {
 			log.Println(err)
@@ -64,7 +73,7 @@ func handleConnection(conn net.Conn) {
 		}
 		response := make([]byte, responseLength)
 
-		_, err = connReader.Read(response)
+		_, err = io.ReadFull(connReader, response)
 
 		if err != nil {
 			log.Printf("ERR: No response from server: %s;", err.Error())
@@ -79,10 +88,12 @@ func handleConnection(conn net.Conn) {
 	}
 }
 
-func getRequest() (string, error) {
-	reader := bufio.NewReader(os.Stdin)
+func getRequest(reader *bufio.Reader) (string, error) {
 	fmt.Print(">>send: ")
 	request, err := reader.ReadString('\n')
+	if err == io.EOF && request == "" {
+		return "", io.EOF
+	}
 	if err != nil {
 		return "", fmt.Errorf("ERR: STDIN reading problem. Input: %s;", request)
 	}
@@ -111,17 +122,19 @@ func getResponseLength(connReader *bufio.Reader) (int, error) {
 }
 
 func getConfig(args []string) *config {
-	config := &config{defaultProtocol, defaultAddr}
+	config := &config{protocol: defaultProtocol, addr: defaultAddr}
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	config.tls.AddFlags(flags)
+	flags.Parse(args[1:])
+	args = flags.Args()
 
-	if len(args) == 2 {
-		config.addr = args[1]
-		return config
+	if len(args) >= 1 {
+		config.addr = args[0]
 	}
 
-	if len(args) == 3 {
-		config.addr = args[1]
-		config.protocol = args[2]
-		return config
+	if len(args) >= 2 {
+		config.protocol = args[1]
 	}
 
 	return config
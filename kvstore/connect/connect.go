@@ -0,0 +1,57 @@
+//Package connect - connection of command line tools(client, tester, newtester, benchmark) to the server.
+package connect
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+//TLSOptions - settings of connection over TLS.
+type TLSOptions struct {
+	Enabled bool   //connect over TLS
+	CACert  string //PEM CA certificates to verify server with, empty - system ones
+	Cert    string //PEM certificate of client, empty - client has no certificate
+	Key     string //PEM private key of Cert
+}
+
+//AddFlags - defines -tls, -cacert, -cert and -key flags, that set options.
+func (options *TLSOptions) AddFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&options.Enabled, "tls", false, "connect over TLS.")
+	flags.StringVar(&options.CACert, "cacert", "", "PEM CA certificates to verify server with. If not set - system ones.")
+	flags.StringVar(&options.Cert, "cert", "", "PEM certificate of client.")
+	flags.StringVar(&options.Key, "key", "", "PEM private key of -cert.")
+}
+
+//Dial - connects to server at addr, over TLS - if options.Enabled is set.
+func Dial(network, addr string, options *TLSOptions) (net.Conn, error) {
+	if !options.Enabled {
+		return net.Dial(network, addr)
+	}
+
+	tlsConfig := &tls.Config{}
+	if options.CACert != "" {
+		data, err := ioutil.ReadFile(options.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: CAN'T READ CA CERTIFICATE: %s. ERR: %s;", options.CACert, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ERR: No certificates in CA file: %s;", options.CACert)
+		}
+	}
+
+	if options.Cert != "" || options.Key != "" {
+		cert, err := tls.LoadX509KeyPair(options.Cert, options.Key)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: CAN'T LOAD CERTIFICATE: %s, KEY: %s. ERR: %s;", options.Cert, options.Key, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tls.Dial(network, addr, tlsConfig)
+}
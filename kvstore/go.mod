@@ -0,0 +1,8 @@
+module kvstore
+
+go 1.25.0
+
+require (
+	github.com/yuin/gopher-lua v1.1.2
+	golang.org/x/crypto v0.54.0
+)
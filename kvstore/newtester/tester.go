@@ -2,13 +2,16 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"strconv"
 	"sync"
 	"time"
+
+	"kvstore/connect"
 )
 
 //Program to make stresstest to server with database(redis format).
@@ -23,6 +26,7 @@ const (
 type config struct {
 	protocol string
 	addr     string
+	tls      connect.TLSOptions //connection over TLS
 }
 
 //Metrics to save time from start and response time
@@ -124,12 +128,13 @@ func sum(durationSlice []time.Duration) (int, time.Duration) {
 }
 
 func stressTester(config *config, cmd string, metric *Metrics, stopMarker bool) {
-	conn, err := net.Dial(config.protocol, config.addr)
+	conn, err := connect.Dial(config.protocol, config.addr, &config.tls)
 
 	ifErrFatal(err)
 	defer conn.Close()
 
 	start := time.Now()
+	connReader := bufio.NewReader(conn)
 
 	for {
 
@@ -143,15 +148,13 @@ func stressTester(config *config, cmd string, metric *Metrics, stopMarker bool)
 		}
 
 		startRequest := time.Now()
-		connReader := bufio.NewReader(conn)
-
 		responseLength, err := getResponseLength(connReader)
 		if err != nil {
 			break
 		}
 
 		response := make([]byte, responseLength)
-		_, err = connReader.Read(response)
+		_, err = io.ReadFull(connReader, response)
 		if err != nil {
 			break
 		}
@@ -188,17 +191,19 @@ func getResponseLength(connReader *bufio.Reader) (int, error) {
 }
 
 func getConfig(args []string) *config {
-	config := &config{defaultProtocol, defaultAddr}
+	config := &config{protocol: defaultProtocol, addr: defaultAddr}
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	config.tls.AddFlags(flags)
+	flags.Parse(args[1:])
+	args = flags.Args()
 
-	if len(args) == 2 {
-		config.addr = args[1]
-		return config
+	if len(args) >= 1 {
+		config.addr = args[0]
 	}
 
-	if len(args) == 3 {
-		config.addr = args[1]
-		config.protocol = args[2]
-		return config
+	if len(args) >= 2 {
+		config.protocol = args[1]
 	}
 
 	return config
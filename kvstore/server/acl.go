@@ -0,0 +1,676 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//Access control: every client acts as some user. New client is "default" user at once, if that user has no password(nopass),
+//otherwise it has to authenticate with auth first, and only auth is allowed before it.
+//User runs commands of allowed categories and commands allowed one by one(see commandCategory), and only with keys
+//matching its key patterns. Commands without keys(keys, scan, randomkey) return only keys matching them.
+//Commands from scripts are checked with permissions of client, that runs the script.
+//Passwords are kept as bcrypt hashes. Users are described by rules, the same as in acl setuser:
+// on, off - enable/disable user,
+// ><password>, <<password> - add/remove password, #<hash> - add password by its bcrypt hash, nopass - no password needed,
+// resetpass - remove all passwords,
+// +@<category>, -@<category> - allow/deny category(all - every category), +<command>, -<command> - allow/deny command,
+// allcommands, nocommands - the same as +@all, -@all,
+// ~<pattern> - allow keys matching glob-style pattern, allkeys - the same as ~*, resetkeys - forget key patterns,
+// reset - user is off and has no passwords, commands and keys.
+//"default" user may run everything without password, unless the server is started with -requirepass or -acl-default-deny.
+//New users have no permissions until they are granted. Users may be loaded at start from -aclfile: lines like
+//user <name> <rule> <rule> ..., empty lines and lines starting with # are skipped.
+
+const defaultUserName = "default"
+
+//categories of commands
+const (
+	categoryRead        = "read"
+	categoryWrite       = "write"
+	categoryAdmin       = "admin"
+	categoryPubSub      = "pubsub"
+	categoryScripting   = "scripting"
+	categoryTransaction = "transaction"
+	categoryConnection  = "connection"
+	categoryAll         = "all"
+)
+
+var categories = []string{categoryRead, categoryWrite, categoryAdmin, categoryPubSub, categoryScripting, categoryTransaction, categoryConnection}
+
+//errNoAuth - client didn't authenticate, or its user was disabled or deleted
+var errNoAuth = fmt.Errorf("NOAUTH: Authentication required;")
+
+//errWrongPass - auth failed
+var errWrongPass = fmt.Errorf("WRONGPASS: invalid username-password pair or user is disabled;")
+
+/*adminCommands - commands, that read or overwrite files, change configuration, replication or users of the server, or stop it*/
+var adminCommands = map[string]bool{
+	"save":       true,
+	"autosave":   true,
+	"restore":    true,
+	"rewriteaof": true,
+	"showall":    true,
+	"config":     true,
+	"replicaof":  true,
+	"role":       true,
+	"info":       true,
+	"psync":      true,
+	"replconf":   true,
+	"acl":        true,
+	"shutdown":   true,
+}
+
+/*authCommands - commands to authenticate. They are executed by handleConnection, as they change the client's state*/
+var authCommands = map[string]func(*KVCache, *client, *command) (reply, error){
+	//auth - authenticate as user: auth [<user>] <password>. Without user name - as "default" user.
+	"auth": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		if len(cmd.args) != 1 && len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: auth [<user>] <password>. %s;", cmd.name)
+		}
+
+		name, password := defaultUserName, cmd.args[0]
+		if len(cmd.args) == 2 {
+			name, password = cmd.args[0], cmd.args[1]
+		}
+
+		if !KVCache.acl.authenticate(name, password) {
+			log.Printf("LOG: Authentication as %s failed. Client addres: %s;", name, client.conn.RemoteAddr())
+			return nil, errWrongPass
+		}
+
+		client.user = name
+		return okReply{}, nil
+	},
+}
+
+func init() {
+	commands["acl"] = aclCommand
+}
+
+//aclUser - permissions of user.
+type aclUser struct {
+	enabled     bool
+	nopass      bool            //user doesn't need password
+	passwords   []string        //bcrypt hashes of passwords
+	categories  map[string]bool //allowed categories of commands
+	allowed     map[string]bool //commands allowed one by one
+	denied      map[string]bool //commands denied one by one, even if their category is allowed
+	allKeys     bool            //any key is allowed
+	keyPatterns []string        //glob-style patterns of allowed keys
+}
+
+func newACLUser() *aclUser {
+	return &aclUser{categories: make(map[string]bool), allowed: make(map[string]bool), denied: make(map[string]bool)}
+}
+
+//accessControl - users of the server.
+type accessControl struct {
+	Mut   *sync.RWMutex
+	users map[string]*aclUser
+}
+
+//newAccessControl - creates "default" user with all permissions and without password.
+func newAccessControl() *accessControl {
+	//these rules are always valid
+	user, _ := newACLUser().apply([]string{"on", "allcommands", "allkeys", "nopass"})
+
+	return &accessControl{&sync.RWMutex{}, map[string]*aclUser{defaultUserName: user}}
+}
+
+//loadFile - loads users from file with lines: user <name> <rule> <rule> ...
+func (acl *accessControl) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ERR: CAN'T READ ACL FILE: %s. ERR: %s;", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("ERR: ACL FILE %s, LINE %d: should be: user <name> <rule> ...;", path, line)
+		}
+
+		err = acl.setUser(fields[1], fields[2:])
+		if err != nil {
+			return fmt.Errorf("ERR: ACL FILE %s, LINE %d: %s", path, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+//setUser - applies rules to user, creating it if it doesn't exist. Rules are applied all or none.
+//Users are never changed in place, changed copy replaces user. The lock is held from reading user till replacing it,
+//so concurrent setUser calls for the same user don't lose each other's rules.
+func (acl *accessControl) setUser(name string, rules []string) error {
+	acl.Mut.Lock()
+	defer acl.Mut.Unlock()
+
+	user, ok := acl.users[name]
+	if !ok {
+		user = newACLUser()
+	}
+
+	user, err := user.apply(rules)
+	if err != nil {
+		return err
+	}
+
+	acl.users[name] = user
+	return nil
+}
+
+//newClientUser - returns user, that new client acts as: "default", if it needs no password, empty - if client should authenticate.
+func (acl *accessControl) newClientUser() string {
+	acl.Mut.RLock()
+	defer acl.Mut.RUnlock()
+
+	if user, ok := acl.users[defaultUserName]; ok && user.enabled && user.nopass {
+		return defaultUserName
+	}
+	return ""
+}
+
+//enabled - check if user exists and is enabled.
+func (acl *accessControl) enabled(name string) bool {
+	acl.Mut.RLock()
+	defer acl.Mut.RUnlock()
+
+	user, ok := acl.users[name]
+	return ok && user.enabled
+}
+
+//authenticate - check if user is enabled and password is one of its passwords.
+func (acl *accessControl) authenticate(name, password string) bool {
+	acl.Mut.RLock()
+	user, ok := acl.users[name]
+	if !ok || !user.enabled {
+		acl.Mut.RUnlock()
+		return false
+	}
+	nopass, hashes := user.nopass, user.passwords
+	acl.Mut.RUnlock()
+
+	if nopass {
+		return true
+	}
+
+	//hashes are compared without the lock, as bcrypt is slow by design
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+//check - check if user may run command with its keys. Empty user - client is not authenticated.
+func (acl *accessControl) check(name string, cmd *command) error {
+	cmdName := strings.ToLower(cmd.name)
+	if alias, ok := aliases[cmdName]; ok {
+		cmdName = alias
+	}
+
+	if _, ok := authCommands[cmdName]; ok {
+		return nil
+	}
+
+	if name == "" {
+		return errNoAuth
+	}
+
+	acl.Mut.RLock()
+	defer acl.Mut.RUnlock()
+
+	user, ok := acl.users[name]
+	if !ok || !user.enabled {
+		return errNoAuth
+	}
+
+	if !user.canRun(cmdName) {
+		return fmt.Errorf("NOPERM: User %s has no permissions to run the '%s' command;", name, cmdName)
+	}
+
+	if user.allKeys {
+		return nil
+	}
+
+	for _, key := range commandKeys(&command{cmdName, cmd.args}) {
+		if !user.canAccess(key) {
+			return fmt.Errorf("NOPERM: User %s has no permissions to access key: %s;", name, key)
+		}
+	}
+
+	return nil
+}
+
+//keyFilter - returns function, that checks if user may access key. Users are never changed in place,
+//so the function uses user without the lock. Missing or disabled user may access no keys.
+func (acl *accessControl) keyFilter(name string) func(key string) bool {
+	acl.Mut.RLock()
+	user, ok := acl.users[name]
+	acl.Mut.RUnlock()
+
+	if !ok || !user.enabled {
+		return func(string) bool { return false }
+	}
+	if user.allKeys {
+		return func(string) bool { return true }
+	}
+	return user.canAccess
+}
+
+func (user *aclUser) canRun(name string) bool {
+	if user.denied[name] {
+		return false
+	}
+
+	return user.allowed[name] || user.categories[commandCategory(name)]
+}
+
+func (user *aclUser) canAccess(key string) bool {
+	for _, pattern := range user.keyPatterns {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+//commandCategory - returns category of command.
+func commandCategory(name string) string {
+	if adminCommands[name] {
+		return categoryAdmin
+	}
+	if _, ok := pubsubCommands[name]; ok || name == "publish" {
+		return categoryPubSub
+	}
+	if name == "eval" || name == "evalsha" || name == "script" {
+		return categoryScripting
+	}
+	if _, ok := transactionCommands[name]; ok {
+		return categoryTransaction
+	}
+	if name == "ping" || name == "echo" {
+		return categoryConnection
+	}
+	if _, ok := blockingCommands[name]; ok || writeCommands[name] {
+		return categoryWrite
+	}
+	return categoryRead
+}
+
+//commandNames - returns names of all commands, that are checked by access control.
+func commandNames() map[string]bool {
+	names := make(map[string]bool)
+	for name := range commands {
+		names[name] = true
+	}
+	for name := range pubsubCommands {
+		names[name] = true
+	}
+	for name := range replicationCommands {
+		names[name] = true
+	}
+	for name := range transactionCommands {
+		names[name] = true
+	}
+	return names
+}
+
+//apply - returns copy of user with rules applied, or error if any of rules is invalid.
+func (user *aclUser) apply(rules []string) (*aclUser, error) {
+	result := &aclUser{user.enabled, user.nopass, append([]string{}, user.passwords...), copySet(user.categories),
+		copySet(user.allowed), copySet(user.denied), user.allKeys, append([]string{}, user.keyPatterns...)}
+
+	for _, rule := range rules {
+		lower := strings.ToLower(rule)
+
+		switch {
+		case lower == "on":
+			result.enabled = true
+		case lower == "off":
+			result.enabled = false
+
+		case lower == "nopass":
+			result.nopass = true
+			result.passwords = nil
+		case lower == "resetpass":
+			result.nopass = false
+			result.passwords = nil
+
+		case strings.HasPrefix(rule, ">"):
+			hash, err := bcrypt.GenerateFromPassword([]byte(rule[1:]), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("ERR: Can't hash password: %s;", err)
+			}
+			result.nopass = false
+			result.passwords = append(result.passwords, string(hash))
+
+		case strings.HasPrefix(rule, "#"):
+			if _, err := bcrypt.Cost([]byte(rule[1:])); err != nil {
+				return nil, fmt.Errorf("ERR: Invalid password hash: %s. Should be bcrypt hash;", rule[1:])
+			}
+			result.nopass = false
+			result.passwords = append(result.passwords, rule[1:])
+
+		case strings.HasPrefix(rule, "<"):
+			passwords := result.passwords[:0]
+			for _, hash := range result.passwords {
+				if bcrypt.CompareHashAndPassword([]byte(hash), []byte(rule[1:])) != nil {
+					passwords = append(passwords, hash)
+				}
+			}
+			result.passwords = passwords
+
+		case lower == "allkeys":
+			result.allKeys = true
+		case lower == "resetkeys":
+			result.allKeys = false
+			result.keyPatterns = nil
+		case strings.HasPrefix(rule, "~"):
+			if rule == "~*" {
+				result.allKeys = true
+			} else {
+				result.keyPatterns = append(result.keyPatterns, rule[1:])
+			}
+
+		case lower == "allcommands":
+			result.setCategory(categoryAll, true)
+		case lower == "nocommands":
+			result.setCategory(categoryAll, false)
+		case strings.HasPrefix(lower, "+@"), strings.HasPrefix(lower, "-@"):
+			category := lower[2:]
+			if category != categoryAll && !contains(categories, category) {
+				return nil, fmt.Errorf("ERR: Unknown category: %s. Should be one of: %s, %s;", category, categoryAll, strings.Join(categories, ", "))
+			}
+			result.setCategory(category, lower[0] == '+')
+
+		case strings.HasPrefix(lower, "+"), strings.HasPrefix(lower, "-"):
+			name := lower[1:]
+			if !commandNames()[name] {
+				return nil, fmt.Errorf("ERR: Unknown command: %s;", name)
+			}
+			if lower[0] == '+' {
+				result.allowed[name] = true
+				delete(result.denied, name)
+			} else {
+				result.denied[name] = true
+				delete(result.allowed, name)
+			}
+
+		case lower == "reset":
+			result = newACLUser()
+
+		default:
+			return nil, fmt.Errorf("ERR: Syntax error in ACL rule: %s;", rule)
+		}
+	}
+
+	return result, nil
+}
+
+//setCategory - allows or denies category(or all of them). Commands of category allowed or denied one by one are forgotten,
+//so the later rule wins.
+func (user *aclUser) setCategory(category string, allow bool) {
+	for _, c := range categories {
+		if category != categoryAll && c != category {
+			continue
+		}
+
+		user.categories[c] = allow
+		for name := range user.allowed {
+			if commandCategory(name) == c {
+				delete(user.allowed, name)
+			}
+		}
+		for name := range user.denied {
+			if commandCategory(name) == c {
+				delete(user.denied, name)
+			}
+		}
+	}
+}
+
+//commandRules - describes allowed commands as rules: categories, then commands allowed and denied one by one.
+func (user *aclUser) commandRules() []string {
+	allowed := make([]string, 0, len(categories))
+	for _, c := range categories {
+		if user.categories[c] {
+			allowed = append(allowed, "+@"+c)
+		}
+	}
+
+	rules := []string{"-@" + categoryAll}
+	if len(allowed) == len(categories) {
+		rules = []string{"+@" + categoryAll}
+	} else {
+		rules = append(rules, allowed...)
+	}
+
+	for _, name := range sortedNames(user.allowed) {
+		rules = append(rules, "+"+name)
+	}
+	for _, name := range sortedNames(user.denied) {
+		rules = append(rules, "-"+name)
+	}
+
+	return rules
+}
+
+//rules - describes user as rules, that create the same user.
+func (user *aclUser) rules() []string {
+	rules := []string{"off"}
+	if user.enabled {
+		rules[0] = "on"
+	}
+
+	if user.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range user.passwords {
+		rules = append(rules, "#"+hash)
+	}
+
+	if user.allKeys {
+		rules = append(rules, "~*")
+	}
+	for _, pattern := range user.keyPatterns {
+		rules = append(rules, "~"+pattern)
+	}
+
+	return append(rules, user.commandRules()...)
+}
+
+//aclCommand - manage users:
+//acl setuser <user> [<rule> ...] - create user or change it by rules.
+//acl getuser <user> - return flags, password hashes, commands and key patterns of user.
+//acl list - return users as rules, that create them.
+//acl deluser <user> [<user> ...] - delete users. Return amount of deleted users. "default" user can't be deleted.
+//acl cat [<category>] - return categories, or commands of category.
+func aclCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	if len(cmd.args) == 0 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: acl setuser|getuser|list|deluser|cat. %s;", cmd)
+	}
+
+	acl := KVCache.acl
+	switch strings.ToLower(cmd.args[0]) {
+	case "setuser":
+		if len(cmd.args) < 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: acl setuser <user> [<rule> ...]. %s;", cmd)
+		}
+
+		err := acl.setUser(cmd.args[1], cmd.args[2:])
+		if err != nil {
+			return nil, err
+		}
+		return okReply{}, nil
+
+	case "getuser":
+		if len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: acl getuser <user>. %s;", cmd)
+		}
+
+		acl.Mut.RLock()
+		defer acl.Mut.RUnlock()
+
+		user, ok := acl.users[cmd.args[1]]
+		if !ok {
+			return nil, &nilError{fmt.Sprintf("ERR: No such user: %s;", cmd.args[1])}
+		}
+
+		flags := arrayReply{bulkReply("off")}
+		if user.enabled {
+			flags[0] = bulkReply("on")
+		}
+		if user.nopass {
+			flags = append(flags, bulkReply("nopass"))
+		}
+		if user.allKeys {
+			flags = append(flags, bulkReply("allkeys"))
+		}
+
+		passwords := arrayReply{}
+		for _, hash := range user.passwords {
+			passwords = append(passwords, bulkReply(hash))
+		}
+
+		keys := arrayReply{}
+		for _, pattern := range user.keyPatterns {
+			keys = append(keys, bulkReply(pattern))
+		}
+
+		return arrayReply{bulkReply("flags"), flags, bulkReply("passwords"), passwords,
+			bulkReply("commands"), bulkReply(strings.Join(user.commandRules(), " ")), bulkReply("keys"), keys}, nil
+
+	case "list":
+		if len(cmd.args) != 1 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: acl list. %s;", cmd)
+		}
+
+		acl.Mut.RLock()
+		defer acl.Mut.RUnlock()
+
+		names := make([]string, 0, len(acl.users))
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		result := make(arrayReply, len(names))
+		for i, name := range names {
+			result[i] = bulkReply("user " + name + " " + strings.Join(acl.users[name].rules(), " "))
+		}
+		return result, nil
+
+	case "deluser":
+		if len(cmd.args) < 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: acl deluser <user> [<user> ...]. %s;", cmd)
+		}
+
+		acl.Mut.Lock()
+		defer acl.Mut.Unlock()
+
+		counter := 0
+		for _, name := range cmd.args[1:] {
+			if name == defaultUserName {
+				return nil, fmt.Errorf("ERR: The 'default' user cannot be removed;")
+			}
+		}
+		for _, name := range cmd.args[1:] {
+			if _, ok := acl.users[name]; ok {
+				delete(acl.users, name)
+				counter++
+			}
+		}
+		return intReply(counter), nil
+
+	case "cat":
+		if len(cmd.args) == 1 {
+			result := make(arrayReply, len(categories))
+			for i, c := range categories {
+				result[i] = bulkReply(c)
+			}
+			return result, nil
+		}
+
+		if len(cmd.args) != 2 || !contains(categories, strings.ToLower(cmd.args[1])) {
+			return nil, fmt.Errorf("ERR: Unknown category or invalid number of arguments. Should be: acl cat [<category>]. %s;", cmd)
+		}
+
+		names := make(map[string]bool)
+		for name := range commandNames() {
+			if commandCategory(name) == strings.ToLower(cmd.args[1]) {
+				names[name] = true
+			}
+		}
+
+		result := arrayReply{}
+		for _, name := range sortedNames(names) {
+			result = append(result, bulkReply(name))
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("ERR: Unknown subcommand: %s. Should be setuser, getuser, list, deluser or cat. %s;", cmd.args[0], cmd)
+}
+
+//loggedCommand - returns command to write to log: passwords of auth and acl setuser are hidden.
+func loggedCommand(cmd *command) *command {
+	switch strings.ToLower(cmd.name) {
+	case "auth":
+		return &command{cmd.name, []string{"(hidden)"}}
+
+	case "acl":
+		args := make([]string, len(cmd.args))
+		for i, arg := range cmd.args {
+			args[i] = arg
+			if i >= 2 && (strings.HasPrefix(arg, ">") || strings.HasPrefix(arg, "<")) {
+				args[i] = arg[:1] + "(hidden)"
+			}
+		}
+		return &command{cmd.name, args}
+	}
+
+	return cmd
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	result := make(map[string]bool, len(set))
+	for k, v := range set {
+		result[k] = v
+	}
+	return result
+}
+
+func sortedNames(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func contains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//newTestACL - returns access control with users:
+//reader - reads keys pub*, writer - all commands except del on all keys, disabled - disabled user with all permissions.
+func newTestACL(t *testing.T) *accessControl {
+	t.Helper()
+
+	acl := newAccessControl()
+	users := map[string][]string{
+		"reader":   {"on", "nopass", "+@read", "~pub*"},
+		"writer":   {"on", "nopass", "allcommands", "-del", "allkeys"},
+		"disabled": {"off", "nopass", "allcommands", "allkeys"},
+	}
+	for name, rules := range users {
+		err := acl.setUser(name, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return acl
+}
+
+func TestACLCheck(t *testing.T) {
+	acl := newTestACL(t)
+
+	tests := []struct {
+		user string
+		args []string
+		want string //prefix of error, empty - command is allowed
+	}{
+		{"", []string{"get", "pub1"}, "NOAUTH"},
+		{"", []string{"auth", "secret"}, ""},
+		{"ghost", []string{"get", "pub1"}, "NOAUTH"},
+		{"disabled", []string{"get", "pub1"}, "NOAUTH"},
+		{"default", []string{"flushall"}, ""},
+		{"reader", []string{"get", "pub1"}, ""},
+		{"reader", []string{"GET", "pub1"}, ""},
+		{"reader", []string{"get", "secret"}, "NOPERM"},
+		{"reader", []string{"exists", "pub1", "secret"}, "NOPERM"},
+		{"reader", []string{"set", "pub1", "v"}, "NOPERM"},
+		{"reader", []string{"eval", "return 1", "0"}, "NOPERM"},
+		{"reader", []string{"keys", "*"}, ""},
+		{"reader", []string{"scan", "0"}, ""},
+		{"writer", []string{"set", "k", "v"}, ""},
+		{"writer", []string{"expire", "k", "10"}, ""},
+		{"writer", []string{"del", "k"}, "NOPERM"},
+	}
+
+	for _, test := range tests {
+		err := acl.check(test.user, &command{test.args[0], test.args[1:]})
+		if test.want == "" && err != nil {
+			t.Errorf("%s %v: unexpected error: %s", test.user, test.args, err)
+		}
+		if test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), test.want)) {
+			t.Errorf("%s %v: got error %v, want %s", test.user, test.args, err, test.want)
+		}
+	}
+
+	//commands without keys return only keys, that user may access
+	rc := newTestCache(t)
+	rc.acl = acl
+	for _, key := range []string{"pub1", "pub2", "secret1", "secret2", "secret3"} {
+		_, err := run(t, rc, "set", key, "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := acl.setUser("reader", []string{"+eval"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := &client{user: "reader"}
+	listed := func(args ...string) []string {
+		t.Helper()
+
+		result, err := getResponse(reader, &command{args[0], args[1:]}, rc)
+		if _, ok := err.(*nilError); ok {
+			return nil
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+		if r, ok := result.(arrayReply); ok && args[0] == "scan" {
+			result = r[1]
+		}
+		if r, ok := result.(bulkReply); ok {
+			return []string{string(r)}
+		}
+		return replyStrings(result)
+	}
+
+	for _, args := range [][]string{
+		{"keys", "*"},
+		{"scan", "0", "count", "1000"},
+		{"randomkey"},
+		{"eval", "return redis.call('keys', '*')", "0"},
+	} {
+		for i := 0; i < 10; i++ {
+			for _, key := range listed(args...) {
+				if !strings.HasPrefix(key, "pub") {
+					t.Errorf("%v of reader returned key %s", args, key)
+				}
+			}
+		}
+	}
+	if keys := listed("keys", "*"); len(keys) != 2 {
+		t.Errorf("keys * of reader: %v, want pub1 and pub2", keys)
+	}
+
+	err = acl.setUser("reader", []string{"resetkeys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := listed("randomkey"); len(keys) != 0 {
+		t.Errorf("randomkey of reader without keys: %v", keys)
+	}
+}
+
+func TestACLSetUserAllOrNone(t *testing.T) {
+	acl := newTestACL(t)
+
+	err := acl.setUser("reader", []string{"+set", "+nosuchcommand"})
+	if err == nil {
+		t.Fatal("expected error for unknown command")
+	}
+	if acl.check("reader", &command{"set", []string{"pub1", "v"}}) == nil {
+		t.Error("rules before invalid one were applied")
+	}
+}
+
+//TestExecScriptRunsAsCaller - scripts queued in transaction run commands as the client, that calls exec,
+//not as the client, that ran the last script.
+func TestExecScriptRunsAsCaller(t *testing.T) {
+	rc := newTestCache(t)
+	rc.acl = newTestACL(t)
+	err := rc.acl.setUser("reader", []string{"+eval"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := &client{user: "reader", watched: make(map[string]int64)}
+	exec := func(script string) reply {
+		t.Helper()
+
+		for _, cmd := range []*command{{"multi", nil}, {"eval", []string{script, "0"}}} {
+			if executor, ok := transactionCommands[cmd.name]; ok {
+				_, err = executor(rc, reader, cmd)
+			} else {
+				_, err = reader.tx.enqueue(cmd)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := transactionCommands["exec"](rc, reader, &command{"exec", nil})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(arrayReply)[0]
+	}
+
+	//no script was run before
+	result := exec("return redis.call('get', 'pub1')")
+	if result != nil {
+		t.Errorf("get from the first script: got %#v, want nil", result)
+	}
+
+	admin := &client{user: defaultUserName, watched: make(map[string]int64)}
+	_, err = getResponse(admin, &command{"eval", []string{"return redis.call('set', 'pub1', 'v')", "0"}}, rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result = exec("return redis.call('set', 'pub1', 'changed')")
+	if r, ok := result.(errorReply); !ok || !strings.Contains(r.err.Error(), "NOPERM") {
+		t.Errorf("set from script of reader: got %#v, want NOPERM error", result)
+	}
+
+	result = exec("return redis.call('get', 'pub1')")
+	if result != bulkReply("v") {
+		t.Errorf("get from script of reader: got %#v, want %q", result, "v")
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fsyncAlways   = "always"   //fsync after every record
+	fsyncEverySec = "everysec" //fsync once per second
+	fsyncNo       = "no"       //leave flushing to the operating system
+
+	defaultFsyncPolicy = fsyncEverySec
+
+	//aofRewriteMinSize - the log is not rewritten automatically until it grows past this size
+	aofRewriteMinSize = 1 << 20
+)
+
+//writeCommands - commands that may modify the database. They are propagated while shards of their keys are still locked,
+//so records about the same key in the append-only log have the same order, as commands were applied in.
+var writeCommands = map[string]bool{
+	"set":      true,
+	"getset":   true,
+	"del":      true,
+	"ex":       true,
+	"expireat": true,
+	"restore":  true,
+}
+
+//appendOnlyLog - file, where every command that modified the database is appended to.
+type appendOnlyLog struct {
+	Mut         *sync.Mutex
+	path        string
+	file        *os.File
+	fsync       string        //fsync policy: always, everysec or no
+	unsynced    bool          //there are records written after the last fsync
+	size        int64         //current size of the log
+	baseSize    int64         //size of the log right after the last rewrite
+	rewriteBuf  [][]byte      //records appended while rewrite is in progress
+	rewriteDone chan struct{} //closed when current rewrite is finished, nil - if there is no rewrite
+	closed      bool          //the log is closed on shutdown, nothing is appended anymore
+	stop        chan struct{} //closed, when syncLoop should return
+	errors      int64         //failed writes and fsyncs, changed atomically
+}
+
+//openAppendOnlyLog - opens (creates if not exist) log file to append records to it.
+func openAppendOnlyLog(path, fsync string) (*appendOnlyLog, error) {
+	err := validateFsyncPolicy(fsync)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: CAN'T OPEN APPEND-ONLY LOG: %s. ERR: %s;", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ERR: CAN'T OPEN APPEND-ONLY LOG: %s. ERR: %s;", path, err)
+	}
+
+	aof := &appendOnlyLog{Mut: &sync.Mutex{}, path: path, file: file, fsync: fsync, size: info.Size(), baseSize: info.Size(),
+		stop: make(chan struct{})}
+
+	go aof.syncLoop()
+
+	return aof, nil
+}
+
+func validateFsyncPolicy(fsync string) error {
+	if fsync != fsyncAlways && fsync != fsyncEverySec && fsync != fsyncNo {
+		return fmt.Errorf("ERR: Unknown fsync policy: %s. Should be one of: %s, %s, %s;", fsync, fsyncAlways, fsyncEverySec, fsyncNo)
+	}
+	return nil
+}
+
+//setFsync - changes fsync policy. Records written before are fsynced, if the policy becomes always.
+func (aof *appendOnlyLog) setFsync(fsync string) error {
+	err := validateFsyncPolicy(fsync)
+	if err != nil {
+		return err
+	}
+
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	if fsync == fsyncAlways && aof.unsynced && !aof.closed {
+		aof.sync()
+		aof.unsynced = false
+	}
+	aof.fsync = fsync
+	return nil
+}
+
+//state - returns fsync policy, current size of the log, its size after the last rewrite and whether rewrite is in progress.
+func (aof *appendOnlyLog) state() (string, int64, int64, bool) {
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	return aof.fsync, aof.size, aof.baseSize, aof.rewriteDone != nil
+}
+
+//sync - fsyncs the log, errors are logged and counted. Must be called with aof.Mut locked.
+func (aof *appendOnlyLog) sync() {
+	err := aof.file.Sync()
+	if err != nil {
+		log.Println(err)
+		atomic.AddInt64(&aof.errors, 1)
+	}
+}
+
+//append - writes command to the end of the log.
+func (aof *appendOnlyLog) append(cmd *command) {
+	record := encodeCommand(cmd)
+
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	if aof.closed {
+		log.Printf("ERR: APPEND-ONLY LOG IS CLOSED. %s", cmd)
+		return
+	}
+
+	n, err := aof.file.Write(record)
+	aof.size += int64(n)
+	if err != nil {
+		log.Printf("ERR: APPEND-ONLY LOG WRITING ERR: %s. %s", err, cmd)
+		atomic.AddInt64(&aof.errors, 1)
+		return
+	}
+
+	if aof.rewriteDone != nil {
+		aof.rewriteBuf = append(aof.rewriteBuf, record)
+	}
+
+	if aof.fsync == fsyncAlways {
+		aof.sync()
+		return
+	}
+	aof.unsynced = true
+}
+
+//syncLoop - fsyncs the log once per second, if anything was written to it and fsync policy is everysec.
+func (aof *appendOnlyLog) syncLoop() {
+	for {
+		select {
+		case <-time.After(time.Second):
+		case <-aof.stop:
+			return
+		}
+
+		aof.Mut.Lock()
+		if aof.fsync == fsyncEverySec && aof.unsynced && !aof.closed {
+			aof.sync()
+			aof.unsynced = false
+		}
+		aof.Mut.Unlock()
+	}
+}
+
+//close - waits for rewrite in progress, fsyncs and closes the log.
+func (aof *appendOnlyLog) close() error {
+	aof.waitRewrite()
+	close(aof.stop)
+
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	aof.closed = true
+	err := aof.file.Sync()
+	if closeErr := aof.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ERR: CAN'T CLOSE APPEND-ONLY LOG: %s. ERR: %s;", aof.path, err)
+	}
+
+	return nil
+}
+
+//needsRewrite - check if log has doubled its size since the last rewrite and should be compacted.
+func (aof *appendOnlyLog) needsRewrite() bool {
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	return aof.rewriteDone == nil && aof.size > aofRewriteMinSize && aof.size > 2*aof.baseSize
+}
+
+//waitRewrite - blocks until rewrite in progress (if any) is finished.
+func (aof *appendOnlyLog) waitRewrite() {
+	aof.Mut.Lock()
+	done := aof.rewriteDone
+	aof.Mut.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+//rewrite - replaces the log in background with the minimal set of commands, that recreates current database.
+//Must be called with KVCache.txMut held exclusively, so no command modifies the database while it is copied.
+//Result of the rewrite is sent to returned channel.
+func (aof *appendOnlyLog) rewrite(KVCache *KVCache) <-chan error {
+	result := make(chan error, 1)
+
+	aof.Mut.Lock()
+	if aof.rewriteDone != nil {
+		aof.Mut.Unlock()
+		result <- fmt.Errorf("ERR: Rewrite of the append-only log is already in progress;")
+		return result
+	}
+	aof.rewriteDone = make(chan struct{})
+	aof.rewriteBuf = nil
+	aof.Mut.Unlock()
+
+	start := time.Now()
+	dataset := datasetCommands(KVCache)
+
+	go func() {
+		err := aof.writeRewrite(dataset)
+		KVCache.stats.recordRewrite(time.Since(start), err)
+
+		aof.Mut.Lock()
+		close(aof.rewriteDone)
+		aof.rewriteDone = nil
+		aof.rewriteBuf = nil
+		aof.Mut.Unlock()
+
+		if err != nil {
+			log.Println(err)
+		} else {
+			log.Printf("LOG: Append-only log rewritten: %s;", aof.path)
+		}
+		result <- err
+	}()
+
+	return result
+}
+
+//writeRewrite - writes dataset to temporary file, then appends to it records, made while it was written,
+//and atomically replaces the log with it.
+func (aof *appendOnlyLog) writeRewrite(dataset []*command) error {
+	tmpPath := aof.path + ".rewrite"
+
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("ERR: AOF REWRITE. CAN'T CREATE FILE: %s. ERR: %s;", tmpPath, err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, cmd := range dataset {
+		writer.Write(encodeCommand(cmd))
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("ERR: AOF REWRITE. WRITING TO FILE ERR: %s;", err)
+	}
+
+	aof.Mut.Lock()
+	defer aof.Mut.Unlock()
+
+	for _, record := range aof.rewriteBuf {
+		_, err = tmp.Write(record)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, aof.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("ERR: AOF REWRITE. REPLACING LOG ERR: %s;", err)
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("ERR: AOF REWRITE. ERR: %s;", err)
+	}
+
+	aof.file.Close()
+	aof.file = tmp
+	aof.size = info.Size()
+	aof.baseSize = aof.size
+	aof.unsynced = false
+	return nil
+}
+
+//datasetCommands - returns commands, that recreate current database from scratch.
+func datasetCommands(KVCache *KVCache) []*command {
+	unlock := KVCache.lockAll(false)
+	defer unlock()
+
+	dataset := make([]*command, 0)
+	for _, shard := range KVCache.shards {
+		for key, value := range shard.data {
+			switch value.Type {
+			case typeHash:
+				args := make([]string, 0, 1+2*len(value.Hash))
+				args = append(args, key)
+				for field, fieldValue := range value.Hash {
+					args = append(args, field, fieldValue)
+				}
+				dataset = append(dataset, &command{"hset", args})
+
+			case typeList:
+				dataset = append(dataset, &command{"rpush", append([]string{key}, value.List.items()...)})
+
+			case typeSet:
+				args := make([]string, 0, 1+len(value.Set))
+				args = append(args, key)
+				for member := range value.Set {
+					args = append(args, member)
+				}
+				dataset = append(dataset, &command{"sadd", args})
+
+			case typeSortedSet:
+				args := make([]string, 0, 1+2*value.ZSet.list.length)
+				args = append(args, key)
+				for node := value.ZSet.list.header.level[0].forward; node != nil; node = node.level[0].forward {
+					args = append(args, formatScore(node.score), node.member)
+				}
+				dataset = append(dataset, &command{"zadd", args})
+
+			default:
+				dataset = append(dataset, &command{"set", []string{key, value.Value}})
+			}
+
+			if deadline, ok := shard.expKeys.expirationOf(key); ok && value.ExpireIsSet {
+				dataset = append(dataset, &command{"pexpireat", []string{key, formatUnixMilli(deadline)}})
+			}
+		}
+	}
+
+	return dataset
+}
+
+//propagate - writes command, that has just modified the database, to the append-only log and to replicas.
+//result - reply of the command. Must be called with shards of command's keys locked exclusively.
+func propagate(KVCache *KVCache, cmd *command, result reply) {
+	switch cmd.name {
+	case "ex", "pexpire":
+		//relative expiration is logged as absolute one, so replay doesn't prolong key's life.
+		//If there is no expiration date, time was 0 and key is deleted.
+		if deadline, ok := KVCache.expirationOf(cmd.args[0]); ok {
+			cmd = &command{"pexpireat", []string{cmd.args[0], formatUnixMilli(deadline)}}
+		} else {
+			cmd = &command{"del", []string{cmd.args[0]}}
+		}
+
+	case "set", "incrbyfloat":
+		//options are logged as their result: value with absolute expiration date, if key has it.
+		//Result of floating point increment is logged as is, so replay doesn't accumulate rounding differently.
+		args := []string{cmd.args[0], cmd.args[1]}
+		if cmd.name == "incrbyfloat" {
+			args[1] = string(result.(bulkReply))
+		}
+		if deadline, ok := KVCache.expirationOf(cmd.args[0]); ok {
+			args = append(args, "pxat", formatUnixMilli(deadline))
+		}
+		cmd = &command{"set", args}
+
+	case "getex":
+		//the key got absolute expiration date, lost it, or was deleted, as the date has passed
+		if _, ok := KVCache.lookup(cmd.args[0]); !ok {
+			cmd = &command{"del", []string{cmd.args[0]}}
+		} else if deadline, ok := KVCache.expirationOf(cmd.args[0]); ok {
+			cmd = &command{"pexpireat", []string{cmd.args[0], formatUnixMilli(deadline)}}
+		} else {
+			cmd = &command{"persist", []string{cmd.args[0]}}
+		}
+
+	case "spop":
+		//random choice would differ on replay, so removed members are logged explicitly
+		cmd = &command{"srem", append([]string{cmd.args[0]}, replyStrings(result)...)}
+
+	case "restore":
+		//the whole database was replaced from file, so the log is rebuilt instead of referring to that file,
+		//and replicas resync from scratch.
+		if KVCache.aof != nil {
+			KVCache.aof.waitRewrite()
+			logErr(<-KVCache.aof.rewrite(KVCache))
+		}
+		KVCache.repl.dropReplicas()
+		return
+	}
+
+	KVCache.repl.feed(cmd)
+
+	if KVCache.aof == nil {
+		return
+	}
+
+	KVCache.aof.append(cmd)
+}
+
+//aofRewriteCron - once per second starts rewrite of the append-only log, if it has grown too much.
+//Command, that has grown the log, can't start it, as the database is copied with KVCache.txMut held exclusively.
+func (KVCache *KVCache) aofRewriteCron() {
+	for KVCache.shutdown.sleep(time.Second) {
+		if KVCache.aof.needsRewrite() {
+			KVCache.txMut.Lock()
+			KVCache.aof.rewrite(KVCache)
+			KVCache.txMut.Unlock()
+		}
+	}
+}
+
+func replayAppendOnlyLog(KVCache *KVCache, path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ERR: CAN'T READ APPEND-ONLY LOG: %s. ERR: %s;", path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	counter := 0
+
+	for {
+		cmd, err := decodeCommand(reader)
+		if err == io.EOF {
+			return counter, nil
+		}
+
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("LOG: Append-only log %s ends with incomplete record, truncating it to %d bytes;", path, offset)
+			return counter, os.Truncate(path, offset)
+		}
+
+		if err != nil {
+			return counter, fmt.Errorf("ERR: APPEND-ONLY LOG %s IS DAMAGED AT OFFSET %d. ERR: %s;", path, offset, err)
+		}
+
+		executor, ok := commands[cmd.name]
+		if !ok {
+			return counter, fmt.Errorf("ERR: APPEND-ONLY LOG %s HAS UNKNOWN COMMAND AT OFFSET %d. %s", path, offset, cmd)
+		}
+
+		//errors are not the reason to stop: commands like getset report error, but still modify the database.
+		executor(KVCache, cmd)
+		KVCache.account(commandKeys(cmd))
+
+		offset += int64(len(encodeCommand(cmd)))
+		counter++
+	}
+}
+
+//encodeCommand - encodes command to log record: netstring with amount of arguments(including the name),
+//netstrings of name and each argument, and new line.
+func encodeCommand(cmd *command) []byte {
+	record := makeNetstring(strconv.Itoa(len(cmd.args) + 1))
+	record += makeNetstring(cmd.name)
+	for _, arg := range cmd.args {
+		record += makeNetstring(arg)
+	}
+
+	return []byte(record + "\n")
+}
+
+//decodeCommand - reads single record made by encodeCommand.
+//Returns io.EOF - if there are no more records, error wrapping io.ErrUnexpectedEOF - if record is incomplete.
+func decodeCommand(reader *bufio.Reader) (*command, error) {
+	if _, err := reader.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+
+	count, err := readNetstring(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 || n > maxRESPArgs {
+		return nil, fmt.Errorf("bad amount of arguments: %q", count)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		args[i], err = readNetstring(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	end, err := reader.ReadByte()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if end != '\n' {
+		return nil, fmt.Errorf("record is not terminated by new line")
+	}
+
+	return &command{args[0], args[1:]}, nil
+}
+
+//readNetstring - reads single netstring and returns its content.
+func readNetstring(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadString(':')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+
+	n, err := strconv.Atoi(length[:len(length)-1])
+	if err != nil || n < 0 || n > maxRESPBulkBytes {
+		return "", fmt.Errorf("bad netstring length: %q", length)
+	}
+
+	//memory is allocated as data is read, so damaged length at the end of the file doesn't allocate it in advance
+	var data strings.Builder
+	_, err = io.CopyN(&data, reader, int64(n))
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return data.String(), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//newTestLog - returns database, that logs commands to append-only log in temporary directory, and path of the log.
+func newTestLog(t *testing.T) (*KVCache, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "appendonly.log")
+	rc := newTestCache(t)
+
+	var err error
+	rc.aof, err = openAppendOnlyLog(path, fsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc, path
+}
+
+//replay - returns new database with commands from append-only log at path and amount of them.
+func replay(t *testing.T, path string) (*KVCache, int) {
+	t.Helper()
+
+	rc := newTestCache(t)
+	n, err := replayAppendOnlyLog(rc, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc, n
+}
+
+func TestAppendOnlyLogReplay(t *testing.T) {
+	rc, path := newTestLog(t)
+	fill(t, rc)
+	for _, args := range [][]string{
+		{"set", "getset", "old"},
+		{"getset", "getset", "v"},
+		{"set", "at", "v"},
+		{"expireat", "at", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		{"set", "expired", "v"},
+		{"expireat", "expired", "1"},
+		{"hdel", "hash", "f1"},
+		{"lpop", "list"},
+		{"spop", "set"},
+		{"zrem", "zset", "b"},
+		{"ex", "string", "1000"},
+		{"persist", "string"},
+		{"pexpire", "hash", "100000"},
+		{"incr", "counter"},
+		{"incrby", "counter", "10"},
+		{"incrbyfloat", "float", "0.1"},
+		{"incrbyfloat", "float", "0.2"},
+		{"get", "string"},
+	} {
+		_, err := run(t, rc, args...)
+		if err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+	want := dump(t, rc)
+
+	replayed, _ := replay(t, path)
+	got := dump(t, replayed)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed database:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestAppendOnlyLogRewrite(t *testing.T) {
+	rc, path := newTestLog(t)
+	fill(t, rc)
+	for i := 0; i < 100; i++ {
+		_, err := run(t, rc, "set", "counter", strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, logged := replay(t, path)
+
+	rc.txMut.Lock()
+	result := rc.aof.rewrite(rc)
+	rc.txMut.Unlock()
+	err := <-result
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//commands after rewrite are appended to the new log
+	_, err = run(t, rc, "set", "after", "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dump(t, rc)
+
+	replayed, n := replay(t, path)
+	if n >= logged {
+		t.Errorf("rewritten log has %d commands, original one had %d", n, logged)
+	}
+	got := dump(t, replayed)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed database:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestAppendOnlyLogIncompleteRecord(t *testing.T) {
+	record := encodeCommand(&command{"set", []string{"k", "changed"}})
+	tails := map[string][]byte{
+		"incomplete record":      record[:len(record)-3],
+		"length beyond file end": []byte("1:33:set1:k400000000:v"),
+	}
+
+	for name, tail := range tails {
+		rc, path := newTestLog(t)
+		_, err := run(t, rc, "set", "k", "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := dump(t, rc)
+
+		complete, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//server crashed while writing the next record
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.Write(tail)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replayed, n := replay(t, path)
+		if n != 1 {
+			t.Errorf("%s: replayed %d commands, want 1", name, n)
+		}
+		if got := dump(t, replayed); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: replayed database:\n%q\nwant:\n%q", name, got, want)
+		}
+
+		truncated, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if truncated.Size() != complete.Size() {
+			t.Errorf("%s: log size after replay: %d, want %d", name, truncated.Size(), complete.Size())
+		}
+	}
+}
+
+func TestAppendOnlyLogDamaged(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"bad amount of arguments", "1:x3:set\n", "IS DAMAGED AT OFFSET 0"},
+		{"no new line", string(encodeCommand(&command{"set", []string{"k", "v"}})) + "1:23:del1:kX", "IS DAMAGED AT OFFSET 15"},
+		{"bad netstring", "1:33:set1:k?:v\n", "IS DAMAGED AT OFFSET 0"},
+		{"too long netstring", "1:9223372036854775807:", "IS DAMAGED AT OFFSET 0"},
+		{"too many arguments", "19:9223372036854775807\n", "IS DAMAGED AT OFFSET 0"},
+		{"unknown command", string(encodeCommand(&command{"unknown", nil})), "HAS UNKNOWN COMMAND AT OFFSET 0"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "appendonly.log")
+		err := os.WriteFile(path, []byte(test.data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = replayAppendOnlyLog(newTestCache(t), path)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
@@ -1,192 +1,240 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
 	"strconv"
 	"sync"
 	"time"
 )
 
 const (
-	autosave = "autosave"
+	defaultDBFilename = "autosave" //file, the database is saved to by autosave and shutdown, if dbfilename is not set
 )
 
 /*Commands Map - includes a list of custom commands for interacting with the database*/
-var commands = map[string]func(*KVCache, *command) (string, error){
-	//set - set's to KVCache.DataStore {key:value} pair. If key allready exists,
-	//set Value.ExpireIsSet to false.
+var commands = map[string]func(*KVCache, *command) (reply, error){
+	//set - set's to database {key:value} pair. If key allready exists,
+	//set Value.ExpireIsSet to false(unless keepttl is set).
+	//Options: ex/px - expiration time in seconds/milliseconds, exat/pxat - expiration date as unix time in seconds/milliseconds,
+	//keepttl - keep expiration date of previous value, nx/xx - set only if key doesn't exist/exists, get - return previous value.
 	//Return:
-	//"Ok",nil - if successful,
-	//"", error - if unsuccessful
-	"set": func(KVCache *KVCache, cmd *command) (string, error) {
-		err := validateArgsCount(cmd, 2)
+	//okReply,nil - if successful,
+	//Value.Value, nil - previous value with get option,
+	//nil, *nilError - if key was not set because of nx/xx, or there was no previous value with get option,
+	//nil, error - if unsuccessful
+	"set": func(KVCache *KVCache, cmd *command) (reply, error) {
+		options, err := parseSetOptions(cmd)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		KVCache.Mut.Lock()
-		KVCache.DataStore[cmd.args[0]] = newValue(cmd.args[1], false)
-		KVCache.ExpKeys.removeExpirationFromKey(cmd.args[0])
-		KVCache.Mut.Unlock()
+		key := cmd.args[0]
+		previous, exists := KVCache.lookup(key)
+		if options.get && exists && previous.Type != typeString {
+			return nil, errWrongType
+		}
 
-		return "true", nil
+		if (options.nx && exists) || (options.xx && !exists) {
+			if options.get && exists {
+				return bulkReply(previous.Value), nil
+			}
+			return nil, &nilError{fmt.Sprintf("ERR: Key was not set because of nx/xx option: %s. %s;", key, cmd)}
+		}
+
+		Value := newValue(cmd.args[1], options.keepTTL && exists && previous.ExpireIsSet)
+		KVCache.store(key, Value)
+		if !Value.ExpireIsSet {
+			KVCache.removeExpiration(key)
+		}
+		if !options.deadline.IsZero() {
+			Value.ExpireIsSet = true
+			KVCache.setExpiration(key, options.deadline)
+		}
+		KVCache.addDirty(key, 1)
+
+		KVCache.notifyKeyspaceEvent(notifyString, "set", key)
+		if !options.deadline.IsZero() {
+			KVCache.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+		}
+
+		if !options.get {
+			return okReply{}, nil
+		}
+		if exists {
+			return bulkReply(previous.Value), nil
+		}
+		return nil, &nilError{fmt.Sprintf("ERR: No available Value for key: %s, is present. %s;", key, cmd)}
 	},
 
-	//get - return the value corresponding to the key from KVCache.DataStore,
+	//get - return the value corresponding to the key from database,
 	//Return:
 	//Value.Value, nil - if successful,
-	//"", error - if unsuccessful
-	"get": func(KVCache *KVCache, cmd *command) (string, error) {
+	//nil, *nilError - if there is no such key,
+	//nil, error - if unsuccessful
+	"get": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 1)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		KVCache.Mut.RLock()
-		defer KVCache.Mut.RUnlock()
+		result, ok := KVCache.lookup(cmd.args[0])
 
-		result, ok := KVCache.DataStore[cmd.args[0]]
+		if ok && result.Type != typeString {
+			return nil, errWrongType
+		}
 
 		if ok {
-			return result.Value, nil
+			return bulkReply(result.Value), nil
 		}
 
-		return "", fmt.Errorf("ERR: NO SUCH ELEMENT: key = %s;", cmd.args[0])
+		return nil, noSuchElement(cmd.args[0])
 	},
 
 	//getset - set to key new value and return the old one.
-	//If there was no such key in database, add new pair{key:value} and return "",error.
-	"getset": func(KVCache *KVCache, cmd *command) (string, error) {
+	//If there was no such key in database, add new pair{key:value} and return nil,*nilError.
+	"getset": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 2)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		KVCache.Mut.Lock()
-		defer KVCache.Mut.Unlock()
+		Value, ok := KVCache.lookup(cmd.args[0])
+		if ok && Value.Type != typeString {
+			return nil, errWrongType
+		}
 
-		Value, ok := KVCache.DataStore[cmd.args[0]]
-		KVCache.DataStore[cmd.args[0]] = newValue(cmd.args[1], false)
-		KVCache.ExpKeys.removeExpirationFromKey(cmd.args[0])
+		KVCache.store(cmd.args[0], newValue(cmd.args[1], false))
+		KVCache.removeExpiration(cmd.args[0])
+		KVCache.addDirty(cmd.args[0], 1)
+		KVCache.notifyKeyspaceEvent(notifyString, "set", cmd.args[0])
 
 		if ok {
-			return Value.Value, nil
+			return bulkReply(Value.Value), nil
 		}
 
-		return "", fmt.Errorf("ERR: No available Value for key: %s, is present. %s;", cmd.args[0], cmd)
+		return nil, &nilError{fmt.Sprintf("ERR: No available Value for key: %s, is present. %s;", cmd.args[0], cmd)}
 	},
 
 	//exists - check if key is presented in database.
 	//return "true" - if is, "false" - if not, or error if it was occured.
-	"exist": func(KVCache *KVCache, cmd *command) (string, error) {
+	"exist": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 1)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+
+		_, ok := KVCache.lookup(cmd.args[0])
+		return boolReply(ok), nil
+	},
+
+	//exists - count how many of keys are presented in database(the same key is counted as many times as it is mentioned).
+	//return amount of existing keys, or error if it was occured.
+	"exists": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) < 1 {
+			return nil, fmt.Errorf("ERR: Not enough arguments. Command name: %s;", cmd.name)
+		}
+
+		counter := 0
+		for _, key := range cmd.args {
+			if _, ok := KVCache.lookup(key); ok {
+				counter++
+			}
 		}
 
-		KVCache.Mut.RLock()
-		_, ok := KVCache.DataStore[cmd.args[0]]
-		KVCache.Mut.RUnlock()
-		return strconv.FormatBool(ok), nil
+		return intReply(counter), nil
 	},
 
 	//deleteElement - delete element from database by key.
 	//return amount of deleted keys, or error if it was occured.
-	"del": func(KVCache *KVCache, cmd *command) (string, error) {
+	"del": func(KVCache *KVCache, cmd *command) (reply, error) {
 		if len(cmd.args) < 1 {
-			return "", fmt.Errorf("ERR: Not enough arguments. Command name: %s;", cmd.name)
+			return nil, fmt.Errorf("ERR: Not enough arguments. Command name: %s;", cmd.name)
 		}
 
 		counter := 0
-		KVCache.Mut.Lock()
 		for _, key := range cmd.args {
-			_, ok := KVCache.DataStore[key]
+			_, ok := KVCache.lookup(key)
 			if ok {
-				delete(KVCache.DataStore, key)
-				KVCache.ExpKeys.removeExpirationFromKey(key)
+				KVCache.remove(key)
+				KVCache.addDirty(key, 1)
+				KVCache.notifyKeyspaceEvent(notifyGeneric, "del", key)
 				counter++
 			}
 		}
-		KVCache.Mut.Unlock()
 
-		return strconv.Itoa(counter), nil
+		return intReply(counter), nil
 	},
 
-	//expire - set expiration date to element of database bu key.
-	//This element will be deleted when expired.
+	//expire - set expiration date to element of database bu key in seconds from now.
+	//This element will be deleted when expired(at once, if time is 0).
 	//Return "true"/"false",nil - when set/not set.
-	//Return "",error - if error was occured.
-	"ex": func(KVCache *KVCache, cmd *command) (string, error) {
+	//Return nil,error - if error was occured.
+	"ex": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 2)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		expTime, err := validateTimeDuration(cmd.args[1])
+		deadline, err := validateExpireTime(cmd.args[1], time.Second, true, true)
 		if err != nil {
-			return "", err
-		}
-
-		KVCache.Mut.Lock()
-		defer KVCache.Mut.Unlock()
-		Value, ok := KVCache.DataStore[cmd.args[0]]
-
-		if ok {
-			Value.ExpireIsSet = true
-			KVCache.ExpKeys.addExpirationForKey(cmd.args[0], time.Now().Add(expTime).Truncate(time.Second))
-			return "true", nil
+			return nil, err
 		}
 
-		return "false", nil
+		return expire(KVCache, cmd.args[0], deadline), nil
 	},
 
-	//saveData - save databse as json formated string to file.
-	//Return "true",nil - if successful.
-	//Return "",error - if it was occured.
-	"save": func(KVCache *KVCache, cmd *command) (string, error) {
-		err := validateArgsCount(cmd, 1)
+	//expireat - set expiration date to element of database as unix time in seconds.
+	//If the date has already passed, element is deleted at once.
+	//Return "true"/"false",nil - when set/not set.
+	//Return nil,error - if error was occured.
+	"expireat": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		file, err := os.Create(cmd.args[0])
+		deadline, err := validateExpireTime(cmd.args[1], time.Second, false, true)
 		if err != nil {
-			return "", fmt.Errorf("ERR: CAN'T CREATE FILE: %s. ERR: %s;", cmd.args[0], err)
+			return nil, err
 		}
-		defer file.Close()
 
-		KVCache.Mut.RLock()
-		data, err := json.Marshal(&KVCache)
-		KVCache.Mut.RUnlock()
+		return expire(KVCache, cmd.args[0], deadline), nil
+	},
 
+	//saveData - save databse to file in snapshot format(see snapshot.go).
+	//Return "true",nil - if successful.
+	//Return nil,error - if it was occured.
+	"save": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
 		if err != nil {
-			return "", fmt.Errorf("ERR: ENCODE ERR: %s;", err)
+			return nil, err
 		}
 
-		_, err = file.Write(data)
+		err = writeSnapshot(KVCache, cmd.args[0])
 		if err != nil {
-			return "", fmt.Errorf("ERR: WRITING TO FILE ERR: %s;", err)
+			return nil, err
 		}
 
-		log.Println("MARSHAL AND SAVE: \n" + string(data))
-		return "true", nil
+		log.Printf("LOG: Database saved to file: %s;", cmd.args[0])
+		return okReply{}, nil
 	},
 
-	"autosave": func(KVCache *KVCache, cmd *command) (string, error) {
+	"autosave": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 1)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		interval, err := validateTimeDuration(cmd.args[0])
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
+		KVCache.Mut.Lock()
+		KVCache.config.autosave = int(interval / time.Second)
+		KVCache.Mut.Unlock()
+
 		if KVCache.autosaveIndicator {
 			KVCache.autoSaveTimeDurationChan <- interval
 
@@ -194,21 +242,30 @@ var commands = map[string]func(*KVCache, *command) (string, error){
 				KVCache.Mut.Lock()
 				KVCache.autosaveIndicator = false
 				KVCache.Mut.Unlock()
-				return fmt.Sprint("Autosave is off."), nil
+				return statusReply("Autosave is off."), nil
 			}
 
-			return fmt.Sprintf("Interval changed to - %v\n", interval), nil
+			return statusReply(fmt.Sprintf("Interval changed to - %v", interval)), nil
+		}
+
+		if KVCache.shutdown.closed() {
+			return nil, fmt.Errorf("ERR: The server is shutting down;")
 		}
 
 		KVCache.autoSaveTimeDurationChan <- interval
 
-		go func() {
+		KVCache.shutdown.goBackground(func() {
 			KVCache.Mut.Lock()
 			KVCache.autosaveIndicator = true
 			KVCache.Mut.Unlock()
 
 			for {
-				el := <-KVCache.autoSaveTimeDurationChan
+				var el time.Duration
+				select {
+				case el = <-KVCache.autoSaveTimeDurationChan:
+				case <-KVCache.shutdown.stop:
+					return
+				}
 
 				if el == time.Duration(0) {
 					KVCache.Mut.Lock()
@@ -217,107 +274,102 @@ var commands = map[string]func(*KVCache, *command) (string, error){
 					return
 				}
 
-				file, err := os.Create(autosave)
-				if err != nil {
-					fmt.Printf("ERR:AUTOSAVING. CAN'T CREATE FILE: %s. ERR: %s;\n", cmd.args[0], err)
-				}
-
-				KVCache.Mut.RLock()
-				data, err := json.Marshal(&KVCache)
-				KVCache.Mut.RUnlock()
-
-				if err != nil {
-					fmt.Printf("ERR:AUTOSAVING. ENCODE ERR: %s;\n", err)
-				}
-
-				_, err = file.Write(data)
+				err := writeSnapshot(KVCache, KVCache.dbFilename())
 				if err != nil {
-					fmt.Printf("ERR:AUTOSAVING. WRITING TO FILE ERR: %s;\n", err)
+					fmt.Printf("ERR:AUTOSAVING. %s\n", err)
 				}
-				file.Close()
 
 				if len(KVCache.autoSaveTimeDurationChan) > 0 {
 					el = <-KVCache.autoSaveTimeDurationChan
 				}
 
 				KVCache.autoSaveTimeDurationChan <- time.Duration(el)
-				time.Sleep(el)
+				if !KVCache.shutdown.sleep(el) {
+					return
+				}
 			}
-		}()
+		})
 
 		if interval == time.Duration(0) {
 			KVCache.Mut.Lock()
 			KVCache.autosaveIndicator = false
 			KVCache.Mut.Unlock()
-			return fmt.Sprint("Autosave is off."), nil
+			return statusReply("Autosave is off."), nil
 		}
 
-		return fmt.Sprintf("Autosave is on. Interval - %v", interval), nil
+		return statusReply(fmt.Sprintf("Autosave is on. Interval - %v", interval)), nil
 	},
 
-	//restoreData - restore database with help of json formated string(from file).
+	//restoreData - restore database from snapshot file. Json dumps of previous versions are accepted too.
 	//Return "true",nil - if successful.
-	//Return "",error - if it was occured.
-	"restore": func(KVCache *KVCache, cmd *command) (string, error) {
+	//Return nil,error - if it was occured.
+	"restore": func(KVCache *KVCache, cmd *command) (reply, error) {
 		err := validateArgsCount(cmd, 1)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		file, err := os.Open(cmd.args[0])
+		entries, err := readSnapshot(cmd.args[0])
 		if err != nil {
-			return "", fmt.Errorf("ERR: CAN'T READ DATA FROM FILE: %s. ERR: %s;", cmd.args[0], err)
+			return nil, err
 		}
-		defer file.Close()
 
-		data, err := ioutil.ReadAll(file)
-		if err != nil {
-			return "", fmt.Errorf("ERR: READING FROM FILE: %s. ERR: %s;", cmd.args[0], err)
-		}
+		KVCache.loadSnapshot(entries)
 
-		newCache := newKVCache()
-		err = json.Unmarshal(data, &newCache)
+		log.Printf("LOG: Database restored from file: %s. Keys: %d;", cmd.args[0], len(entries))
+		return okReply{}, nil
+	},
+
+	//rewriteaof - compacts append-only log in background.
+	//Return "true",nil - if rewrite is started.
+	//Return nil,error - if log is off or rewrite is already in progress.
+	"rewriteaof": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
 		if err != nil {
-			return "", fmt.Errorf("ERR: UNMARSHAL ERR: %s;", err)
+			return nil, err
 		}
 
-		KVCache.Mut.Lock()
-		KVCache.DataStore = newCache.DataStore
-		KVCache.ExpKeys = newCache.ExpKeys
-		for k := range KVCache.ExpKeys.ByTimeMap {
-			KVCache.ExpKeys.TimePriorityQueue.Push(&ExpTime{value: k})
+		if KVCache.aof == nil {
+			return nil, fmt.Errorf("ERR: Append-only log is off;")
 		}
-		KVCache.Mut.Unlock()
 
-		log.Println("UNMARSHALED AND RESTOREd: \n" + string(data))
-		return "true", nil
-	},
+		select {
+		case err = <-KVCache.aof.rewrite(KVCache):
+			if err != nil {
+				return nil, err
+			}
+		default:
+		}
 
-	//showAll - return all information from database as string.
-	"showall": func(KVCache *KVCache, cmd *command) (string, error) {
-		return KVCache.String(), nil
+		return okReply{}, nil
 	},
-}
 
-// expirationWatcher - Checks if any keys have expired at the moment, and removes them, if any.
-func (KVCache *KVCache) expirationWatcher() {
-	for {
+	//ping - return "PONG", or the argument if it is set.
+	"ping": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) > 1 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be 0 or 1, has: %d. %s;", len(cmd.args), cmd)
+		}
 
-		go func() {
-			keysToDelete := KVCache.ExpKeys.getExpiredKeys(time.Now())
+		if len(cmd.args) == 1 {
+			return bulkReply(cmd.args[0]), nil
+		}
+		return statusReply("PONG"), nil
+	},
 
-			KVCache.Mut.Lock()
-			for _, key := range keysToDelete {
+	//echo - return the argument.
+	"echo": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
 
-				if _, ok := KVCache.DataStore[key]; ok && KVCache.DataStore[key].ExpireIsSet {
-					delete(KVCache.DataStore, key)
-				}
-			}
-			KVCache.Mut.Unlock()
-		}()
+		return bulkReply(cmd.args[0]), nil
+	},
 
-		time.Sleep(time.Second)
-	}
+	//showAll - return all information from database as string.
+	"showall": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return bulkReply(KVCache.String()), nil
+	},
 }
 
 //Command - describes user command.
@@ -328,41 +380,110 @@ type command struct {
 
 //KVCache - main struct to store all possible information about our database.
 type KVCache struct {
-	Mut                      *sync.RWMutex
-	DataStore                map[string]*Value //main database
-	ExpKeys                  *onExpiration     //information about keys with a set expiration date
+	Mut                      *sync.RWMutex //guards autosaveIndicator and parameters of config changed at runtime, shards are guarded by their own locks
+	shards                   []*shard      //main database split by hash of key, see shard.go
 	autoSaveTimeDurationChan chan time.Duration
 	autosaveIndicator        bool
+	dirty                    int64           //amount of changes made to the database, changed atomically
+	aof                      *appendOnlyLog  //nil - if append-only log is off
+	blocked                  *blockedClients //clients waiting in blpop/brpop
+	pubsub                   *pubSub         //subscribers of channels
+	txMut                    *sync.RWMutex   //held exclusively by exec, so transaction is atomic, and shared by other commands
+	scripts                  *scriptCache    //scripts loaded for evalsha
+	repl                     *replication    //state of replication with primary or replicas
+	expiry                   *expiryEngine   //state of expirationWatcher, see exparation.go
+	memory                   *memoryLimit    //used memory and eviction settings, see memory.go
+	acl                      *accessControl  //users and their permissions, see acl.go
+	maxRequestSize           int64           //max size of client's request in bytes, changed atomically
+	shutdown                 *shutdownState  //clients and background goroutines stopped on shutdown, see shutdown.go
+	config                   *config         //parameters of the server, see config.go
+	stats                    *serverStats    //statistics reported by info, see stats.go
 }
 
-//Value - describes value set to key in Rcache.DataStore
+//types of values
+const (
+	typeString    = "string"
+	typeHash      = "hash"
+	typeList      = "list"
+	typeSet       = "set"
+	typeSortedSet = "zset"
+)
+
+//errWrongType - command can't be applied to the type of value stored under the key
+var errWrongType = fmt.Errorf("WRONGTYPE: Operation against a key holding the wrong kind of value;")
+
+//Value - describes value set to key in the database
 type Value struct {
 	Mut         *sync.Mutex
 	Value       string
 	ExpireIsSet bool
+	Type        string              //typeString, typeHash, typeList, typeSet, typeSortedSet
+	Hash        map[string]string   //fields of hash, if Type is typeHash
+	List        *deque              //elements of list, if Type is typeList
+	Set         map[string]struct{} //members of set, if Type is typeSet
+	ZSet        *sortedSet          //members and scores of sorted set, if Type is typeSortedSet
+	Version     int64               //KVCache.dirty at the moment of the last modification, see transaction.go
+	Size        int64               //estimated memory used by key and value, see memory.go
+	Access      int64               //unix time in milliseconds of the last access, changed atomically
+	Freq        int32               //logarithmic counter of accesses for allkeys-lfu, changed atomically
 }
 
 //newRcache - creates and returns *Rcache instance
-func newKVCache() *KVCache {
-	return &KVCache{&sync.RWMutex{}, make(map[string]*Value), newOnExpiration(),
-		make(chan time.Duration, 1), false}
+func newKVCache(config *config) *KVCache {
+	return &KVCache{&sync.RWMutex{}, newShards(config.shards),
+		make(chan time.Duration, 1), false, 0, nil, newBlockedClients(), newPubSub(), &sync.RWMutex{}, newScriptCache(), newReplication(),
+		newExpiryEngine(), newMemoryLimit(), newAccessControl(), defaultMaxRequestSize, newShutdownState(), config, newServerStats()}
+}
+
+//dbFilename - returns file, the database is saved to by autosave and shutdown.
+func (KVCache *KVCache) dbFilename() string {
+	KVCache.Mut.RLock()
+	defer KVCache.Mut.RUnlock()
+
+	return KVCache.config.dbFilename
 }
 
 //newValue - creates and returns *Value instance
 func newValue(s string, ExpireIsSet bool) *Value {
-	return &Value{&sync.Mutex{}, s, ExpireIsSet}
+	return &Value{Mut: &sync.Mutex{}, Value: s, ExpireIsSet: ExpireIsSet, Type: typeString}
+}
+
+//newListValue - creates and returns *Value instance with empty list
+func newListValue() *Value {
+	return &Value{Mut: &sync.Mutex{}, Type: typeList, List: newDeque()}
+}
+
+//newSetValue - creates and returns *Value instance with empty set
+func newSetValue() *Value {
+	return &Value{Mut: &sync.Mutex{}, Type: typeSet, Set: make(map[string]struct{})}
+}
+
+//newSortedSetValue - creates and returns *Value instance with empty sorted set
+func newSortedSetValue() *Value {
+	return &Value{Mut: &sync.Mutex{}, Type: typeSortedSet, ZSet: newSortedSet()}
+}
+
+//newHashValue - creates and returns *Value instance with empty hash
+func newHashValue() *Value {
+	return &Value{Mut: &sync.Mutex{}, Type: typeHash, Hash: make(map[string]string)}
 }
 
 func (KVCache *KVCache) String() string {
-	KVCache.Mut.RLock()
-	defer KVCache.Mut.RUnlock()
+	unlock := KVCache.lockAll(false)
+	defer unlock()
 
 	res := fmt.Sprint("=================================")
 	res = "\nData store:\n"
-	for k, v := range KVCache.DataStore {
-		res = fmt.Sprint(res, "{", k, " : ", v, "}\n")
+	for _, shard := range KVCache.shards {
+		for k, v := range shard.data {
+			res = fmt.Sprint(res, "{", k, " : ", v, "}\n")
+		}
+	}
+	for _, shard := range KVCache.shards {
+		if len(shard.expKeys.ByKeyMap) > 0 {
+			res = fmt.Sprint(res, shard.expKeys)
+		}
 	}
-	res = fmt.Sprint(res, KVCache.ExpKeys)
 	res = fmt.Sprint(res, "=================================")
 
 	return res
@@ -371,6 +492,18 @@ func (KVCache *KVCache) String() string {
 func (v *Value) String() string {
 	v.Mut.Lock()
 	defer v.Mut.Unlock()
+	if v.Type == typeHash {
+		return fmt.Sprintf("<hash: %v | expire_is_set: %v>", v.Hash, v.ExpireIsSet)
+	}
+	if v.Type == typeList {
+		return fmt.Sprintf("<list: %v | expire_is_set: %v>", v.List.items(), v.ExpireIsSet)
+	}
+	if v.Type == typeSet {
+		return fmt.Sprintf("<set: %v | expire_is_set: %v>", replyStrings(membersReply(v.Set)), v.ExpireIsSet)
+	}
+	if v.Type == typeSortedSet {
+		return fmt.Sprintf("<zset: %v | expire_is_set: %v>", v.ZSet.dict, v.ExpireIsSet)
+	}
 	return fmt.Sprintf("<value: %s | expire_is_set: %v>", v.Value, v.ExpireIsSet)
 }
 
@@ -386,6 +519,14 @@ func validateArgsCount(cmd *command, n int) error {
 	return nil
 }
 
+//validateArgsMin validate if there are at least n args
+func validateArgsMin(cmd *command, n int) error {
+	if len(cmd.args) < n {
+		return fmt.Errorf("ERR: Not enough arguments. Should be at least %d, has: %d. %s;", n, len(cmd.args), cmd)
+	}
+	return nil
+}
+
 //validateTimeDuration validate if value set as expiration date for key is correct
 func validateTimeDuration(Value string) (time.Duration, error) {
 	n, err := strconv.Atoi(Value)
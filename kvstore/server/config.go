@@ -0,0 +1,594 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//Parameters of the server are taken from(every next source overrides the previous one):
+// 1. defaults of flags(see newFlagSet in main.go);
+// 2. config file set with -config or KVSTORE_CONFIG: lines <parameter> <value>, lines starting with # are comments,
+//    value with spaces or quotes is written in double quotes as Go string, e.g. requirepass "my secret";
+// 3. environment variables KVSTORE_<PARAMETER>: parameter in upper case with "_" instead of "-", e.g. KVSTORE_MAXMEMORY_POLICY;
+// 4. flags of command line, which are named as parameters.
+//config get and config set read and change parameters at runtime, the ones needed only at startup are read-only.
+//config rewrite writes current values back to config file: lines of parameters are updated in place, comments are kept,
+//parameters missing in the file are appended, if their values differ from defaults.
+
+const (
+	envPrefix = "KVSTORE_"
+)
+
+func init() {
+	commands["config"] = configCommand
+}
+
+//configParameter - parameter of the server, that can be read and changed at runtime.
+type configParameter struct {
+	get func(*KVCache) string
+	set func(*KVCache, string) error
+}
+
+//staticParameter - parameter, that is read at startup only and can't be changed at runtime.
+func staticParameter(name string, get func(*config) string) configParameter {
+	return configParameter{
+		get: func(KVCache *KVCache) string {
+			return get(KVCache.config)
+		},
+		set: func(*KVCache, string) error {
+			return fmt.Errorf("ERR: Parameter can't be changed at runtime: %s;", name)
+		},
+	}
+}
+
+/*startupParameters - parameters applied at startup by their setters, in this order*/
+var startupParameters = []string{"notify-keyspace-events", "maxmemory", "maxmemory-policy", "maxmemory-samples", "max-request-size",
+	"pubsub-buffer-limit", "requirepass", "primaryuser", "primaryauth"}
+
+/*configParameters - parameters available through config command and config file, named as flags of the server*/
+var configParameters = map[string]configParameter{
+	"bind":         staticParameter("bind", func(config *config) string { return config.bind }),
+	"port":         staticParameter("port", func(config *config) string { return config.port }),
+	"protocol":     staticParameter("protocol", func(config *config) string { return config.protocol }),
+	"resp":         staticParameter("resp", func(config *config) string { return config.respPort }),
+	"metrics-port": staticParameter("metrics-port", func(config *config) string { return config.metricsPort }),
+	"shards":       staticParameter("shards", func(config *config) string { return strconv.Itoa(config.shards) }),
+	"logfile":      staticParameter("logfile", func(config *config) string { return config.logFile }),
+
+	"appendonly": staticParameter("appendonly", func(config *config) string { return config.appendOnly }),
+	"aclfile":    staticParameter("aclfile", func(config *config) string { return config.aclFile }),
+	"acl-default-deny": staticParameter("acl-default-deny", func(config *config) string {
+		return strconv.FormatBool(config.aclDefaultDeny)
+	}),
+	"shutdown-timeout": staticParameter("shutdown-timeout", func(config *config) string { return config.shutdownTimeout.String() }),
+
+	"tls-cert":         staticParameter("tls-cert", func(config *config) string { return config.tlsCert }),
+	"tls-key":          staticParameter("tls-key", func(config *config) string { return config.tlsKey }),
+	"tls-cacert":       staticParameter("tls-cacert", func(config *config) string { return config.tlsCACert }),
+	"tls-min-version":  staticParameter("tls-min-version", func(config *config) string { return config.tlsMinVersion }),
+	"tls-ciphers":      staticParameter("tls-ciphers", func(config *config) string { return config.tlsCiphers }),
+	"tls-auth-clients": staticParameter("tls-auth-clients", func(config *config) string { return config.tlsAuthClients }),
+	"tls-replication": staticParameter("tls-replication", func(config *config) string {
+		return strconv.FormatBool(config.tlsReplication)
+	}),
+
+	//loglevel - error, info or debug(see logger.go).
+	"loglevel": {
+		get: func(*KVCache) string {
+			return getLogLevel()
+		},
+		set: func(_ *KVCache, value string) error {
+			return setLogLevel(value)
+		},
+	},
+
+	//dbfilename - file, the database is saved to by autosave and shutdown.
+	"dbfilename": {
+		get: func(KVCache *KVCache) string {
+			return KVCache.dbFilename()
+		},
+		set: func(KVCache *KVCache, value string) error {
+			if value == "" {
+				return fmt.Errorf("ERR: dbfilename can't be empty;")
+			}
+
+			KVCache.Mut.Lock()
+			KVCache.config.dbFilename = value
+			KVCache.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//autosave - interval of autosave in seconds, 0 - autosave is off. The same as autosave command.
+	"autosave": {
+		get: func(KVCache *KVCache) string {
+			KVCache.Mut.RLock()
+			defer KVCache.Mut.RUnlock()
+
+			return strconv.Itoa(KVCache.config.autosave)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			_, err := commands["autosave"](KVCache, &command{"autosave", []string{value}})
+			return err
+		},
+	},
+
+	//appendfsync - fsync policy of append-only log: always, everysec or no.
+	"appendfsync": {
+		get: func(KVCache *KVCache) string {
+			KVCache.Mut.RLock()
+			defer KVCache.Mut.RUnlock()
+
+			return KVCache.config.appendFsync
+		},
+		set: func(KVCache *KVCache, value string) error {
+			err := validateFsyncPolicy(value)
+			if err != nil {
+				return err
+			}
+
+			if KVCache.aof != nil {
+				err = KVCache.aof.setFsync(value)
+				if err != nil {
+					return err
+				}
+			}
+
+			KVCache.Mut.Lock()
+			KVCache.config.appendFsync = value
+			KVCache.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//replicaof - address of primary(host:port), "" or "no one" - server is primary. The same as replicaof command.
+	"replicaof": {
+		get: func(KVCache *KVCache) string {
+			KVCache.repl.Mut.Lock()
+			defer KVCache.repl.Mut.Unlock()
+
+			return KVCache.repl.primaryAddr
+		},
+		set: func(KVCache *KVCache, value string) error {
+			if value == "" || strings.ToLower(value) == "no one" {
+				KVCache.stopReplication()
+				return nil
+			}
+
+			_, port, err := net.SplitHostPort(value)
+			if err != nil {
+				return fmt.Errorf("ERR: Address of primary is not valid: %s. Should be: <host>:<port>;", value)
+			}
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return fmt.Errorf("ERR: Port is not valid: %s;", port)
+			}
+
+			KVCache.startReplication(value)
+			return nil
+		},
+	},
+
+	//primaryuser, primaryauth - user and password to authenticate on primary with, used on the next connection to primary.
+	"primaryuser": {
+		get: func(KVCache *KVCache) string {
+			KVCache.repl.Mut.Lock()
+			defer KVCache.repl.Mut.Unlock()
+
+			return KVCache.repl.primaryUser
+		},
+		set: func(KVCache *KVCache, value string) error {
+			KVCache.repl.Mut.Lock()
+			KVCache.repl.primaryUser = value
+			KVCache.repl.Mut.Unlock()
+			return nil
+		},
+	},
+	"primaryauth": {
+		get: func(KVCache *KVCache) string {
+			KVCache.repl.Mut.Lock()
+			defer KVCache.repl.Mut.Unlock()
+
+			return KVCache.repl.primaryAuth
+		},
+		set: func(KVCache *KVCache, value string) error {
+			KVCache.repl.Mut.Lock()
+			KVCache.repl.primaryAuth = value
+			KVCache.repl.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//requirepass - password of "default" user, "" - clients don't need to authenticate.
+	"requirepass": {
+		get: func(KVCache *KVCache) string {
+			KVCache.Mut.RLock()
+			defer KVCache.Mut.RUnlock()
+
+			return KVCache.config.requirepass
+		},
+		set: func(KVCache *KVCache, value string) error {
+			rules := []string{"resetpass", "nopass"}
+			if value != "" {
+				rules = []string{"resetpass", ">" + value}
+			}
+
+			err := KVCache.acl.setUser(defaultUserName, rules)
+			if err != nil {
+				return err
+			}
+
+			KVCache.Mut.Lock()
+			KVCache.config.requirepass = value
+			KVCache.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//max-request-size - max size of client's request in bytes(suffixes kb, mb, gb are allowed), see resp.go.
+	"max-request-size": {
+		get: func(KVCache *KVCache) string {
+			return strconv.FormatInt(atomic.LoadInt64(&KVCache.maxRequestSize), 10)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			size, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			if size == 0 || size > maxRESPBulkBytes {
+				return fmt.Errorf("ERR: Max request size should be from 1 byte to %d bytes: %s;", maxRESPBulkBytes, value)
+			}
+
+			atomic.StoreInt64(&KVCache.maxRequestSize, size)
+			return nil
+		},
+	},
+
+	//pubsub-buffer-limit - max size of subscriber's output buffer in bytes, applied to new subscribers.
+	"pubsub-buffer-limit": {
+		get: func(KVCache *KVCache) string {
+			KVCache.pubsub.Mut.RLock()
+			defer KVCache.pubsub.Mut.RUnlock()
+
+			return strconv.Itoa(KVCache.pubsub.bufferLimit)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return fmt.Errorf("ERR: pubsub-buffer-limit should be positive number of bytes: %s;", value)
+			}
+
+			KVCache.pubsub.Mut.Lock()
+			KVCache.pubsub.bufferLimit = limit
+			KVCache.pubsub.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//notify-keyspace-events - classes of keyspace events to publish(see notify.go).
+	"notify-keyspace-events": {
+		get: func(KVCache *KVCache) string {
+			KVCache.pubsub.Mut.RLock()
+			defer KVCache.pubsub.Mut.RUnlock()
+
+			return formatNotifyFlags(KVCache.pubsub.notify)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			flags, err := parseNotifyFlags(value)
+			if err != nil {
+				return err
+			}
+
+			KVCache.pubsub.Mut.Lock()
+			KVCache.pubsub.notify = flags
+			KVCache.pubsub.Mut.Unlock()
+			return nil
+		},
+	},
+
+	//maxmemory - memory limit in bytes(suffixes kb, mb, gb are allowed), 0 - no limit(see memory.go).
+	"maxmemory": {
+		get: func(KVCache *KVCache) string {
+			max, _, _ := KVCache.memory.settings()
+			return strconv.FormatInt(max, 10)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			return KVCache.memory.setMax(value)
+		},
+	},
+
+	//maxmemory-policy - how keys are chosen for eviction, when memory limit is reached.
+	"maxmemory-policy": {
+		get: func(KVCache *KVCache) string {
+			_, policy, _ := KVCache.memory.settings()
+			return policy
+		},
+		set: func(KVCache *KVCache, value string) error {
+			return KVCache.memory.setPolicy(value)
+		},
+	},
+
+	//maxmemory-samples - amount of keys sampled to choose one to evict.
+	"maxmemory-samples": {
+		get: func(KVCache *KVCache) string {
+			_, _, samples := KVCache.memory.settings()
+			return strconv.Itoa(samples)
+		},
+		set: func(KVCache *KVCache, value string) error {
+			return KVCache.memory.setSamples(value)
+		},
+	},
+}
+
+//configCommand - read and change parameters of the server:
+//config get <pattern> - return names and values of parameters matching glob-style pattern, sorted by name.
+//config set <parameter> <value> - change value of parameter.
+//config rewrite - write current values of parameters to config file.
+func configCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	if len(cmd.args) == 0 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: config get <pattern> | config set <parameter> <value> | config rewrite. %s;", cmd)
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "get":
+		if len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: config get <pattern>. %s;", cmd)
+		}
+
+		names := make([]string, 0)
+		for name := range configParameters {
+			if matchPattern(strings.ToLower(cmd.args[1]), name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		result := arrayReply{}
+		for _, name := range names {
+			result = append(result, bulkReply(name), bulkReply(configParameters[name].get(KVCache)))
+		}
+		return result, nil
+
+	case "set":
+		if len(cmd.args) != 3 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: config set <parameter> <value>. %s;", cmd)
+		}
+
+		parameter, ok := configParameters[strings.ToLower(cmd.args[1])]
+		if !ok {
+			return nil, fmt.Errorf("ERR: Unknown parameter: %s;", cmd.args[1])
+		}
+
+		err := parameter.set(KVCache, cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+		return okReply{}, nil
+
+	case "rewrite":
+		if len(cmd.args) != 1 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: config rewrite. %s;", cmd)
+		}
+
+		err := rewriteConfigFile(KVCache)
+		if err != nil {
+			return nil, err
+		}
+		return okReply{}, nil
+	}
+
+	return nil, fmt.Errorf("ERR: Unknown subcommand: %s. Should be get, set or rewrite. %s;", cmd.args[0], cmd)
+}
+
+//loadConfig - sets flags, which are not set in command line, from config file at path(or KVSTORE_CONFIG, if path is empty)
+//and then from environment variables.
+func loadConfig(flags *flag.FlagSet, path string) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if path == "" {
+		path = os.Getenv(envName("config"))
+		flags.Set("config", path)
+	}
+
+	if path != "" {
+		lines, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if explicit[line.name] {
+				continue
+			}
+
+			err = flags.Set(line.name, line.value)
+			if err != nil {
+				return fmt.Errorf("ERR: Invalid value in config file %s, line %d: %s. %s;", path, line.number, line.value, err)
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] || f.Name == "config" || err != nil {
+			return
+		}
+
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("ERR: Invalid value of environment variable %s: %s. %s;", envName(f.Name), value, setErr)
+		}
+	})
+	return err
+}
+
+//envName - name of environment variable of parameter.
+func envName(parameter string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(parameter, "-", "_"))
+}
+
+//configLine - parameter set in config file.
+type configLine struct {
+	name   string
+	value  string
+	number int //number of line in the file
+}
+
+//readConfigFile - reads parameters from config file. Unknown parameter is an error.
+func readConfigFile(path string) ([]configLine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: CAN'T READ CONFIG FILE: %s. ERR: %s;", path, err)
+	}
+
+	lines := make([]configLine, 0)
+	for i, text := range strings.Split(string(data), "\n") {
+		name, value, ok, err := parseConfigLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Invalid config file %s, line %d: %s;", path, i+1, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if _, known := configParameters[name]; !known {
+			return nil, fmt.Errorf("ERR: Invalid config file %s, line %d: unknown parameter: %s;", path, i+1, name)
+		}
+		lines = append(lines, configLine{name, value, i + 1})
+	}
+
+	return lines, nil
+}
+
+//parseConfigLine - returns parameter and its value from line of config file, false - if line is empty or comment.
+func parseConfigLine(text string) (string, string, bool, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return "", "", false, nil
+	}
+
+	name, value := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		name, value = text[:i], strings.TrimSpace(text[i:])
+	}
+	name = strings.ToLower(name)
+
+	if strings.HasPrefix(value, "\"") {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", "", false, fmt.Errorf("bad quoted value: %s", value)
+		}
+		value = unquoted
+	}
+
+	return name, value, true, nil
+}
+
+//formatConfigLine - returns line of config file with parameter, value is quoted, if it is empty or has spaces, quotes or #.
+func formatConfigLine(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"#\\") {
+		value = strconv.Quote(value)
+	}
+	return name + " " + value
+}
+
+//rewriteConfigFile - writes current values of parameters to config file the server was started with.
+//Lines of parameters are replaced(duplicates are dropped), comments are kept, missing parameters with values
+//other than defaults are appended. File is replaced atomically as snapshot is.
+func rewriteConfigFile(KVCache *KVCache) error {
+	path := KVCache.config.configFile
+	if path == "" {
+		return fmt.Errorf("ERR: The server is running without config file;")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ERR: CAN'T READ CONFIG FILE: %s. ERR: %s;", path, err)
+	}
+
+	//new file gets permissions of replaced one, as temporary file is created with 0600
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	values := make(map[string]string)
+	for name, parameter := range configParameters {
+		values[name] = parameter.get(KVCache)
+	}
+
+	result := make([]string, 0)
+	written := make(map[string]bool)
+	for _, text := range strings.Split(string(data), "\n") {
+		name, _, ok, err := parseConfigLine(text)
+		if err != nil || !ok {
+			result = append(result, text)
+			continue
+		}
+
+		value, known := values[name]
+		if !known || written[name] {
+			continue
+		}
+
+		written[name] = true
+		result = append(result, formatConfigLine(name, value))
+	}
+
+	//the file ends with the last line
+	for len(result) > 0 && result[len(result)-1] == "" {
+		result = result[:len(result)-1]
+	}
+
+	defaults := newFlagSet("", &config{})
+	missing := make([]string, 0)
+	for name, value := range values {
+		if !written[name] && value != defaults.Lookup(name).DefValue {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		result = append(result, formatConfigLine(name, values[name]))
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("ERR: CAN'T CREATE FILE: %s. ERR: %s;", path, err)
+	}
+
+	err = tmp.Chmod(mode)
+	if err == nil {
+		_, err = tmp.WriteString(strings.Join(result, "\n") + "\n")
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR: WRITING TO FILE ERR: %s;", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR: CAN'T REPLACE FILE: %s. ERR: %s;", path, err)
+	}
+
+	log.Printf("LOG: Config file rewritten: %s;", path)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConfigGet(t *testing.T) {
+	rc := newTestCache(t)
+
+	got, err := run(t, rc, "config", "get", "maxmemory*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := arrayReply{bulkReply("maxmemory"), bulkReply("0"), bulkReply("maxmemory-policy"), bulkReply("noeviction"),
+		bulkReply("maxmemory-samples"), bulkReply("5")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config get maxmemory*: got %q, want %q", got, want)
+	}
+
+	//all parameters, the same order every time
+	for i := 0; i < 10; i++ {
+		got, err := run(t, rc, "config", "get", "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := got.(arrayReply)
+		if len(all) != 2*len(configParameters) {
+			t.Fatalf("config get *: got %d names and values, want %d", len(all), 2*len(configParameters))
+		}
+		names := make([]string, 0, len(configParameters))
+		for j := 0; j < len(all); j += 2 {
+			names = append(names, string(all[j].(bulkReply)))
+		}
+		if !sort.StringsAreSorted(names) {
+			t.Fatalf("config get *: names are not sorted: %q", names)
+		}
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	for _, perm := range []os.FileMode{0600, 0640, 0644} {
+		path := filepath.Join(t.TempDir(), "kvstore.conf")
+		err := os.WriteFile(path, []byte("# limits\nmaxmemory-policy noeviction\n"), perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//permissions of created file are limited by umask
+		err = os.Chmod(path, perm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//the server is started as main does it
+		config := &config{}
+		flags := newFlagSet("kvstore", config)
+		err = flags.Parse([]string{"-config", path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := newKVCache(config)
+		for _, name := range startupParameters {
+			err := configParameters[name].set(rc, flags.Lookup(name).Value.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, args := range [][]string{{"config", "set", "maxmemory-policy", "allkeys-lru"}, {"config", "rewrite"}} {
+			_, err := run(t, rc, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "# limits\nmaxmemory-policy allkeys-lru\n"; string(data) != want {
+			t.Errorf("rewritten config file: got %q, want %q", data, want)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("permissions of rewritten config file: got %v, want %v", info.Mode().Perm(), perm)
+		}
+	}
+}
@@ -4,19 +4,33 @@ import (
 	"container/heap"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+//Keys expire in two ways:
+//active - expirationWatcher sleeps until the earliest expiration date among shards and removes expired keys then.
+//Setting earlier expiration date wakes it up, so keys are removed within milliseconds after they expire.
+//Expired keys are taken from the head of shard's queue in batches of activeExpireBatch, the shard is unlocked
+//between batches, so memory is reclaimed without scanning the database and without blocking commands for long.
+//lazy - execute removes expired keys of command before executing it, so command never sees expired key.
+//Replica doesn't remove keys itself, it waits for del from primary. Until then read commands on replica don't see expired keys.
+
+const (
+	activeExpireBatch = 20          //max amount of keys removed from shard with single lock
+	activeExpireIdle  = time.Second //max sleep of expirationWatcher, when there are no keys to expire
+)
+
 //onExpiration - store info about keys with set expiration date
 type onExpiration struct {
 	Mut               *sync.Mutex
-	ByKeyMap          map[string]*ExpTime    //key - key from main database, value - expiration time
-	ByTimeMap         map[time.Time][]string //key - expiration date, value - slice of keys from main database
-	TimePriorityQueue *PriorityQueue         //priority queue
+	ByKeyMap          map[string]*ExpTime //key - key from main database, value - its item in priority queue
+	TimePriorityQueue *PriorityQueue      //expiration dates of keys, the earliest one is the first
 }
 
-//ExpTime store expiration date and it's index in priority queue
+//ExpTime store key, its expiration date and index in priority queue
 type ExpTime struct {
+	key   string
 	value time.Time
 	index int
 }
@@ -28,11 +42,6 @@ func (ExpKeys *onExpiration) String() string {
 		res = fmt.Sprint(res, "{", k, " : ", v.value, "}\n")
 	}
 
-	res = fmt.Sprint(res, "by time map:\n")
-	for k, v := range ExpKeys.ByTimeMap {
-		res = fmt.Sprint(res, "{", k, " : ", v, "}\n")
-	}
-
 	res = fmt.Sprint(res, "time priority queue:\n")
 	res = fmt.Sprint(res, ExpKeys.TimePriorityQueue)
 	ExpKeys.Mut.Unlock()
@@ -42,89 +51,256 @@ func (ExpKeys *onExpiration) String() string {
 func newOnExpiration() *onExpiration {
 	var TimePriorityQueue = make(PriorityQueue, 0)
 	heap.Init(&TimePriorityQueue)
-	return &onExpiration{&sync.Mutex{}, make(map[string]*ExpTime), make(map[time.Time][]string), &TimePriorityQueue}
+	return &onExpiration{&sync.Mutex{}, make(map[string]*ExpTime), &TimePriorityQueue}
 }
 
 //addExpirationForKey - add exparaiontion for key
 func (ExpKeys *onExpiration) addExpirationForKey(key string, expTime time.Time) {
-	timeItem := &ExpTime{value: expTime}
-
 	ExpKeys.Mut.Lock()
+	defer ExpKeys.Mut.Unlock()
 
-	_, ok := ExpKeys.ByKeyMap[key]
-
-	if ok {
-		removeExpiredKey(key, ExpKeys)
+	if timeItem, ok := ExpKeys.ByKeyMap[key]; ok {
+		ExpKeys.TimePriorityQueue.update(timeItem, expTime)
+		return
 	}
 
+	timeItem := &ExpTime{key: key, value: expTime}
 	ExpKeys.ByKeyMap[key] = timeItem
-	ExpKeys.ByTimeMap[timeItem.value] = append(ExpKeys.ByTimeMap[timeItem.value], key)
 	heap.Push(ExpKeys.TimePriorityQueue, timeItem)
-	ExpKeys.Mut.Unlock()
 }
 
 //removeExpirationFromKey - remove expiration date for key
 func (ExpKeys *onExpiration) removeExpirationFromKey(key string) {
 	ExpKeys.Mut.Lock()
-	removeExpiredKey(key, ExpKeys)
-	ExpKeys.Mut.Unlock()
+	defer ExpKeys.Mut.Unlock()
+
+	if timeItem, ok := ExpKeys.ByKeyMap[key]; ok {
+		delete(ExpKeys.ByKeyMap, key)
+		heap.Remove(ExpKeys.TimePriorityQueue, timeItem.index)
+	}
 }
 
-//getExpiredKeys - find all keys, that expired, delete them from *onExpiration base and return it as slice of string
-func (ExpKeys *onExpiration) getExpiredKeys(tillTime time.Time) []string {
+//expirationOf - returns expiration date of key, false - if it is not set
+func (ExpKeys *onExpiration) expirationOf(key string) (time.Time, bool) {
 	ExpKeys.Mut.Lock()
+	defer ExpKeys.Mut.Unlock()
 
-	keysToReturn := make([]string, 0)
+	timeItem, ok := ExpKeys.ByKeyMap[key]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return timeItem.value, true
+}
 
-	for i := 0; i < ExpKeys.TimePriorityQueue.Len(); i++ {
-		timeItem := ExpKeys.TimePriorityQueue.Peek()
+//earliest - returns the earliest expiration date, false - if there are no keys with expiration date
+func (ExpKeys *onExpiration) earliest() (time.Time, bool) {
+	ExpKeys.Mut.Lock()
+	defer ExpKeys.Mut.Unlock()
 
-		if timeItem.value.Before(tillTime) {
-			keysToReturn = append(keysToReturn, ExpKeys.ByTimeMap[heap.Pop(ExpKeys.TimePriorityQueue).(*ExpTime).value]...)
-			delete(ExpKeys.ByTimeMap, timeItem.value)
-			continue
-		}
-		break
+	if ExpKeys.TimePriorityQueue.Len() == 0 {
+		return time.Time{}, false
 	}
 
-	for _, key := range keysToReturn {
-		delete(ExpKeys.ByKeyMap, key)
+	return ExpKeys.TimePriorityQueue.Peek().value, true
+}
+
+//getExpiredKeys - find up to limit keys, that expired till tillTime, delete them from *onExpiration base and return it as slice of string
+func (ExpKeys *onExpiration) getExpiredKeys(tillTime time.Time, limit int) []string {
+	ExpKeys.Mut.Lock()
+	defer ExpKeys.Mut.Unlock()
+
+	keysToReturn := make([]string, 0)
+
+	for ExpKeys.TimePriorityQueue.Len() > 0 && len(keysToReturn) < limit {
+		if ExpKeys.TimePriorityQueue.Peek().value.After(tillTime) {
+			break
+		}
+
+		timeItem := heap.Pop(ExpKeys.TimePriorityQueue).(*ExpTime)
+		delete(ExpKeys.ByKeyMap, timeItem.key)
+		keysToReturn = append(keysToReturn, timeItem.key)
 	}
 
-	ExpKeys.Mut.Unlock()
 	return keysToReturn
 }
 
-//removeExpiredKey - remove key-element from *onExpiration base
-func removeExpiredKey(key string, ExpKeys *onExpiration) {
-	timeItem, ok := ExpKeys.ByKeyMap[key]
+//sampleKeys - returns up to n keys with expiration date in order of map iteration, which is random.
+func (ExpKeys *onExpiration) sampleKeys(n int) []string {
+	ExpKeys.Mut.Lock()
+	defer ExpKeys.Mut.Unlock()
 
-	if ok {
-		delete(ExpKeys.ByKeyMap, key)
+	keys := make([]string, 0, n)
+	for key := range ExpKeys.ByKeyMap {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+//expiryEngine - state of expirationWatcher.
+type expiryEngine struct {
+	Mut    *sync.Mutex
+	next   time.Time     //expirationWatcher sleeps till this time, zero - till any key gets expiration date
+	wakeUp chan struct{} //wakes expirationWatcher up before next
+}
+
+func newExpiryEngine() *expiryEngine {
+	return &expiryEngine{Mut: &sync.Mutex{}, wakeUp: make(chan struct{}, 1)}
+}
+
+//schedule - wakes expirationWatcher up, if deadline is earlier than the time it sleeps till.
+func (engine *expiryEngine) schedule(deadline time.Time) {
+	engine.Mut.Lock()
+	earlier := engine.next.IsZero() || deadline.Before(engine.next)
+	engine.Mut.Unlock()
+
+	if earlier {
+		engine.wake()
+	}
+}
+
+func (engine *expiryEngine) wake() {
+	select {
+	case engine.wakeUp <- struct{}{}:
+	default:
+	}
+}
 
-		keySlice := ExpKeys.ByTimeMap[timeItem.value]
+func (engine *expiryEngine) setNext(next time.Time) {
+	engine.Mut.Lock()
+	engine.next = next
+	engine.Mut.Unlock()
+}
 
-		for i, k := range keySlice {
-			if k == key {
-				keySlice = removeByIndexFromSliceOfString(keySlice, i)
-				break
+// expirationWatcher - removes keys, when they expire. Sleeps until the earliest expiration date or until it is woken up.
+func (KVCache *KVCache) expirationWatcher() {
+	for {
+		wait := activeExpireIdle
+
+		//replica deletes expired keys, when primary tells it to
+		if !KVCache.repl.isReplica() {
+			if next, ok := KVCache.activeExpire(); ok {
+				wait = time.Until(next)
 			}
 		}
-		if len(keySlice) == 0 {
-			delete(ExpKeys.ByTimeMap, timeItem.value)
-			heap.Remove(ExpKeys.TimePriorityQueue, timeItem.index)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-KVCache.expiry.wakeUp:
+			timer.Stop()
+		case <-KVCache.shutdown.stop:
+			timer.Stop()
 			return
 		}
-		ExpKeys.ByTimeMap[timeItem.value] = keySlice
 	}
 }
 
-func removeByIndexFromSliceOfString(slice []string, index int) []string {
-	length := len(slice)
-	if length == 1 {
-		return []string{}
+//activeExpire - removes expired keys of all shards. Returns the earliest expiration date left, false - if there is none.
+func (KVCache *KVCache) activeExpire() (time.Time, bool) {
+	//expiration dates set while shards are checked wake watcher up
+	KVCache.expiry.setNext(time.Time{})
+
+	var next time.Time
+	found := false
+	for i, shard := range KVCache.shards {
+		for KVCache.removeExpired(i, time.Now()) {
+		}
+
+		unlock := KVCache.lockShards([]int{i}, false)
+		deadline, ok := shard.expKeys.earliest()
+		unlock()
+
+		if ok && (!found || deadline.Before(next)) {
+			next, found = deadline, true
+		}
+	}
+
+	KVCache.expiry.setNext(next)
+	return next, found
+}
+
+//removeExpired - removes up to activeExpireBatch expired keys of shard by index, propagates and notifies about their deletion.
+//Returns true, if the batch is full and there may be more expired keys.
+func (KVCache *KVCache) removeExpired(index int, now time.Time) bool {
+	KVCache.txMut.RLock()
+	defer KVCache.txMut.RUnlock()
+
+	unlock := KVCache.lockShards([]int{index}, true)
+	defer unlock()
+
+	keys := KVCache.shards[index].expKeys.getExpiredKeys(now, activeExpireBatch)
+	for _, key := range keys {
+		if value, ok := KVCache.lookup(key); ok && value.ExpireIsSet {
+			KVCache.expireKey(key)
+		}
+	}
+
+	return len(keys) == activeExpireBatch
+}
+
+//isExpired - check if key has expiration date, that has passed. Must be called with shard of key locked.
+func (KVCache *KVCache) isExpired(key string, now time.Time) bool {
+	value, ok := KVCache.lookup(key)
+	if !ok || !value.ExpireIsSet {
+		return false
 	}
-	last := slice[length-1]
-	slice[index] = last
-	return slice[0 : length-2]
+
+	deadline, ok := KVCache.expirationOf(key)
+	return ok && !deadline.After(now)
+}
+
+//hasExpired - check if any of keys is expired. Must be called with shards of keys locked.
+func (KVCache *KVCache) hasExpired(keys []string) bool {
+	now := time.Now()
+	for _, key := range keys {
+		if KVCache.isExpired(key, now) {
+			return true
+		}
+	}
+	return false
+}
+
+//expireKeys - removes expired keys among keys. Must be called with shards of keys locked exclusively.
+func (KVCache *KVCache) expireKeys(keys []string) {
+	now := time.Now()
+	for _, key := range keys {
+		if KVCache.isExpired(key, now) {
+			KVCache.expireKey(key)
+		}
+	}
+}
+
+//hideExpired - removes expired keys among keys from their shards without deleting them, so read command on replica
+//doesn't see them until del from primary comes. Returns function, that puts them back.
+//Must be called with shards of keys locked exclusively, the function too.
+func (KVCache *KVCache) hideExpired(keys []string) func() {
+	now := time.Now()
+	hidden := make(map[string]*Value)
+	for _, key := range keys {
+		if KVCache.isExpired(key, now) {
+			hidden[key], _ = KVCache.lookup(key)
+			delete(KVCache.shardOf(key).data, key)
+		}
+	}
+
+	return func() {
+		for key, value := range hidden {
+			KVCache.shardOf(key).data[key] = value
+		}
+	}
+}
+
+//expireKey - removes expired key, propagates and notifies about its deletion.
+//Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) expireKey(key string) {
+	KVCache.remove(key)
+	KVCache.addDirty(key, 1)
+	atomic.AddInt64(&KVCache.stats.expiredKeys, 1)
+	propagate(KVCache, &command{"del", []string{key}}, nil)
+	KVCache.notifyKeyspaceEvent(notifyExpired, "expired", key)
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+func init() {
+	for name, executor := range hashCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["hset"] = true
+	writeCommands["hdel"] = true
+	writeCommands["hincrby"] = true
+}
+
+/*hashCommands - commands to work with hashes: values, that store field:value pairs under single key*/
+var hashCommands = map[string]func(*KVCache, *command) (reply, error){
+	//hset - set fields of hash to values. Creates hash if key doesn't exist.
+	//Return amount of added fields(not counting updated ones), or error if it was occured.
+	"hset": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) < 3 || len(cmd.args)%2 != 1 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: hset <key> <field> <value> [<field> <value> ...]. %s;", cmd)
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		counter := 0
+		for i := 1; i < len(cmd.args); i += 2 {
+			if _, ok := hash.Hash[cmd.args[i]]; !ok {
+				counter++
+			}
+			hash.Hash[cmd.args[i]] = cmd.args[i+1]
+		}
+		KVCache.addDirty(cmd.args[0], 1)
+
+		return intReply(counter), nil
+	},
+
+	//hget - return value of hash's field.
+	//Return nil,*nilError - if there is no such key or field.
+	"hget": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != nil {
+			if value, ok := hash.Hash[cmd.args[1]]; ok {
+				return bulkReply(value), nil
+			}
+		}
+
+		return nil, &nilError{fmt.Sprintf("ERR: NO SUCH FIELD: key = %s, field = %s;", cmd.args[0], cmd.args[1])}
+	},
+
+	//hmget - return values of hash's fields. Absent fields are returned as nil.
+	"hmget": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make(arrayReply, len(cmd.args)-1)
+		for i, field := range cmd.args[1:] {
+			if hash == nil {
+				continue
+			}
+			if value, ok := hash.Hash[field]; ok {
+				result[i] = bulkReply(value)
+			}
+		}
+
+		return result, nil
+	},
+
+	//hdel - delete fields from hash. Hash without fields is deleted.
+	//Return amount of deleted fields, or error if it was occured.
+	"hdel": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil || hash == nil {
+			return intReply(0), err
+		}
+
+		counter := 0
+		for _, field := range cmd.args[1:] {
+			if _, ok := hash.Hash[field]; ok {
+				delete(hash.Hash, field)
+				counter++
+			}
+		}
+
+		if len(hash.Hash) == 0 {
+			KVCache.remove(cmd.args[0])
+		}
+		KVCache.addDirty(cmd.args[0], counter)
+
+		return intReply(counter), nil
+	},
+
+	//hgetall - return all fields and values of hash as list: field1, value1, field2, value2...
+	"hgetall": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil || hash == nil {
+			return arrayReply{}, err
+		}
+
+		result := make(arrayReply, 0, 2*len(hash.Hash))
+		for field, value := range hash.Hash {
+			result = append(result, bulkReply(field), bulkReply(value))
+		}
+
+		return result, nil
+	},
+
+	//hlen - return amount of fields in hash, 0 - if there is no such key.
+	"hlen": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil || hash == nil {
+			return intReply(0), err
+		}
+
+		return intReply(len(hash.Hash)), nil
+	},
+
+	//hexists - check if hash has field.
+	"hexists": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], false)
+		if err != nil || hash == nil {
+			return boolReply(false), err
+		}
+
+		_, ok := hash.Hash[cmd.args[1]]
+		return boolReply(ok), nil
+	},
+
+	//hincrby - increment integer value of hash's field by number. Absent field is considered as 0.
+	//Return new value, or error if value is not an integer or it would overflow.
+	"hincrby": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		increment, err := strconv.ParseInt(cmd.args[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Increment is not an integer or out of range: %s;", cmd.args[2])
+		}
+
+		hash, err := lookupHash(KVCache, cmd.args[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		var current int64
+		if value, ok := hash.Hash[cmd.args[1]]; ok {
+			current, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ERR: Hash value is not an integer: key = %s, field = %s;", cmd.args[0], cmd.args[1])
+			}
+		}
+
+		if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+			return nil, fmt.Errorf("ERR: Increment or decrement would overflow: key = %s, field = %s;", cmd.args[0], cmd.args[1])
+		}
+
+		current += increment
+		hash.Hash[cmd.args[1]] = strconv.FormatInt(current, 10)
+		KVCache.addDirty(cmd.args[0], 1)
+
+		return intReply(current), nil
+	},
+}
+
+//lookupHash - returns hash stored under key. If there is no such key, returns nil,
+//or creates new hash, when create is true. Returns errWrongType - if key holds value of other type.
+//Must be called with shard of key locked.
+func lookupHash(KVCache *KVCache, key string, create bool) (*Value, error) {
+	value, ok := KVCache.lookup(key)
+	if ok && value.Type != typeHash {
+		return nil, errWrongType
+	}
+
+	if !ok && create {
+		value = newHashValue()
+		KVCache.store(key, value)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Keys of every shard are grouped into scanBuckets buckets by hash of key(see shard.go). Bucket of key doesn't depend
+//on amount of keys, so scan cursor is just the number of the next bucket to visit among buckets of all shards:
+//key, that is present for the whole iteration, is returned when its bucket is visited, however the database grows.
+//Key may be returned more than once, if it is deleted and added back during iteration. Cursor 0 starts iteration
+//and is returned when it is finished. Commands without keys(scan, keys, dbsize, randomkey) lock shards one by one,
+//so they don't block the whole database.
+
+func init() {
+	for name, executor := range keyspaceCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["rename"] = true
+	writeCommands["renamenx"] = true
+
+	//commands without keys return only keys, that user may access, clients get executors for their user, see commandExecutor
+	userCommands["scan"] = scanCommand
+	userCommands["keys"] = keysCommand
+	userCommands["randomkey"] = randomkeyCommand
+
+	commands["scan"] = scanCommand("")
+	commands["keys"] = keysCommand("")
+	commands["randomkey"] = randomkeyCommand("")
+}
+
+//defaultScanCount - amount of keys scan looks through, if count option is not set
+const defaultScanCount = 10
+
+/*keyspaceCommands - commands to enumerate keys and to work with keys of any type*/
+var keyspaceCommands = map[string]func(*KVCache, *command) (reply, error){
+	//dbsize - return amount of keys in the database(expired keys, that are not removed yet, are counted too).
+	"dbsize": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		size := 0
+		for i, shard := range KVCache.shards {
+			unlock := KVCache.lockShards([]int{i}, false)
+			size += len(shard.data)
+			unlock()
+		}
+
+		return intReply(size), nil
+	},
+
+	//type - return type of value stored under key: string, hash, list, set, zset, or none - if there is no such key.
+	"type": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := KVCache.lookup(cmd.args[0])
+		if !ok {
+			return statusReply("none"), nil
+		}
+
+		return statusReply(value.Type), nil
+	},
+
+	//rename - rename key with its value and expiration date. Existing value of new key is overwritten.
+	//Return error - if there is no such key.
+	"rename": func(KVCache *KVCache, cmd *command) (reply, error) {
+		_, err := rename(KVCache, cmd, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return okReply{}, nil
+	},
+
+	//renamenx - rename key, only if new key doesn't exist.
+	//Return true/false - if key is renamed/new key exists, or error - if there is no such key.
+	"renamenx": func(KVCache *KVCache, cmd *command) (reply, error) {
+		renamed, err := rename(KVCache, cmd, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return boolReply(renamed), nil
+	},
+}
+
+//rename - executes rename and renamenx. Returns false - if key is not renamed because new key exists and nx is true.
+//Must be called with shards of keys locked exclusively.
+func rename(KVCache *KVCache, cmd *command, nx bool) (bool, error) {
+	err := validateArgsCount(cmd, 2)
+	if err != nil {
+		return false, err
+	}
+
+	from, to := cmd.args[0], cmd.args[1]
+	value, ok := KVCache.lookup(from)
+	if !ok {
+		return false, fmt.Errorf("ERR: NO SUCH ELEMENT: key = %s;", from)
+	}
+
+	if _, exists := KVCache.lookup(to); exists && nx {
+		return false, nil
+	}
+
+	if from == to {
+		return true, nil
+	}
+
+	deadline, expires := KVCache.expirationOf(from)
+	KVCache.remove(from)
+	KVCache.remove(to)
+	KVCache.store(to, value)
+	if expires {
+		KVCache.setExpiration(to, deadline)
+	}
+	KVCache.addDirty(from, 1)
+	KVCache.addDirty(to, 1)
+
+	KVCache.notifyKeyspaceEvent(notifyGeneric, "rename_from", from)
+	KVCache.notifyKeyspaceEvent(notifyGeneric, "rename_to", to)
+
+	if value.Type == typeList {
+		KVCache.wakeUpBlocked(to)
+	}
+
+	return true, nil
+}
+
+//scanCommand - returns executor of scan <cursor> [match <pattern>] [count <n>] [type <type>], that iterates keys of user.
+//Looks through whole buckets, until at least count keys are seen, and returns those, that match pattern and type
+//and user may access. Return array: the next cursor(0 - iteration is finished) and array of keys.
+func scanCommand(user string) func(*KVCache, *command) (reply, error) {
+	return func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		cursor, err := strconv.ParseUint(cmd.args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Invalid cursor: %s;", cmd.args[0])
+		}
+
+		pattern, keyType, count := "*", "", defaultScanCount
+		for i := 1; i < len(cmd.args); i += 2 {
+			if i+1 == len(cmd.args) {
+				return nil, fmt.Errorf("ERR: Syntax error. Should be: scan <cursor> [match <pattern>] [count <n>] [type <type>]. %s;", cmd)
+			}
+
+			switch strings.ToLower(cmd.args[i]) {
+			case "match":
+				pattern = cmd.args[i+1]
+			case "count":
+				count, err = strconv.Atoi(cmd.args[i+1])
+				if err != nil || count <= 0 {
+					return nil, fmt.Errorf("ERR: Count should be positive integer: %s;", cmd.args[i+1])
+				}
+			case "type":
+				keyType = strings.ToLower(cmd.args[i+1])
+			default:
+				return nil, fmt.Errorf("ERR: Syntax error. Should be: scan <cursor> [match <pattern>] [count <n>] [type <type>]. %s;", cmd)
+			}
+		}
+
+		allowed := KVCache.acl.keyFilter(user)
+		total := uint64(len(KVCache.shards) * scanBuckets)
+		keys := arrayReply{}
+		now := time.Now()
+		seen := 0
+		for cursor < total && seen < count {
+			index := int(cursor / scanBuckets)
+			shard := KVCache.shards[index]
+			unlock := KVCache.lockShards([]int{index}, false)
+
+			for ; cursor < total && int(cursor/scanBuckets) == index && seen < count; cursor++ {
+				for key := range shard.scan[cursor%scanBuckets] {
+					seen++
+					if KVCache.isExpired(key, now) || !matchPattern(pattern, key) || !allowed(key) {
+						continue
+					}
+					if keyType != "" && shard.data[key].Type != keyType {
+						continue
+					}
+					keys = append(keys, bulkReply(key))
+				}
+			}
+
+			unlock()
+		}
+
+		if cursor >= total {
+			cursor = 0
+		}
+
+		return arrayReply{bulkReply(strconv.FormatUint(cursor, 10)), keys}, nil
+	}
+}
+
+//keysCommand - returns executor of keys <pattern>, that returns all keys of user matching glob-style pattern.
+//It looks through the whole database, use scan for large ones.
+func keysCommand(user string) func(*KVCache, *command) (reply, error) {
+	return func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed := KVCache.acl.keyFilter(user)
+		keys := arrayReply{}
+		now := time.Now()
+		for i, shard := range KVCache.shards {
+			unlock := KVCache.lockShards([]int{i}, false)
+			for key := range shard.data {
+				if !KVCache.isExpired(key, now) && matchPattern(cmd.args[0], key) && allowed(key) {
+					keys = append(keys, bulkReply(key))
+				}
+			}
+			unlock()
+		}
+
+		return keys, nil
+	}
+}
+
+//randomkeyCommand - returns executor of randomkey, that returns random key of user.
+//Return nil,*nilError - if the database has no keys, that user may access.
+func randomkeyCommand(user string) func(*KVCache, *command) (reply, error) {
+	return func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed := KVCache.acl.keyFilter(user)
+		now := time.Now()
+		start := rand.Intn(len(KVCache.shards))
+		for i := range KVCache.shards {
+			index := (start + i) % len(KVCache.shards)
+			unlock := KVCache.lockShards([]int{index}, false)
+
+			//order of map iteration is random
+			for key := range KVCache.shards[index].data {
+				if !KVCache.isExpired(key, now) && allowed(key) {
+					unlock()
+					return bulkReply(key), nil
+				}
+			}
+			unlock()
+		}
+
+		return nil, &nilError{"ERR: The database is empty;"}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestScanWhileDatabaseGrows(t *testing.T) {
+	rc := newTestCache(t)
+	for i := 0; i < 1000; i++ {
+		_, err := run(t, rc, "set", "k"+strconv.Itoa(i), "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	returned := make(map[string]bool)
+	cursor, added, calls := "0", 0, 0
+	for {
+		result, err := run(t, rc, "scan", cursor, "count", "20")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor = string(result.(arrayReply)[0].(bulkReply))
+		for _, key := range replyStrings(result.(arrayReply)[1]) {
+			returned[key] = true
+		}
+		calls++
+		if cursor == "0" {
+			break
+		}
+		if calls > 10000 {
+			t.Fatal("scan doesn't finish")
+		}
+
+		//database grows several times during iteration
+		for i := 0; i < 100; i++ {
+			_, err := run(t, rc, "set", "new"+strconv.Itoa(added), "v")
+			if err != nil {
+				t.Fatal(err)
+			}
+			added++
+		}
+	}
+
+	if added < 2000 {
+		t.Fatalf("only %d keys were added during iteration", added)
+	}
+	for i := 0; i < 1000; i++ {
+		if key := "k" + strconv.Itoa(i); !returned[key] {
+			t.Errorf("key %s, present for the whole iteration, is not returned", key)
+		}
+	}
+}
+
+func TestScanOptions(t *testing.T) {
+	rc := newTestCache(t)
+	for _, args := range [][]string{
+		{"set", "user:1", "v"},
+		{"set", "user:2", "v"},
+		{"rpush", "user:list", "v"},
+		{"set", "other", "v"},
+	} {
+		_, err := run(t, rc, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		options []string
+		want    int //amount of returned keys
+	}{
+		{nil, 4},
+		{[]string{"match", "user:*"}, 3},
+		{[]string{"match", "user:*", "type", "string"}, 2},
+		{[]string{"type", "list"}, 1},
+		{[]string{"match", "none*"}, 0},
+	}
+
+	for _, test := range tests {
+		keys := 0
+		cursor := "0"
+		for {
+			result, err := run(t, rc, append([]string{"scan", cursor}, test.options...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor = string(result.(arrayReply)[0].(bulkReply))
+			keys += len(result.(arrayReply)[1].(arrayReply))
+			if cursor == "0" {
+				break
+			}
+		}
+
+		if keys != test.want {
+			t.Errorf("scan %v: got %d keys, want %d", test.options, keys, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+//keySpec - positions of keys in arguments of command: from first to last(negative last counts from the end) with step.
+type keySpec struct {
+	first, last, step int
+	destination       bool //only the first key is modified by command, others are just read
+}
+
+/*keySpecs - keys of commands, that work with keys. Commands without keys are not listed*/
+var keySpecs = map[string]keySpec{
+	"set":      {0, 0, 1, false},
+	"get":      {0, 0, 1, false},
+	"getset":   {0, 0, 1, false},
+	"exist":    {0, 0, 1, false},
+	"exists":   {0, -1, 1, false},
+	"del":      {0, -1, 1, false},
+	"ex":       {0, 0, 1, false},
+	"expireat": {0, 0, 1, false},
+
+	"pexpire":   {0, 0, 1, false},
+	"pexpireat": {0, 0, 1, false},
+	"ttl":       {0, 0, 1, false},
+	"pttl":      {0, 0, 1, false},
+	"persist":   {0, 0, 1, false},
+
+	"type":     {0, 0, 1, false},
+	"rename":   {0, 1, 1, false},
+	"renamenx": {0, 1, 1, false},
+
+	"incr":        {0, 0, 1, false},
+	"decr":        {0, 0, 1, false},
+	"incrby":      {0, 0, 1, false},
+	"decrby":      {0, 0, 1, false},
+	"incrbyfloat": {0, 0, 1, false},
+	"append":      {0, 0, 1, false},
+	"strlen":      {0, 0, 1, false},
+	"getrange":    {0, 0, 1, false},
+	"setrange":    {0, 0, 1, false},
+	"setnx":       {0, 0, 1, false},
+	"getdel":      {0, 0, 1, false},
+	"getex":       {0, 0, 1, false},
+
+	"hset":    {0, 0, 1, false},
+	"hget":    {0, 0, 1, false},
+	"hmget":   {0, 0, 1, false},
+	"hdel":    {0, 0, 1, false},
+	"hgetall": {0, 0, 1, false},
+	"hlen":    {0, 0, 1, false},
+	"hexists": {0, 0, 1, false},
+	"hincrby": {0, 0, 1, false},
+
+	"lpush":  {0, 0, 1, false},
+	"rpush":  {0, 0, 1, false},
+	"lpop":   {0, 0, 1, false},
+	"rpop":   {0, 0, 1, false},
+	"lrange": {0, 0, 1, false},
+	"llen":   {0, 0, 1, false},
+	"ltrim":  {0, 0, 1, false},
+	"lindex": {0, 0, 1, false},
+	"blpop":  {0, -2, 1, false},
+	"brpop":  {0, -2, 1, false},
+
+	"sadd":        {0, 0, 1, false},
+	"srem":        {0, 0, 1, false},
+	"smembers":    {0, 0, 1, false},
+	"sismember":   {0, 0, 1, false},
+	"scard":       {0, 0, 1, false},
+	"spop":        {0, 0, 1, false},
+	"srandmember": {0, 0, 1, false},
+	"sinter":      {0, -1, 1, false},
+	"sunion":      {0, -1, 1, false},
+	"sdiff":       {0, -1, 1, false},
+	"sinterstore": {0, -1, 1, true},
+	"sunionstore": {0, -1, 1, true},
+	"sdiffstore":  {0, -1, 1, true},
+
+	"zadd":             {0, 0, 1, false},
+	"zrem":             {0, 0, 1, false},
+	"zscore":           {0, 0, 1, false},
+	"zincrby":          {0, 0, 1, false},
+	"zrange":           {0, 0, 1, false},
+	"zrevrange":        {0, 0, 1, false},
+	"zrangebyscore":    {0, 0, 1, false},
+	"zrank":            {0, 0, 1, false},
+	"zcard":            {0, 0, 1, false},
+	"zremrangebyscore": {0, 0, 1, false},
+}
+
+//commandKeys - returns keys, that command works with.
+func commandKeys(cmd *command) []string {
+	spec, ok := keySpecs[cmd.name]
+	if !ok || spec.first >= len(cmd.args) {
+		return nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(cmd.args)
+	}
+	if last >= len(cmd.args) {
+		last = len(cmd.args) - 1
+	}
+
+	keys := make([]string, 0, last-spec.first+1)
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, cmd.args[i])
+	}
+
+	return keys
+}
+
+//modifiedKeys - returns keys, that write command may modify.
+func modifiedKeys(cmd *command) []string {
+	keys := commandKeys(cmd)
+	if len(keys) > 0 && keySpecs[cmd.name].destination {
+		return keys[:1]
+	}
+
+	return keys
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	for name, executor := range listCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["lpush"] = true
+	writeCommands["rpush"] = true
+	writeCommands["lpop"] = true
+	writeCommands["rpop"] = true
+	writeCommands["ltrim"] = true
+}
+
+//blockingCommands - commands, that wait for data. They are not executed by getResponse, but by blockingPop,
+//which parks the connection until one of the lists gets an element.
+var blockingCommands = map[string]string{
+	"blpop": "lpop",
+	"brpop": "rpop",
+}
+
+/*listCommands - commands to work with lists of strings*/
+var listCommands = map[string]func(*KVCache, *command) (reply, error){
+	//lpush - insert values at the head of list. Creates list if key doesn't exist.
+	//Return length of list after the push.
+	"lpush": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return push(KVCache, cmd, true)
+	},
+
+	//rpush - insert values at the tail of list. Creates list if key doesn't exist.
+	//Return length of list after the push.
+	"rpush": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return push(KVCache, cmd, false)
+	},
+
+	//lpop - remove and return the first element of list, nil - if there is no such key.
+	"lpop": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return pop(KVCache, cmd, true)
+	},
+
+	//rpop - remove and return the last element of list, nil - if there is no such key.
+	"rpop": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return pop(KVCache, cmd, false)
+	},
+
+	//lrange - return elements of list from start to stop(inclusive).
+	//Negative index counts from the end of list: -1 is the last element.
+	"lrange": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		start, stop, err := validateRange(cmd.args[1], cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := lookupList(KVCache, cmd.args[0], false)
+		if err != nil || list == nil {
+			return arrayReply{}, err
+		}
+
+		start, stop = normalizeRange(start, stop, list.List.len)
+		result := make(arrayReply, 0, stop-start+1)
+		for i := start; i <= stop; i++ {
+			result = append(result, bulkReply(list.List.at(i)))
+		}
+
+		return result, nil
+	},
+
+	//llen - return length of list, 0 - if there is no such key.
+	"llen": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := lookupList(KVCache, cmd.args[0], false)
+		if err != nil || list == nil {
+			return intReply(0), err
+		}
+
+		return intReply(list.List.len), nil
+	},
+
+	//ltrim - leave in list only elements from start to stop(inclusive). Empty list is deleted.
+	"ltrim": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		start, stop, err := validateRange(cmd.args[1], cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := lookupList(KVCache, cmd.args[0], false)
+		if err != nil || list == nil {
+			return okReply{}, err
+		}
+
+		start, stop = normalizeRange(start, stop, list.List.len)
+		list.List.trim(start, stop)
+
+		if list.List.len == 0 {
+			KVCache.remove(cmd.args[0])
+		}
+		KVCache.addDirty(cmd.args[0], 1)
+
+		return okReply{}, nil
+	},
+
+	//lindex - return element of list by index, nil - if index is out of range.
+	//Negative index counts from the end of list: -1 is the last element.
+	"lindex": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := strconv.Atoi(cmd.args[1])
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Index is not an integer: %s;", cmd.args[1])
+		}
+
+		list, err := lookupList(KVCache, cmd.args[0], false)
+		if err != nil || list == nil {
+			return nil, err
+		}
+
+		if index < 0 {
+			index += list.List.len
+		}
+
+		if index < 0 || index >= list.List.len {
+			return nil, nil
+		}
+
+		return bulkReply(list.List.at(index)), nil
+	},
+}
+
+//push - inserts values at the head(if head is true) or tail of list.
+func push(KVCache *KVCache, cmd *command, head bool) (reply, error) {
+	err := validateArgsMin(cmd, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := lookupList(KVCache, cmd.args[0], true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range cmd.args[1:] {
+		if head {
+			list.List.pushFront(value)
+		} else {
+			list.List.pushBack(value)
+		}
+	}
+	KVCache.addDirty(cmd.args[0], 1)
+
+	KVCache.wakeUpBlocked(cmd.args[0])
+
+	return intReply(list.List.len), nil
+}
+
+//pop - removes and returns the first(if head is true) or the last element of list.
+func pop(KVCache *KVCache, cmd *command, head bool) (reply, error) {
+	err := validateArgsCount(cmd, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := lookupList(KVCache, cmd.args[0], false)
+	if err != nil || list == nil {
+		return nil, err
+	}
+
+	var value string
+	if head {
+		value = list.List.popFront()
+	} else {
+		value = list.List.popBack()
+	}
+
+	if list.List.len == 0 {
+		KVCache.remove(cmd.args[0])
+	}
+	KVCache.addDirty(cmd.args[0], 1)
+
+	return bulkReply(value), nil
+}
+
+//blockingPop - executes blpop/brpop: blpop <key> [<key> ...] <timeout>.
+//Pops element from the first non-empty list. If all lists are empty, waits (without holding any lock) until
+//another client pushes to one of them, timeout(in seconds, 0 - forever) passes or client disconnects.
+//Return [key, value] - if element was popped, nil - if timeout passed.
+func blockingPop(rc *KVCache, client *client, cmd *command) (reply, error) {
+	err := validateArgsMin(cmd, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := cmd.args[:len(cmd.args)-1]
+	timeout, err := strconv.ParseFloat(cmd.args[len(cmd.args)-1], 64)
+	if err != nil || timeout < 0 {
+		return nil, fmt.Errorf("ERR: Timeout is not a positive number: %s;", cmd.args[len(cmd.args)-1])
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout * float64(time.Second)))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	popName := blockingCommands[strings.ToLower(cmd.name)]
+	var disconnected <-chan struct{}
+
+	for {
+		//client is registered before checking the lists, so push made right after the check is not missed
+		wakeUp := rc.registerBlocked(keys)
+
+		for _, key := range keys {
+			result, err := getResponse(client, &command{popName, []string{key}}, rc)
+			if err != nil || result != nil {
+				rc.unregisterBlocked(keys, wakeUp)
+				if err != nil {
+					return nil, err
+				}
+				return arrayReply{bulkReply(key), result}, nil
+			}
+		}
+
+		if disconnected == nil {
+			var stopWatching func()
+			disconnected, stopWatching = client.watchDisconnect()
+			defer stopWatching()
+		}
+
+		select {
+		case <-wakeUp:
+		case <-deadline:
+			rc.unregisterBlocked(keys, wakeUp)
+			return nil, nil
+		case <-rc.shutdown.closing:
+			//server is shutting down, client gets reply as on timeout
+			rc.unregisterBlocked(keys, wakeUp)
+			return nil, nil
+		case <-disconnected:
+			rc.unregisterBlocked(keys, wakeUp)
+			return nil, fmt.Errorf("ERR: Client disconnected while waiting. %s", cmd)
+		}
+		rc.unregisterBlocked(keys, wakeUp)
+	}
+}
+
+//blockedClients - clients waiting for elements to be pushed to lists.
+type blockedClients struct {
+	Mut   *sync.Mutex
+	byKey map[string][]chan struct{} //key - list's key, value - channels of clients waiting for it
+}
+
+func newBlockedClients() *blockedClients {
+	return &blockedClients{&sync.Mutex{}, make(map[string][]chan struct{})}
+}
+
+//registerBlocked - registers client waiting for keys. Returned channel receives signal when one of keys is pushed to.
+func (KVCache *KVCache) registerBlocked(keys []string) chan struct{} {
+	wakeUp := make(chan struct{}, 1)
+
+	KVCache.blocked.Mut.Lock()
+	for _, key := range keys {
+		KVCache.blocked.byKey[key] = append(KVCache.blocked.byKey[key], wakeUp)
+	}
+	KVCache.blocked.Mut.Unlock()
+
+	return wakeUp
+}
+
+//unregisterBlocked - removes client's channel from waiting lists of keys.
+func (KVCache *KVCache) unregisterBlocked(keys []string, wakeUp chan struct{}) {
+	KVCache.blocked.Mut.Lock()
+	defer KVCache.blocked.Mut.Unlock()
+
+	for _, key := range keys {
+		waiting := KVCache.blocked.byKey[key]
+		for i, ch := range waiting {
+			if ch == wakeUp {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiting) == 0 {
+			delete(KVCache.blocked.byKey, key)
+			continue
+		}
+		KVCache.blocked.byKey[key] = waiting
+	}
+}
+
+//wakeUpBlocked - signals all clients waiting for key.
+func (KVCache *KVCache) wakeUpBlocked(key string) {
+	KVCache.blocked.Mut.Lock()
+	defer KVCache.blocked.Mut.Unlock()
+
+	for _, wakeUp := range KVCache.blocked.byKey[key] {
+		select {
+		case wakeUp <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//lookupList - returns list stored under key. If there is no such key, returns nil,
+//or creates new list, when create is true. Returns errWrongType - if key holds value of other type.
+//Must be called with shard of key locked.
+func lookupList(KVCache *KVCache, key string, create bool) (*Value, error) {
+	value, ok := KVCache.lookup(key)
+	if ok && value.Type != typeList {
+		return nil, errWrongType
+	}
+
+	if !ok && create {
+		value = newListValue()
+		KVCache.store(key, value)
+	}
+
+	return value, nil
+}
+
+//validateRange - parses start and stop indexes of range
+func validateRange(start, stop string) (int, int, error) {
+	startIndex, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ERR: Index is not an integer: %s;", start)
+	}
+
+	stopIndex, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ERR: Index is not an integer: %s;", stop)
+	}
+
+	return startIndex, stopIndex, nil
+}
+
+//normalizeRange - converts negative indexes and cuts range to [0, length-1].
+//If range is empty, stop is less than start.
+func normalizeRange(start, stop, length int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return 0, -1
+	}
+
+	return start, stop
+}
+
+//deque - list of strings with push and pop at both ends and access by index in O(1).
+type deque struct {
+	buf  []string //ring buffer
+	head int      //index of the first element in buf
+	len  int
+}
+
+func newDeque() *deque {
+	return &deque{buf: make([]string, 4)}
+}
+
+func (d *deque) grow() {
+	buf := make([]string, 2*len(d.buf))
+	for i := 0; i < d.len; i++ {
+		buf[i] = d.at(i)
+	}
+	d.buf = buf
+	d.head = 0
+}
+
+func (d *deque) pushFront(s string) {
+	if d.len == len(d.buf) {
+		d.grow()
+	}
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = s
+	d.len++
+}
+
+func (d *deque) pushBack(s string) {
+	if d.len == len(d.buf) {
+		d.grow()
+	}
+	d.buf[(d.head+d.len)%len(d.buf)] = s
+	d.len++
+}
+
+func (d *deque) popFront() string {
+	s := d.buf[d.head]
+	d.buf[d.head] = ""
+	d.head = (d.head + 1) % len(d.buf)
+	d.len--
+	return s
+}
+
+func (d *deque) popBack() string {
+	i := (d.head + d.len - 1) % len(d.buf)
+	s := d.buf[i]
+	d.buf[i] = ""
+	d.len--
+	return s
+}
+
+//at - returns element by index, 0 <= i < len
+func (d *deque) at(i int) string {
+	return d.buf[(d.head+i)%len(d.buf)]
+}
+
+//trim - leaves only elements from start to stop(inclusive). If stop < start - deque becomes empty.
+func (d *deque) trim(start, stop int) {
+	length := stop - start + 1
+	if length < 0 {
+		length = 0
+	}
+
+	buf := make([]string, len(d.buf))
+	for i := 0; i < length; i++ {
+		buf[i] = d.at(start + i)
+	}
+	d.buf = buf
+	d.head = 0
+	d.len = length
+}
+
+//items - returns all elements in order.
+func (d *deque) items() []string {
+	items := make([]string, d.len)
+	for i := range items {
+		items[i] = d.at(i)
+	}
+	return items
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//Log levels: debug - requests and replies of every client, connections and everything below,
+//info - events of the server(records starting with "LOG:") and errors, error - errors only.
+
+const (
+	logLevelError = "error"
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+
+	defaultLogLevel = logLevelDebug
+)
+
+/*logLevels - log levels by verbosity*/
+var logLevels = map[string]int32{
+	logLevelError: 0,
+	logLevelInfo:  1,
+	logLevelDebug: 2,
+}
+
+//currentLogLevel - verbosity of the current log level, changed atomically
+var currentLogLevel = logLevels[defaultLogLevel]
+
+//logWriter - output of log package, that drops informational records below the current log level.
+type logWriter struct {
+	out io.Writer
+}
+
+func (writer *logWriter) Write(record []byte) (int, error) {
+	if atomic.LoadInt32(&currentLogLevel) < logLevels[logLevelInfo] && bytes.HasPrefix(record, []byte("LOG:")) {
+		return len(record), nil
+	}
+
+	//time is written here, so records are filtered by their own prefix
+	_, err := writer.out.Write(append([]byte(time.Now().Format("2006/01/02 15:04:05 ")), record...))
+	return len(record), err
+}
+
+//setupLog - directs log to file(stderr - if path is empty) and sets log level.
+func setupLog(path, level string) error {
+	err := setLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stderr
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("ERR: CAN'T OPEN LOG FILE: %s. ERR: %s;", path, err)
+		}
+		out = file
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(&logWriter{out})
+	return nil
+}
+
+func setLogLevel(level string) error {
+	verbosity, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("ERR: Unknown log level: %s. Should be one of: %s, %s, %s;", level, logLevelError, logLevelInfo, logLevelDebug)
+	}
+
+	atomic.StoreInt32(&currentLogLevel, verbosity)
+	return nil
+}
+
+func getLogLevel() string {
+	verbosity := atomic.LoadInt32(&currentLogLevel)
+	for level, v := range logLevels {
+		if v == verbosity {
+			return level
+		}
+	}
+	return defaultLogLevel
+}
+
+//debugf - logs record on debug level: requests, replies and connections of clients.
+func debugf(format string, args ...interface{}) {
+	if atomic.LoadInt32(&currentLogLevel) >= logLevels[logLevelDebug] {
+		log.Printf(format, args...)
+	}
+}
@@ -1,75 +1,385 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"flag"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 //Program deploys the server with database(redis format) on it.
 //Possible client's commands:
-// set <key> <value> - add key:value pair to database
+// set <key> <value> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|pxat <unix-milliseconds>|keepttl] [nx|xx] [get] - add key:value
+//   pair to database. Options: expiration time or date, keep expiration date of previous value, set only if key doesn't exist/exists,
+//   return previous value.
 // get <key> - returns the value corresponding to the key
 // getset <key> <value> - set value to key-element and returns it's previous value. If no previous value - returns error
+// incr <key>, decr <key> - increment/decrement integer value of key by one(absent key is 0). Return new value.
+// incrby <key> <increment>, decrby <key> <decrement> - increment/decrement integer value of key by number.
+// incrbyfloat <key> <increment> - increment value of key by floating point number. Return new value.
+// append <key> <value> - append string to value of key. Return new length.
+// strlen <key> - return length of value of key.
+// getrange <key> <start> <end> - return substring of value from start to end(negative index counts from the end).
+// setrange <key> <offset> <value> - overwrite part of value starting at offset. Return new length.
+// setnx <key> <value> - set value, only if there is no such key.
+// getdel <key> - return value of key and delete it.
+// getex <key> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|pxat <unix-milliseconds>|persist] - return value of key
+//   and change its expiration date.
 // exist <key> - check if element correspondig to key - is exist. Return true - if it is, false - if not.
+// exists <key> <key> ... - return amount of existing keys.
 // del <key> <key> ...- delete all elements corresponded to pool of keys. Return amount of deleted values
-// ex <key> <seconds> - set expiration date to key's-element.
-// save <filepath> - save database as json to file(if file not exist - creats it).
-// restore <filepath> - restore database from file.
-// showall - return all information about database
+// ex <key> <seconds> - set expiration date to key's-element(expire - is the same).
+// pexpire <key> <milliseconds> - set expiration date to key's-element in milliseconds.
+// expireat <key> <unix-seconds> - set expiration date to key's-element as unix time.
+// pexpireat <key> <unix-milliseconds> - set expiration date to key's-element as unix time in milliseconds.
+// ttl <key>, pttl <key> - return time to live of key in seconds/milliseconds(-1 - no expiration date, -2 - no such key).
+// persist <key> - remove expiration date of key.
+// scan <cursor> [match <pattern>] [count <n>] [type <type>] - iterate keys: return the next cursor(0 - the end)
+//   and keys matching pattern and type. Start with cursor 0. Keys present during the whole iteration are returned.
+// keys <pattern> - return all keys matching glob-style pattern.
+// dbsize - return amount of keys.
+// randomkey - return random key.
+// type <key> - return type of value: string, hash, list, set, zset or none.
+// rename <key> <newkey> - rename key(existing newkey is overwritten).
+// renamenx <key> <newkey> - rename key, only if newkey doesn't exist.
+// save <filepath> - save database snapshot to file(if file not exist - creats it).
+// restore <filepath> - restore database from snapshot file(json dumps of previous versions are accepted too).
+// rewriteaof - compact append-only log in background.
+// hset <key> <field> <value> [<field> <value> ...] - set fields of hash. Return amount of added fields.
+// hget <key> <field> - return value of hash's field.
+// hmget <key> <field> [<field> ...] - return values of hash's fields.
+// hdel <key> <field> [<field> ...] - delete fields from hash. Return amount of deleted fields.
+// hgetall <key> - return all fields and values of hash.
+// hlen <key> - return amount of fields in hash.
+// hexists <key> <field> - check if hash has field.
+// hincrby <key> <field> <increment> - increment integer value of hash's field. Return new value.
+// lpush <key> <value> [<value> ...] - insert values at the head of list. Return length of list.
+// rpush <key> <value> [<value> ...] - insert values at the tail of list. Return length of list.
+// lpop <key>, rpop <key> - remove and return the first/last element of list.
+// lrange <key> <start> <stop> - return elements of list from start to stop(negative index counts from the end).
+// llen <key> - return length of list.
+// ltrim <key> <start> <stop> - leave in list only elements from start to stop.
+// lindex <key> <index> - return element of list by index.
+// blpop <key> [<key> ...] <timeout>, brpop <key> [<key> ...] <timeout> - pop element from the first non-empty list,
+//   if all of them are empty - wait for element timeout seconds(0 - forever).
+// sadd <key> <member> [<member> ...] - add members to set. Return amount of added members.
+// srem <key> <member> [<member> ...] - remove members from set. Return amount of removed members.
+// smembers <key> - return all members of set.
+// sismember <key> <member> - check if member is in set.
+// scard <key> - return amount of members in set.
+// spop <key> [count] - remove and return random members of set.
+// srandmember <key> [count] - return random members of set(negative count allows repeats).
+// sinter, sunion, sdiff <key> [<key> ...] - return intersection, union or difference of sets.
+// sinterstore, sunionstore, sdiffstore <destination> <key> [<key> ...] - the same, but store result to destination.
+// zadd <key> <score> <member> [<score> <member> ...] - add members with scores to sorted set. Return amount of added members.
+// zrem <key> <member> [<member> ...] - remove members from sorted set. Return amount of removed members.
+// zscore <key> <member> - return score of member.
+// zincrby <key> <increment> <member> - increment score of member. Return new score.
+// zrange <key> <start> <stop> [withscores], zrevrange <key> <start> <stop> [withscores] - return members
+//   from start to stop ordered by score from the lowest/highest.
+// zrangebyscore <key> <min> <max> [withscores] [limit <offset> <count>] - return members with score from min to max
+//   ("(" before bound excludes it, -inf and +inf are allowed).
+// zrank <key> <member> - return position of member ordered by score from the lowest.
+// zcard <key> - return amount of members in sorted set.
+// zremrangebyscore <key> <min> <max> - remove members with score from min to max. Return amount of removed members.
+// subscribe <channel> [<channel> ...] - subscribe to channels. Connection switches to push mode: published messages
+//   are sent to client as ["message", channel, message], only (p)subscribe, (p)unsubscribe and ping are allowed.
+// psubscribe <pattern> [<pattern> ...] - subscribe to channels matching glob-style patterns(messages: ["pmessage", pattern, channel, message]).
+// unsubscribe [<channel> ...], punsubscribe [<pattern> ...] - unsubscribe from channels/patterns(from all - without arguments).
+// publish <channel> <message> - send message to subscribers of channel. Return amount of clients, that got it.
+// multi - start transaction: the next commands are queued and executed atomically by exec.
+// exec - execute queued commands. Return their results, nil - if any of watched keys was modified.
+// discard - drop queued commands.
+// watch <key> [<key> ...] - abort the next exec, if any of keys is modified before it.
+// unwatch - forget watched keys.
+// eval <script> <numkeys> [<key> ...] [<arg> ...] - execute Lua script atomically. Script gets KEYS and ARGV tables
+//   and executes commands with call(<command>, <arg>, ...). Return result of script.
+// evalsha <sha1> <numkeys> [<key> ...] [<arg> ...] - execute script loaded before by its sha1 digest.
+// script load <script> - load script without executing. Return its sha1 digest.
+// script exists <sha1> [<sha1> ...], script flush - check if scripts are loaded, remove all loaded scripts.
+// replicaof <host> <port> - make server replica of primary: it loads primary's database, then applies every write
+//   made on primary. Replica rejects writes from clients. replicaof no one - stop replication, server becomes primary.
+// role - return role of server(master/slave) with replication offset and lag of replicas or link with primary.
+// info [section] - return statistics of the server: uptime, clients, commands processed, calls and latency of every command,
+//   keyspace hits/misses, expired and evicted keys, persistence and memory. Sections: server, clients, memory, persistence,
+//   stats, replication, commandstats, keyspace; all - every section, by default - all but commandstats.
+// auth [<user>] <password> - authenticate as user("default" - if user is not set).
+// acl setuser <user> [<rule> ...] - create or change user. Rules: on/off, ><password>, <<password>, nopass, resetpass,
+//   +@<category>/-@<category>(read, write, admin, pubsub, scripting, transaction, connection, all), +<command>/-<command>,
+//   ~<pattern>/allkeys/resetkeys - allowed keys, reset.
+// acl getuser <user>, acl list, acl deluser <user> [<user> ...], acl cat [<category>] - show, list, delete users,
+//   list categories or commands of category.
+// config get <pattern> - return names and values of server parameters matching pattern.
+// config set <parameter> <value> - change parameter of the server. Parameters:
+//   notify-keyspace-events - classes of keyspace events published to __keyspace__:<key> and __keyevent__:<event>
+//   channels(K - keyspace, E - keyevent, g - del/expire/persist/rename, $ - set/incrby/append/..., x - expired, e - evicted, A - all classes; "" - off).
+//   maxmemory - memory limit in bytes(or with suffix kb, mb, gb), 0 - no limit.
+//   maxmemory-policy - what to do, when memory limit is reached: noeviction(reject writes with OOM error), allkeys-lru,
+//   allkeys-lfu, volatile-lru, volatile-ttl, allkeys-random(evict keys chosen by the policy).
+//   maxmemory-samples - amount of keys sampled to choose one to evict.
+//   loglevel, dbfilename, autosave, appendfsync, requirepass, replicaof, primaryuser, primaryauth, max-request-size,
+//   pubsub-buffer-limit - the same as flags of the server. Other parameters are read-only.
+// config rewrite - write current values of parameters to config file(-config).
+// shutdown [save|nosave] - stop the server gracefully: clients finish their commands, append-only log is fsynced,
+//   the database is saved to dbfilename(save), isn't saved(nosave) or is saved only if autosave is on(by default).
+// ping [message] - return PONG or message.
+// echo <message> - return message.
+// showall - return all information about database(for debugging, use scan to enumerate keys)
+//
+//Usage: server [-config <file>] [-bind <address>] [-port <port>] [-protocol <network>] [-loglevel error|info|debug] [-logfile <file>]
+//  [-appendonly <file>] [-appendfsync always|everysec|no] [-dbfilename <file>] [-autosave <seconds>] [-resp <port>]
+//  [-pubsub-buffer-limit <bytes>] [-notify-keyspace-events <classes>] [-metrics-port <port>]
+//  [-replicaof <host:port>] [-shards <n>] [-maxmemory <bytes>] [-maxmemory-policy <policy>] [-maxmemory-samples <n>]
+//  [-requirepass <password>] [-aclfile <file>] [-acl-default-deny] [-primaryuser <user>] [-primaryauth <password>]
+//  [-tls-cert <file> -tls-key <file>] [-tls-cacert <file>] [-tls-min-version <version>] [-tls-ciphers <suites>]
+//  [-tls-auth-clients no|optional|yes] [-tls-replication] [-max-request-size <bytes>] [-shutdown-timeout <duration>]
+//  [port] [protocol]
+//Every flag may be set in config file(-config) as line: <flag name> <value>, or in environment variable KVSTORE_<FLAG NAME>
+//(see config.go). Flags override environment, environment overrides config file.
+//With -appendonly every command, that modifies database, is appended to the file,
+//and the file is replayed when the server starts.
+//
+//Clients may speak netstring protocol or RESP2(protocol of Redis, so redis-cli and Redis client libraries work).
+//The main port detects protocol by the first byte from client, port set with -resp accepts RESP only.
+//Clients may pipeline requests: send many requests without waiting for replies. Replies come in order of requests.
+//Request larger than -max-request-size closes connection.
+//SIGINT and SIGTERM stop the server as shutdown command without arguments. Clients, that don't finish their commands
+//in -shutdown-timeout, are disconnected forcibly(see shutdown.go).
+//With -metrics-port the server serves GET /metrics over HTTP in Prometheus text format(see metrics.go).
+//Subscriber, which doesn't read messages fast enough, is disconnected when its output buffer exceeds -pubsub-buffer-limit.
+//The database is split into -shards parts by hash of key, commands with keys from different parts run in parallel.
+//With -maxmemory the server is a bounded cache: when memory used by keys exceeds the limit, keys are evicted
+//by -maxmemory-policy, or writes are rejected with noeviction.
+//With -requirepass clients authenticate with auth before any other command. Users with their own passwords,
+//permissions and allowed keys are loaded from -aclfile(lines: user <name> <rule> ...) or created with acl setuser.
+//With -acl-default-deny "default" user can't run admin commands(save, restore, autosave, config, acl, ...).
+//Replica authenticates on primary as -primaryuser with -primaryauth.
+//With -tls-cert and -tls-key the server accepts TLS connections only, clients with certificates signed by -tls-cacert
+//act as ACL users named as common names of the certificates(see tls.go).
 
 const (
 	defaultProtocol = "tcp"
-	defaultPort     = ":16998"
+	defaultPort     = "16998"
+
+	protocolAuto      = "auto"
+	protocolNetstring = "netstring"
+	protocolRESP      = "resp"
 )
 
+//config - parameters of the server. dbFilename, autosave, requirepass and appendFsync are changed at runtime(see config.go)
+//and guarded by KVCache.Mut, other parameters changed at runtime are kept by their owners(memory, pubsub, ...).
 type config struct {
-	protocol string
-	port     string
+	configFile           string        //config file, empty - if not set
+	bind                 string        //address to listen on, empty - all interfaces
+	port                 string        //port to listen on
+	protocol             string        //network of listeners: tcp, tcp4, tcp6
+	appendOnly           string        //path to append-only log, empty - log is off
+	appendFsync          string        //fsync policy of append-only log
+	dbFilename           string        //file, the database is saved to by autosave and shutdown
+	autosave             int           //interval of autosave in seconds, 0 - autosave is off
+	respPort             string        //port of additional RESP-only listener, empty - if not set
+	metricsPort          string        //port of HTTP listener of /metrics, empty - if not set
+	replicaOf            string        //address of primary(host:port), empty - if server is primary
+	pubsubBufferLimit    int           //max size of output buffer of subscriber, bytes
+	shards               int           //amount of independently locked parts of the database
+	notifyKeyspaceEvents string        //classes of keyspace events to publish
+	maxmemory            string        //memory limit, "0" - no limit
+	maxmemoryPolicy      string        //how keys are chosen for eviction
+	maxmemorySamples     string        //amount of keys sampled to choose one to evict
+	requirepass          string        //password of "default" user, empty - no password
+	aclFile              string        //file with users, empty - if not set
+	aclDefaultDeny       bool          //"default" user can't run admin commands
+	primaryUser          string        //user to authenticate on primary with
+	primaryAuth          string        //password to authenticate on primary with
+	tlsCert              string        //certificate of TLS listeners, empty - TLS is off
+	tlsKey               string        //private key of tlsCert
+	tlsCACert            string        //CA to verify client certificates and primary with
+	tlsMinVersion        string        //the oldest allowed version of TLS
+	tlsCiphers           string        //comma separated cipher suites of TLS 1.2 and older, empty - default ones
+	tlsAuthClients       string        //no, optional or yes - whether clients present certificates
+	tlsReplication       bool          //replica connects to primary over TLS
+	maxRequestSize       string        //max size of client's request
+	shutdownTimeout      time.Duration //how long clients may finish their commands on shutdown
+	logLevel             string        //error, info or debug
+	logFile              string        //file to write log to, empty - stderr
 }
 
 func main() {
-	config := getConfig(os.Args)
+	config, flags := getConfig(os.Args)
+	ifErrFatal(setupLog(config.logFile, config.logLevel))
+	if config.configFile != "" {
+		log.Printf("LOG: Configuration loaded from file: %s;", config.configFile)
+	}
+
+	rc := newKVCache(config)
+
+	//these parameters are set the same way as config set does
+	for _, name := range startupParameters {
+		ifErrFatal(configParameters[name].set(rc, flags.Lookup(name).Value.String()))
+	}
+
+	if config.aclDefaultDeny {
+		ifErrFatal(rc.acl.setUser(defaultUserName, []string{"-@" + categoryAdmin}))
+	}
+	if config.aclFile != "" {
+		ifErrFatal(rc.acl.loadFile(config.aclFile))
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	ifErrFatal(err)
+	if config.tlsReplication {
+		if tlsConfig == nil {
+			log.Fatal("ERR: -tls-replication needs -tls-cert and -tls-key;")
+		}
+		rc.repl.tlsConfig = replicationTLSConfig(tlsConfig)
+	}
+
+	if config.appendOnly != "" {
+		n, err := replayAppendOnlyLog(rc, config.appendOnly)
+		ifErrFatal(err)
+		log.Printf("LOG: %d commands replayed from append-only log: %s;", n, config.appendOnly)
 
-	l, err := net.Listen(config.protocol, config.port)
+		rc.aof, err = openAppendOnlyLog(config.appendOnly, config.appendFsync)
+		ifErrFatal(err)
+
+		rc.shutdown.goBackground(rc.aofRewriteCron)
+	}
+
+	l, err := listen(config.protocol, net.JoinHostPort(config.bind, config.port), tlsConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("LOG: The server started listening;")
+	log.Printf("LOG: The server started listening: %s;", l.Addr())
+	listeners := []net.Listener{l}
+	if tlsConfig != nil {
+		log.Printf("LOG: TLS is on, clients' certificates: %s;", config.tlsAuthClients)
+	}
 
-	rc := newKVCache()
+	if config.respPort != "" {
+		respListener, err := listen(config.protocol, net.JoinHostPort(config.bind, config.respPort), tlsConfig)
+		ifErrFatal(err)
+		log.Printf("LOG: The server started listening for RESP clients: %s;", respListener.Addr())
+
+		listeners = append(listeners, respListener)
+		go serve(rc, respListener, protocolRESP)
+	}
 
-	go rc.expirationWatcher()
+	if config.metricsPort != "" {
+		metricsListener, err := net.Listen(config.protocol, net.JoinHostPort(config.bind, config.metricsPort))
+		ifErrFatal(err)
+		log.Printf("LOG: The server started listening for metrics requests: %s;", metricsListener.Addr())
 
+		listeners = append(listeners, metricsListener)
+		go serveMetrics(rc, metricsListener)
+	}
+
+	rc.shutdown.goBackground(rc.expirationWatcher)
+	rc.shutdown.goBackground(rc.replicationCron)
+
+	if config.autosave > 0 {
+		ifErrFatal(configParameters["autosave"].set(rc, strconv.Itoa(config.autosave)))
+	}
+
+	if config.replicaOf != "" {
+		rc.startReplication(config.replicaOf)
+	}
+
+	go serve(rc, l, protocolAuto)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	mode := shutdownDefault
+	select {
+	case sig := <-signals:
+		log.Printf("LOG: Signal received: %s;", sig)
+	case mode = <-rc.shutdown.request:
+	}
+
+	os.Exit(rc.shutdownServer(mode, listeners, config.shutdownTimeout))
+}
+
+//serve - accepts clients from listener and handles them with protocol.
+func serve(rc *KVCache, l net.Listener, protocol string) {
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
-		log.Printf("LOG: New client connected: %s;\n", conn.RemoteAddr())
+		debugf("LOG: New client connected: %s;", conn.RemoteAddr())
 
-		go handleConnection(rc, conn)
+		go handleConnection(rc, conn, protocol)
 	}
 }
 
-func getConfig(args []string) *config {
-	config := &config{defaultProtocol, defaultPort}
+//getConfig - reads parameters from config file, environment and flags(see config.go), positional arguments port
+//and protocol override them all. Returns parameters and flags, which hold them as strings.
+func getConfig(args []string) (*config, *flag.FlagSet) {
+	config := &config{}
+
+	flags := newFlagSet(args[0], config)
+	flags.Parse(args[1:])
+	ifErrFatal(loadConfig(flags, config.configFile))
+	args = flags.Args()
 
-	if len(args) == 2 {
-		config.port = fmt.Sprint(":", args[1])
-		return config
+	if len(args) >= 1 {
+		config.port = args[0]
 	}
 
-	if len(args) == 3 {
-		config.port = fmt.Sprint(":", args[1])
-		config.protocol = args[2]
-		return config
+	if len(args) >= 2 {
+		config.protocol = args[1]
 	}
 
-	return config
+	return config, flags
+}
+
+//newFlagSet - returns flags, which set parameters of config. Names of flags are names of parameters in config file.
+func newFlagSet(name string, config *config) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&config.configFile, "config", "", "config file with lines: <parameter> <value>. Flags and environment variables KVSTORE_<PARAMETER> override it.")
+	flags.StringVar(&config.bind, "bind", "", "address to listen on. If not set - all interfaces.")
+	flags.StringVar(&config.port, "port", defaultPort, "port to listen on.")
+	flags.StringVar(&config.protocol, "protocol", defaultProtocol, "network of listeners: tcp, tcp4 or tcp6.")
+	flags.StringVar(&config.appendOnly, "appendonly", "", "path to append-only log. If not set - log is off.")
+	flags.StringVar(&config.appendFsync, "appendfsync", defaultFsyncPolicy, "fsync policy of append-only log: always, everysec or no.")
+	flags.StringVar(&config.dbFilename, "dbfilename", defaultDBFilename, "file, the database is saved to by autosave and shutdown.")
+	flags.IntVar(&config.autosave, "autosave", 0, "interval of autosave in seconds. 0 - autosave is off.")
+	flags.StringVar(&config.respPort, "resp", "", "port of additional listener, that accepts RESP clients only.")
+	flags.StringVar(&config.metricsPort, "metrics-port", "", "port of HTTP listener, that serves /metrics in Prometheus format.")
+	flags.StringVar(&config.replicaOf, "replicaof", "", "address of primary(host:port) to replicate from.")
+	flags.IntVar(&config.pubsubBufferLimit, "pubsub-buffer-limit", defaultPubSubBufferLimit, "max size of subscriber's output buffer in bytes. Slower subscribers are disconnected.")
+	flags.IntVar(&config.shards, "shards", defaultShardCount, "amount of independently locked parts of the database.")
+	flags.StringVar(&config.notifyKeyspaceEvents, "notify-keyspace-events", "", "classes of keyspace events to publish. If not set - events are off.")
+	flags.StringVar(&config.maxmemory, "maxmemory", "0", "memory limit in bytes(or with suffix kb, mb, gb). 0 - no limit.")
+	flags.StringVar(&config.maxmemoryPolicy, "maxmemory-policy", defaultMaxmemoryPolicy, "eviction policy: noeviction, allkeys-lru, allkeys-lfu, volatile-lru, volatile-ttl or allkeys-random.")
+	flags.StringVar(&config.maxmemorySamples, "maxmemory-samples", strconv.Itoa(defaultMaxmemorySamples), "amount of keys sampled to choose one to evict.")
+	flags.StringVar(&config.requirepass, "requirepass", "", "password of \"default\" user. If not set - clients don't need to authenticate.")
+	flags.StringVar(&config.aclFile, "aclfile", "", "file with users: lines user <name> <rule> ...")
+	flags.BoolVar(&config.aclDefaultDeny, "acl-default-deny", false, "deny admin commands(save, restore, autosave, config, acl, ...) to \"default\" user.")
+	flags.StringVar(&config.primaryUser, "primaryuser", defaultUserName, "user to authenticate on primary with.")
+	flags.StringVar(&config.primaryAuth, "primaryauth", "", "password to authenticate on primary with.")
+	flags.StringVar(&config.tlsCert, "tls-cert", "", "PEM certificate of the server. With -tls-key turns TLS on.")
+	flags.StringVar(&config.tlsKey, "tls-key", "", "PEM private key of -tls-cert.")
+	flags.StringVar(&config.tlsCACert, "tls-cacert", "", "PEM CA certificates to verify clients' certificates and primary's one with.")
+	flags.StringVar(&config.tlsMinVersion, "tls-min-version", defaultTLSMinVersion, "the oldest allowed version of TLS: 1.0, 1.1, 1.2 or 1.3.")
+	flags.StringVar(&config.tlsCiphers, "tls-ciphers", "", "comma separated cipher suites of TLS 1.2 and older(names of crypto/tls). If not set - default ones.")
+	flags.StringVar(&config.tlsAuthClients, "tls-auth-clients", defaultTLSAuthClients, "whether clients present certificates: no, optional or yes.")
+	flags.BoolVar(&config.tlsReplication, "tls-replication", false, "replica connects to primary over TLS.")
+	flags.StringVar(&config.maxRequestSize, "max-request-size", strconv.Itoa(defaultMaxRequestSize), "max size of client's request in bytes(or with suffix kb, mb, gb). Larger requests close connection.")
+	flags.DurationVar(&config.shutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "how long clients may finish their commands on shutdown.")
+	flags.StringVar(&config.logLevel, "loglevel", defaultLogLevel, "log level: error, info or debug(requests and replies of every client).")
+	flags.StringVar(&config.logFile, "logfile", "", "file to write log to. If not set - stderr.")
+	return flags
 }
 
 func ifErrFatal(err error) {
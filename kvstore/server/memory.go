@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Memory limit: memory used by every key is estimated after each write command(see sizeOf) and summed in shards
+//and memoryLimit.used. When maxmemory is set and used memory exceeds it, write commands, that may add data,
+//first evict keys chosen by maxmemory-policy until memory is below the limit:
+// noeviction - nothing is evicted, such commands are rejected with OOM error,
+// allkeys-lru, volatile-lru - the least recently used key among all keys/keys with expiration date,
+// allkeys-lfu - the least frequently used key(logarithmic counter of accesses, that decays with time),
+// volatile-ttl - the key with the nearest expiration date,
+// allkeys-random - random key.
+//Keys are chosen approximately: maxmemory-samples keys are sampled from a random shard and the best of them is evicted.
+//Commands, that only remove data, are never rejected. Replica doesn't evict keys itself, it gets del from primary.
+
+const (
+	policyNoEviction    = "noeviction"
+	policyAllKeysLRU    = "allkeys-lru"
+	policyAllKeysLFU    = "allkeys-lfu"
+	policyVolatileLRU   = "volatile-lru"
+	policyVolatileTTL   = "volatile-ttl"
+	policyAllKeysRandom = "allkeys-random"
+
+	defaultMaxmemoryPolicy  = policyNoEviction
+	defaultMaxmemorySamples = 5
+
+	lfuInitVal   = 5           //counter of new key, so it isn't evicted before it gets a chance to be used
+	lfuMaxVal    = 255         //max value of counter
+	lfuLogFactor = 10          //the higher it is, the more accesses are needed to increment counter
+	lfuDecayTime = time.Minute //counter is decremented by one for every period of key's idle time
+
+	valueOverhead   = 96 //estimated memory used by Value and its entry in shard
+	elementOverhead = 48 //estimated memory used by element of hash, list, set or sorted set besides its strings
+	sizeSamples     = 8  //elements of larger collections are not measured one by one, their average size is sampled
+)
+
+//errOOM - command, that may add data, is rejected, because memory limit is reached and nothing can be evicted
+var errOOM = fmt.Errorf("OOM: command not allowed when used memory > 'maxmemory';")
+
+/*shrinkingCommands - write commands, that never add data, so they are executed even if memory limit is reached*/
+var shrinkingCommands = map[string]bool{
+	"del":              true,
+	"getdel":           true,
+	"ex":               true,
+	"expireat":         true,
+	"pexpire":          true,
+	"pexpireat":        true,
+	"persist":          true,
+	"hdel":             true,
+	"lpop":             true,
+	"rpop":             true,
+	"ltrim":            true,
+	"srem":             true,
+	"spop":             true,
+	"zrem":             true,
+	"zremrangebyscore": true,
+	"restore":          true,
+}
+
+//memoryLimit - used memory, limit and eviction settings.
+type memoryLimit struct {
+	used    int64 //estimated memory used by the database in bytes, changed atomically
+	evicted int64 //amount of keys evicted since start, changed atomically
+	Mut     *sync.RWMutex
+	max     int64  //maxmemory in bytes, 0 - no limit
+	policy  string //maxmemory-policy
+	samples int    //maxmemory-samples
+}
+
+func newMemoryLimit() *memoryLimit {
+	return &memoryLimit{Mut: &sync.RWMutex{}, policy: defaultMaxmemoryPolicy, samples: defaultMaxmemorySamples}
+}
+
+func (limit *memoryLimit) settings() (int64, string, int) {
+	limit.Mut.RLock()
+	defer limit.Mut.RUnlock()
+
+	return limit.max, limit.policy, limit.samples
+}
+
+//setMax - sets maxmemory from value in bytes or with suffix kb, mb, gb. 0 - no limit.
+func (limit *memoryLimit) setMax(value string) error {
+	max, err := parseMemory(value)
+	if err != nil {
+		return err
+	}
+
+	limit.Mut.Lock()
+	limit.max = max
+	limit.Mut.Unlock()
+	return nil
+}
+
+func (limit *memoryLimit) setPolicy(policy string) error {
+	policy = strings.ToLower(policy)
+	switch policy {
+	case policyNoEviction, policyAllKeysLRU, policyAllKeysLFU, policyVolatileLRU, policyVolatileTTL, policyAllKeysRandom:
+	default:
+		return fmt.Errorf("ERR: Unknown maxmemory policy: %s. Should be one of: %s, %s, %s, %s, %s, %s;", policy,
+			policyNoEviction, policyAllKeysLRU, policyAllKeysLFU, policyVolatileLRU, policyVolatileTTL, policyAllKeysRandom)
+	}
+
+	limit.Mut.Lock()
+	limit.policy = policy
+	limit.Mut.Unlock()
+	return nil
+}
+
+func (limit *memoryLimit) setSamples(value string) error {
+	samples, err := strconv.Atoi(value)
+	if err != nil || samples <= 0 {
+		return fmt.Errorf("ERR: Amount of samples should be positive integer: %s;", value)
+	}
+
+	limit.Mut.Lock()
+	limit.samples = samples
+	limit.Mut.Unlock()
+	return nil
+}
+
+//parseMemory - parses amount of memory in bytes or with suffix kb, mb, gb(powers of 1024).
+func parseMemory(value string) (int64, error) {
+	s := strings.ToLower(value)
+	multiplier := int64(1)
+	for _, suffix := range []struct {
+		name       string
+		multiplier int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"b", 1}} {
+		if strings.HasSuffix(s, suffix.name) {
+			s = strings.TrimSuffix(s, suffix.name)
+			multiplier = suffix.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/multiplier {
+		return 0, fmt.Errorf("ERR: Invalid amount of memory: %s. Use bytes or suffix kb, mb, gb;", value)
+	}
+
+	return n * multiplier, nil
+}
+
+//sizeOf - returns estimated memory used by key and its value. Sizes of elements of large collections are sampled,
+//so it takes the same time for any value.
+func sizeOf(key string, value *Value) int64 {
+	size := int64(valueOverhead + len(key) + len(value.Value))
+
+	n, sampled, sampledSize := 0, 0, 0
+	switch value.Type {
+	case typeHash:
+		n = len(value.Hash)
+		for field, fieldValue := range value.Hash {
+			if sampled == sizeSamples {
+				break
+			}
+			sampledSize += len(field) + len(fieldValue)
+			sampled++
+		}
+
+	case typeList:
+		n = value.List.len
+		step := n / sizeSamples
+		if step == 0 {
+			step = 1
+		}
+		for ; sampled < sizeSamples && sampled < n; sampled++ {
+			sampledSize += len(value.List.at(sampled * step))
+		}
+
+	case typeSet:
+		n = len(value.Set)
+		for member := range value.Set {
+			if sampled == sizeSamples {
+				break
+			}
+			sampledSize += len(member)
+			sampled++
+		}
+
+	case typeSortedSet:
+		n = len(value.ZSet.dict)
+		for member := range value.ZSet.dict {
+			if sampled == sizeSamples {
+				break
+			}
+			//member is stored both in dict and skiplist
+			sampledSize += 2*len(member) + elementOverhead
+			sampled++
+		}
+	}
+
+	if sampled > 0 {
+		size += int64(n) * (int64(sampledSize/sampled) + elementOverhead)
+	}
+
+	return size
+}
+
+//account - updates estimated memory of keys after they were modified. Must be called with shards of keys locked exclusively.
+func (KVCache *KVCache) account(keys []string) {
+	for _, key := range keys {
+		value, ok := KVCache.lookup(key)
+		if !ok {
+			continue
+		}
+
+		size := sizeOf(key, value)
+		KVCache.addMemory(key, size-value.Size)
+		value.Size = size
+	}
+}
+
+//addMemory - counts n bytes used by key. Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) addMemory(key string, n int64) {
+	KVCache.shardOf(key).memory += n
+	atomic.AddInt64(&KVCache.memory.used, n)
+}
+
+//touch - updates access time and frequency of existing keys. Must be called with shards of keys locked,
+//values are changed atomically, as commands reading them may run concurrently.
+func (KVCache *KVCache) touch(keys []string) {
+	now := unixMilli(time.Now())
+	for _, key := range keys {
+		if value, ok := KVCache.lookup(key); ok {
+			atomic.StoreInt32(&value.Freq, lfuIncrement(lfuCounter(value, now)))
+			atomic.StoreInt64(&value.Access, now)
+		}
+	}
+}
+
+//lfuCounter - returns access counter of value decremented by periods of idle time till now(unix time in milliseconds).
+func lfuCounter(value *Value, now int64) int32 {
+	freq := atomic.LoadInt32(&value.Freq)
+	periods := (now - atomic.LoadInt64(&value.Access)) / int64(lfuDecayTime/time.Millisecond)
+	if periods >= int64(freq) {
+		return 0
+	}
+
+	return freq - int32(periods)
+}
+
+//lfuIncrement - increments counter with probability, that falls as counter grows, so counter is logarithm of accesses.
+func lfuIncrement(freq int32) int32 {
+	if freq >= lfuMaxVal {
+		return lfuMaxVal
+	}
+
+	base := float64(freq - lfuInitVal)
+	if base < 0 {
+		base = 0
+	}
+
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		freq++
+	}
+	return freq
+}
+
+//freeMemory - evicts keys by policy, until used memory is below maxmemory. Called before write command,
+//that may add data. Returns errOOM - if memory is over the limit and policy is noeviction or there is nothing to evict.
+func (KVCache *KVCache) freeMemory(cmd *command) error {
+	max, policy, samples := KVCache.memory.settings()
+	if max == 0 || shrinkingCommands[cmd.name] {
+		return nil
+	}
+
+	for atomic.LoadInt64(&KVCache.memory.used) > max {
+		if policy == policyNoEviction {
+			return errOOM
+		}
+
+		key, ok := KVCache.evictionCandidate(policy, samples)
+		if !ok {
+			return errOOM
+		}
+
+		KVCache.evict(key, policy)
+	}
+
+	return nil
+}
+
+//evictionCandidate - samples keys starting from random shard and returns the best one to evict by policy,
+//false - if there are no keys, policy allows to evict.
+func (KVCache *KVCache) evictionCandidate(policy string, samples int) (string, bool) {
+	if policy == policyAllKeysRandom {
+		samples = 1
+	}
+	volatile := policy == policyVolatileLRU || policy == policyVolatileTTL
+
+	now := unixMilli(time.Now())
+	start := rand.Intn(len(KVCache.shards))
+
+	var candidate string
+	var candidateScore int64
+	sampled := 0
+	for i := 0; i < len(KVCache.shards) && sampled < samples; i++ {
+		index := (start + i) % len(KVCache.shards)
+		shard := KVCache.shards[index]
+		unlock := KVCache.lockShards([]int{index}, false)
+
+		var keys []string
+		if volatile {
+			keys = shard.expKeys.sampleKeys(samples - sampled)
+		} else {
+			for key := range shard.data {
+				if len(keys) == samples-sampled {
+					break
+				}
+				keys = append(keys, key)
+			}
+		}
+
+		//the lower score is, the better key is to evict
+		for _, key := range keys {
+			value := shard.data[key]
+
+			var score int64
+			switch policy {
+			case policyAllKeysLRU, policyVolatileLRU:
+				score = atomic.LoadInt64(&value.Access)
+			case policyAllKeysLFU:
+				score = int64(lfuCounter(value, now))
+			case policyVolatileTTL:
+				deadline, _ := shard.expKeys.expirationOf(key)
+				score = unixMilli(deadline)
+			}
+
+			if sampled == 0 || score < candidateScore {
+				candidate, candidateScore = key, score
+			}
+			sampled++
+		}
+
+		unlock()
+	}
+
+	return candidate, sampled > 0
+}
+
+//evict - removes key, propagates and notifies about its deletion. Key of volatile policy is kept,
+//if it lost expiration date after it was sampled.
+func (KVCache *KVCache) evict(key, policy string) {
+	unlock := KVCache.lockKeys([]string{key}, true)
+	defer unlock()
+
+	value, ok := KVCache.lookup(key)
+	if !ok || ((policy == policyVolatileLRU || policy == policyVolatileTTL) && !value.ExpireIsSet) {
+		return
+	}
+
+	KVCache.remove(key)
+	KVCache.addDirty(key, 1)
+	propagate(KVCache, &command{"del", []string{key}}, nil)
+	KVCache.notifyKeyspaceEvent(notifyEvicted, "evicted", key)
+	atomic.AddInt64(&KVCache.memory.evicted, 1)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEviction(t *testing.T) {
+	tests := []struct {
+		policy  string
+		evicted string //key, that must be evicted, "" - any key
+	}{
+		{policyAllKeysLRU, "b"},
+		{policyVolatileLRU, "c"},
+		{policyAllKeysLFU, "d"},
+		{policyVolatileTTL, "a"},
+		{policyAllKeysRandom, ""},
+	}
+
+	for _, test := range tests {
+		rc := newTestCache(t)
+		//all keys are sampled, so the choice doesn't depend on sampling
+		err := rc.memory.setSamples("100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rc.memory.setPolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//a - the nearest expiration date, b - the least recently used, c - the least recently used volatile key,
+		//d - the least frequently used
+		now := unixMilli(time.Now())
+		for _, key := range []struct {
+			name   string
+			ttl    string
+			access int64
+			freq   int32
+		}{
+			{"a", "10000", now - 3000, 50},
+			{"b", "", now - 5000, 50},
+			{"c", "20000", now - 4000, 50},
+			{"d", "30000", now, 10},
+		} {
+			args := []string{"set", key.name, "value"}
+			if key.ttl != "" {
+				args = append(args, "px", key.ttl)
+			}
+			_, err := run(t, rc, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, _ := rc.lookup(key.name)
+			value.Access, value.Freq = key.access, key.freq
+		}
+
+		//every key is larger than 1 byte, so the next write evicts exactly one key
+		err = rc.memory.setMax(strconv.FormatInt(atomic.LoadInt64(&rc.memory.used)-1, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = run(t, rc, "set", "new", "value")
+		if err != nil {
+			t.Fatalf("%s: %s", test.policy, err)
+		}
+
+		var evicted []string
+		for _, key := range []string{"a", "b", "c", "d"} {
+			if _, ok := rc.lookup(key); !ok {
+				evicted = append(evicted, key)
+			}
+		}
+		if len(evicted) != 1 || (test.evicted != "" && evicted[0] != test.evicted) {
+			t.Errorf("%s: evicted %v, want %s", test.policy, evicted, test.evicted)
+		}
+		if _, ok := rc.lookup("new"); !ok {
+			t.Errorf("%s: new key is not stored", test.policy)
+		}
+		if n := atomic.LoadInt64(&rc.memory.evicted); n != 1 {
+			t.Errorf("%s: evicted keys counter: %d", test.policy, n)
+		}
+	}
+}
+
+func TestEvictionOOM(t *testing.T) {
+	for _, policy := range []string{policyNoEviction, policyVolatileLRU, policyVolatileTTL} {
+		rc := newTestCache(t)
+		err := rc.memory.setPolicy(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//there are no keys with expiration date, that volatile policies could evict
+		for _, key := range []string{"a", "b"} {
+			_, err := run(t, rc, "set", key, "value")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = rc.memory.setMax("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = run(t, rc, "set", "new", "value")
+		if err != errOOM {
+			t.Errorf("%s: set over the limit: got error %v, want %v", policy, err, errOOM)
+		}
+		_, err = run(t, rc, "rpush", "list", "x")
+		if err != errOOM {
+			t.Errorf("%s: rpush over the limit: got error %v, want %v", policy, err, errOOM)
+		}
+
+		//commands, that only remove data, are executed
+		result, err := run(t, rc, "del", "a")
+		if err != nil || result != intReply(1) {
+			t.Errorf("%s: del over the limit: got %#v, %v", policy, result, err)
+		}
+		if _, ok := rc.lookup("b"); !ok {
+			t.Errorf("%s: key is evicted", policy)
+		}
+	}
+}
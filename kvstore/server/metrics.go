@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//Metrics: with -metrics-port the server listens for HTTP requests and serves GET /metrics in Prometheus text format:
+//calls, errors and latency histograms of commands by name, connections, keys, expirations and evictions, memory,
+//durations and errors of saves and append-only log rewrites, replication. The values are the ones info command reports
+//(see stats.go). The listener has no authentication, so it should be reachable only by the monitoring system(see -bind).
+
+const metricsPrefix = "kvstore_"
+
+//serveMetrics - serves /metrics on listener until it is closed.
+func serveMetrics(KVCache *KVCache, l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, err := w.Write(KVCache.metrics())
+		if err != nil {
+			log.Printf("ERR: Metrics send error: %s. Client addres: %s;", err, r.RemoteAddr)
+		}
+	})
+
+	err := http.Serve(l, mux)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("ERR: Metrics listener stopped: %s;", err)
+	}
+}
+
+//metricsWriter - builds response in Prometheus text format.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+//metric - starts metric: writes its description and type(counter, gauge or histogram).
+func (w *metricsWriter) metric(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+//sample - writes value of metric with labels(comma separated name="value" pairs, empty - no labels).
+func (w *metricsWriter) sample(name, labels string, value interface{}) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+
+	if f, ok := value.(float64); ok {
+		value = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	fmt.Fprintf(w, "%s%s%s %v\n", metricsPrefix, name, labels, value)
+}
+
+//single - writes metric with single value without labels.
+func (w *metricsWriter) single(name, kind, help string, value interface{}) {
+	w.metric(name, kind, help)
+	w.sample(name, "", value)
+}
+
+//histogram - writes buckets, sum and count of histogram with labels.
+func (w *metricsWriter) histogram(name, labels string, h *histogram) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	var cumulative int64
+	for i, bound := range durationBuckets {
+		cumulative += atomic.LoadInt64(&h.buckets[i])
+		w.sample(name+"_bucket", fmt.Sprintf("%sle=%q", prefix, strconv.FormatFloat(bound, 'g', -1, 64)), cumulative)
+	}
+
+	//count is read the last, so it isn't less than any bucket
+	count := atomic.LoadInt64(&h.count)
+	if count < cumulative {
+		count = cumulative
+	}
+	w.sample(name+"_bucket", prefix+`le="+Inf"`, count)
+	w.sample(name+"_sum", labels, time.Duration(atomic.LoadInt64(&h.sum)).Seconds())
+	w.sample(name+"_count", labels, count)
+}
+
+//metrics - returns all metrics of the server in Prometheus text format.
+func (KVCache *KVCache) metrics() []byte {
+	w := &metricsWriter{}
+	stats := KVCache.stats
+
+	w.single("uptime_seconds", "gauge", "Time since the server started.", time.Since(stats.startTime).Seconds())
+
+	connected, blocked := KVCache.clientCounts()
+	w.single("connected_clients", "gauge", "Clients connected now.", connected)
+	w.single("blocked_clients", "gauge", "Clients waiting in blpop/brpop.", blocked)
+	w.single("connections_total", "counter", "Clients connected since start.", atomic.LoadInt64(&stats.connections))
+
+	names := make([]string, 0, len(stats.commands))
+	for name, cs := range stats.commands {
+		if atomic.LoadInt64(&cs.latency.count) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	w.metric("commands_total", "counter", "Calls of command.")
+	for _, name := range names {
+		w.sample("commands_total", fmt.Sprintf("command=%q", name), atomic.LoadInt64(&stats.commands[name].latency.count))
+	}
+	w.metric("command_errors_total", "counter", "Calls of command, that returned error.")
+	for _, name := range names {
+		w.sample("command_errors_total", fmt.Sprintf("command=%q", name), atomic.LoadInt64(&stats.commands[name].failed))
+	}
+	w.metric("command_duration_seconds", "histogram", "Duration of command's calls.")
+	for _, name := range names {
+		w.histogram("command_duration_seconds", fmt.Sprintf("command=%q", name), &stats.commands[name].latency)
+	}
+
+	keys, expires := KVCache.keyCounts()
+	w.single("keys", "gauge", "Keys in the database.", keys)
+	w.single("keys_with_expiration", "gauge", "Keys with expiration date.", expires)
+	w.single("keyspace_hits_total", "counter", "Keys found by read commands.", atomic.LoadInt64(&stats.keyspaceHits))
+	w.single("keyspace_misses_total", "counter", "Keys not found by read commands.", atomic.LoadInt64(&stats.keyspaceMisses))
+	w.single("expired_keys_total", "counter", "Keys removed, because they expired.", atomic.LoadInt64(&stats.expiredKeys))
+	w.single("evicted_keys_total", "counter", "Keys evicted by maxmemory-policy.", atomic.LoadInt64(&KVCache.memory.evicted))
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	max, _, _ := KVCache.memory.settings()
+	w.single("memory_used_bytes", "gauge", "Estimated memory used by the database.", atomic.LoadInt64(&KVCache.memory.used))
+	w.single("memory_max_bytes", "gauge", "Memory limit(maxmemory), 0 - no limit.", max)
+	w.single("memory_heap_bytes", "gauge", "Heap memory allocated by the server.", mem.HeapAlloc)
+
+	lastSave, _, _, dirtyAtSave := stats.saveState()
+	lastSaveTime := int64(0)
+	if !lastSave.IsZero() {
+		lastSaveTime = lastSave.Unix()
+	}
+	w.single("last_save_timestamp_seconds", "gauge", "Time of the last successful save, 0 - no save since start.", lastSaveTime)
+	w.single("changes_since_last_save", "gauge", "Changes of the database since the last successful save.",
+		atomic.LoadInt64(&KVCache.dirty)-dirtyAtSave)
+	w.metric("save_duration_seconds", "histogram", "Duration of saves of the database.")
+	w.histogram("save_duration_seconds", "", &stats.saves)
+	w.single("save_errors_total", "counter", "Failed saves of the database.", atomic.LoadInt64(&stats.saveErrors))
+
+	if KVCache.aof != nil {
+		_, size, _, _ := KVCache.aof.state()
+		w.single("aof_size_bytes", "gauge", "Size of append-only log.", size)
+		w.single("aof_errors_total", "counter", "Failed writes and fsyncs of append-only log.", atomic.LoadInt64(&KVCache.aof.errors))
+		w.metric("aof_rewrite_duration_seconds", "histogram", "Duration of rewrites of append-only log.")
+		w.histogram("aof_rewrite_duration_seconds", "", &stats.rewrites)
+		w.single("aof_rewrite_errors_total", "counter", "Failed rewrites of append-only log.", atomic.LoadInt64(&stats.rewriteErrors))
+	}
+
+	KVCache.repl.Mut.Lock()
+	replica, replicas, offset := KVCache.repl.primaryAddr != "", len(KVCache.repl.replicas), KVCache.repl.offset
+	KVCache.repl.Mut.Unlock()
+	w.single("replica", "gauge", "1 - if the server is replica, 0 - if it is primary.", boolField(replica))
+	w.single("connected_replicas", "gauge", "Replicas connected to the server.", replicas)
+	w.single("replication_offset", "gauge", "Bytes of replication stream produced(primary) or applied(replica).", offset)
+
+	return w.Bytes()
+}
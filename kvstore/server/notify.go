@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Keyspace notifications - events about changes of keys published to channels:
+//__keyspace__:<key> with event as message and __keyevent__:<event> with key as message.
+//Classes of events and kinds of channels are turned on by flags of notify-keyspace-events parameter(see config command):
+// K - keyspace channels, E - keyevent channels,
+// g - generic events: del, expire, persist, rename_from, rename_to, $ - string events: set, incrby, incrbyfloat,
+// append, setrange, x - expired events, e - evicted events(see memory.go),
+// A - alias for "g$xe".
+//Without K or E nothing is published. Notifications are off by default.
+
+const (
+	notifyKeyspace = 1 << iota //K
+	notifyKeyevent             //E
+	notifyGeneric              //g
+	notifyString               //$
+	notifyExpired              //x
+	notifyEvicted              //e
+
+	notifyAll = notifyGeneric | notifyString | notifyExpired | notifyEvicted //A
+)
+
+var notifyFlagChars = []struct {
+	char byte
+	flag int
+}{
+	{'A', notifyAll},
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+//parseNotifyFlags - parses value of notify-keyspace-events parameter.
+func parseNotifyFlags(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		known := false
+		for _, fc := range notifyFlagChars {
+			if s[i] == fc.char {
+				flags |= fc.flag
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return 0, fmt.Errorf("ERR: Invalid flag of keyspace events: %c. Allowed: K, E, g, $, x, e, A;", s[i])
+		}
+	}
+
+	return flags, nil
+}
+
+//formatNotifyFlags - formats flags as value of notify-keyspace-events parameter.
+func formatNotifyFlags(flags int) string {
+	var b strings.Builder
+	for _, fc := range notifyFlagChars {
+		if flags&fc.flag == fc.flag {
+			b.WriteByte(fc.char)
+			flags &^= fc.flag
+		}
+	}
+
+	return b.String()
+}
+
+//notifyKeyspaceEvent - publishes event of class about key, if notifications of this class are on.
+func (KVCache *KVCache) notifyKeyspaceEvent(class int, event, key string) {
+	KVCache.pubsub.Mut.RLock()
+	flags := KVCache.pubsub.notify
+	KVCache.pubsub.Mut.RUnlock()
+
+	if flags&class == 0 {
+		return
+	}
+
+	if flags&notifyKeyspace != 0 {
+		KVCache.pubsub.publish("__keyspace__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		KVCache.pubsub.publish("__keyevent__:"+event, key)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+const defaultPubSubBufferLimit = 32 * 1024 * 1024
+
+func init() {
+	commands["publish"] = func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		return intReply(KVCache.pubsub.publish(cmd.args[0], cmd.args[1])), nil
+	}
+}
+
+//pubsubCommands - commands, that switch connection to push mode: server sends published messages to client
+//without requests. They are not executed by getResponse, but by handleConnection, as they need the client.
+//Each of them sends one reply per channel or pattern.
+var pubsubCommands = map[string]func(*KVCache, *client, *command) error{
+	//subscribe - subscribe client to channels: subscribe <channel> [<channel> ...]
+	"subscribe": func(KVCache *KVCache, client *client, cmd *command) error {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return err
+		}
+
+		for _, channel := range cmd.args {
+			KVCache.pubsub.subscribe(client, channel, false)
+			err = client.writeReply(arrayReply{bulkReply("subscribe"), bulkReply(channel), intReply(client.subscriptions())})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	//psubscribe - subscribe client to channels matching glob-style patterns: psubscribe <pattern> [<pattern> ...]
+	"psubscribe": func(KVCache *KVCache, client *client, cmd *command) error {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return err
+		}
+
+		for _, pattern := range cmd.args {
+			KVCache.pubsub.subscribe(client, pattern, true)
+			err = client.writeReply(arrayReply{bulkReply("psubscribe"), bulkReply(pattern), intReply(client.subscriptions())})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	//unsubscribe - unsubscribe client from channels, from all of them - if there are no arguments.
+	"unsubscribe": func(KVCache *KVCache, client *client, cmd *command) error {
+		return unsubscribe(KVCache, client, cmd, false)
+	},
+
+	//punsubscribe - unsubscribe client from patterns, from all of them - if there are no arguments.
+	"punsubscribe": func(KVCache *KVCache, client *client, cmd *command) error {
+		return unsubscribe(KVCache, client, cmd, true)
+	},
+}
+
+//pushModeCommands - commands allowed while client has subscriptions.
+var pushModeCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+}
+
+//unsubscribe - executes unsubscribe and punsubscribe.
+func unsubscribe(KVCache *KVCache, client *client, cmd *command, pattern bool) error {
+	name := "unsubscribe"
+	if pattern {
+		name = "punsubscribe"
+	}
+
+	targets := cmd.args
+	if len(targets) == 0 {
+		targets = client.subscribedTo(pattern)
+	}
+
+	if len(targets) == 0 {
+		return client.writeReply(arrayReply{bulkReply(name), nil, intReply(client.subscriptions())})
+	}
+
+	for _, target := range targets {
+		KVCache.pubsub.unsubscribe(client, target, pattern)
+		err := client.writeReply(arrayReply{bulkReply(name), bulkReply(target), intReply(client.subscriptions())})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//pubSub - channels and patterns with their subscribers.
+type pubSub struct {
+	Mut         *sync.RWMutex
+	channels    map[string]map[*client]struct{}
+	patterns    map[string]map[*client]struct{}
+	bufferLimit int //max size of messages queued for single subscriber, bytes
+	notify      int //classes of keyspace events to publish, see notify.go
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{&sync.RWMutex{}, make(map[string]map[*client]struct{}), make(map[string]map[*client]struct{}),
+		defaultPubSubBufferLimit, 0}
+}
+
+//subscribe - adds client to subscribers of channel(or pattern). Switches client to push mode.
+func (ps *pubSub) subscribe(subscriber *client, target string, pattern bool) {
+	ps.Mut.Lock()
+	defer ps.Mut.Unlock()
+
+	if subscriber.push == nil {
+		subscriber.push = newPushQueue(subscriber.conn, ps.bufferLimit)
+		go subscriber.push.writeLoop()
+	}
+
+	subscriptions, subscribers := subscriber.channels, ps.channels
+	if pattern {
+		subscriptions, subscribers = subscriber.patterns, ps.patterns
+	}
+
+	subscriptions[target] = true
+	if subscribers[target] == nil {
+		subscribers[target] = make(map[*client]struct{})
+	}
+	subscribers[target][subscriber] = struct{}{}
+}
+
+//unsubscribe - removes client from subscribers of channel(or pattern).
+func (ps *pubSub) unsubscribe(subscriber *client, target string, pattern bool) {
+	ps.Mut.Lock()
+	defer ps.Mut.Unlock()
+
+	subscriptions, subscribers := subscriber.channels, ps.channels
+	if pattern {
+		subscriptions, subscribers = subscriber.patterns, ps.patterns
+	}
+
+	delete(subscriptions, target)
+	delete(subscribers[target], subscriber)
+	if len(subscribers[target]) == 0 {
+		delete(subscribers, target)
+	}
+}
+
+//unsubscribeAll - removes client from all channels and patterns. Called when client disconnects.
+func (ps *pubSub) unsubscribeAll(subscriber *client) {
+	for _, channel := range subscriber.subscribedTo(false) {
+		ps.unsubscribe(subscriber, channel, false)
+	}
+	for _, pattern := range subscriber.subscribedTo(true) {
+		ps.unsubscribe(subscriber, pattern, true)
+	}
+}
+
+//publish - sends message to subscribers of channel and of patterns matching it.
+//Return amount of clients, that got the message.
+func (ps *pubSub) publish(channel, message string) int {
+	ps.Mut.RLock()
+	defer ps.Mut.RUnlock()
+
+	receivers := 0
+	for client := range ps.channels[channel] {
+		client.push.send(client.encodeReply(arrayReply{bulkReply("message"), bulkReply(channel), bulkReply(message)}))
+		receivers++
+	}
+
+	for pattern, subscribers := range ps.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+
+		for client := range subscribers {
+			client.push.send(client.encodeReply(arrayReply{bulkReply("pmessage"), bulkReply(pattern), bulkReply(channel), bulkReply(message)}))
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+//pushQueue - output buffer of client in push mode. Replies and published messages are queued
+//and written by separate goroutine, so slow subscriber doesn't block publishers.
+//If queued data exceeds limit, the connection is closed.
+type pushQueue struct {
+	Mut     *sync.Mutex
+	conn    net.Conn
+	queue   [][]byte
+	pending int //size of queued and not yet written data, bytes
+	limit   int
+	closed  bool
+	wakeUp  chan struct{}
+	done    chan struct{}
+}
+
+func newPushQueue(conn net.Conn, limit int) *pushQueue {
+	return &pushQueue{Mut: &sync.Mutex{}, conn: conn, limit: limit, wakeUp: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+//send - queues data to be written to client. Closes connection, if output buffer limit is exceeded.
+func (push *pushQueue) send(data []byte) error {
+	push.Mut.Lock()
+	defer push.Mut.Unlock()
+
+	if push.closed {
+		return fmt.Errorf("ERR: Connection is closed. Client addres: %s;", push.conn.RemoteAddr())
+	}
+
+	if push.pending+len(data) > push.limit {
+		push.closed = true
+		push.conn.Close()
+		log.Printf("LOG: Client output buffer limit (%d bytes) exceeded, closing connection. Client addres: %s;", push.limit, push.conn.RemoteAddr())
+		return fmt.Errorf("ERR: Output buffer limit exceeded. Client addres: %s;", push.conn.RemoteAddr())
+	}
+
+	push.queue = append(push.queue, data)
+	push.pending += len(data)
+
+	select {
+	case push.wakeUp <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//writeLoop - writes queued data to client until stop is called or write fails.
+func (push *pushQueue) writeLoop() {
+	for {
+		select {
+		case <-push.wakeUp:
+		case <-push.done:
+			return
+		}
+
+		push.Mut.Lock()
+		queue := push.queue
+		push.queue = nil
+		push.Mut.Unlock()
+
+		buffers := net.Buffers(queue)
+		written, err := buffers.WriteTo(push.conn)
+
+		push.Mut.Lock()
+		push.pending -= int(written)
+		if err != nil && !push.closed {
+			push.closed = true
+			push.conn.Close()
+			log.Printf("ERR: Can't send pushed data: %s. Client addres: %s;", err, push.conn.RemoteAddr())
+		}
+		push.Mut.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+//stop - stops writeLoop.
+func (push *pushQueue) stop() {
+	close(push.done)
+}
+
+//subscriptions - returns amount of channels and patterns client is subscribed to.
+func (client *client) subscriptions() int {
+	return len(client.channels) + len(client.patterns)
+}
+
+//subscribedTo - returns patterns(if pattern is true) or channels client is subscribed to.
+func (client *client) subscribedTo(pattern bool) []string {
+	subscriptions := client.channels
+	if pattern {
+		subscriptions = client.patterns
+	}
+
+	targets := make([]string, 0, len(subscriptions))
+	for target := range subscriptions {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+//matchPattern - check if s matches glob-style pattern:
+//* - any sequence, ? - any symbol, [abc], [^abc], [a-z] - symbol from class, \ - escapes special symbol.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			negate := len(pattern) > 0 && pattern[0] == '^'
+			if negate {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+					matched = matched || pattern[0] == s[0]
+				} else if len(pattern) > 2 && pattern[1] == '-' {
+					low, high := pattern[0], pattern[2]
+					if low > high {
+						low, high = high, low
+					}
+					matched = matched || (s[0] >= low && s[0] <= high)
+					pattern = pattern[2:]
+				} else {
+					matched = matched || pattern[0] == s[0]
+				}
+				pattern = pattern[1:]
+			}
+
+			if matched == negate {
+				return false
+			}
+			s = s[1:]
+
+			//unterminated class
+			if len(pattern) == 0 {
+				return len(s) == 0
+			}
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(s) == 0
+}
+
+//pushModeError - error for commands not allowed in push mode.
+func pushModeError(cmd *command) error {
+	return fmt.Errorf("ERR: Only (p)subscribe, (p)unsubscribe and ping are allowed while subscribed. %s", cmd)
+}
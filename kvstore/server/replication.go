@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Replication: replica connects to primary and sends psync <replication id> <offset>.
+//If primary's backlog still has the stream from that offset, primary answers +CONTINUE <replication id>
+//and sends the rest of the stream. Otherwise it answers +FULLRESYNC <replication id> <offset>,
+//sends snapshot of the database as bulk string($<length>\r\n<snapshot>) and the stream after it.
+//Stream consists of records of append-only log format(see aof.go), offset is amount of bytes of stream.
+//Replica reports applied offset with replconf ack <offset> every second, primary pings replicas every second.
+
+const (
+	replBacklogSize   = 1 << 20          //size of stream kept by primary for partial resync, bytes
+	replBufferLimit   = 64 * 1024 * 1024 //max size of data queued for single replica, bytes
+	replPingInterval  = time.Second
+	replRetryInterval = time.Second
+	replDialTimeout   = 5 * time.Second
+
+	replStateConnecting = "connecting"
+	replStateSync       = "sync"
+	replStateConnected  = "connected"
+)
+
+var errReadOnly = fmt.Errorf("READONLY: You can't write against a read only replica;")
+
+func init() {
+	commands["replicaof"] = replicaofCommand
+	commands["role"] = roleCommand
+}
+
+//replication - state of replication. Server is primary, if primaryAddr is empty, replica - otherwise.
+type replication struct {
+	Mut      *sync.Mutex
+	replID   string //id of the stream
+	offset   int64  //amount of bytes of stream produced(primary) or applied(replica)
+	backlog  *replBacklog
+	replicas map[*client]*replicaLink //replicas connected to primary
+
+	primaryAddr string        //address of primary, empty - if server is primary
+	replica     int32         //1 - if primaryAddr is set, changed atomically with Mut held, so it can be read without Mut
+	state       string        //state of link with primary
+	link        net.Conn      //connection to primary, nil - if it is not established
+	lastIO      time.Time     //last time data was received from primary
+	stop        chan struct{} //closed when server stops being replica of primaryAddr
+	primaryUser string        //user to authenticate on primary with
+	primaryAuth string        //password to authenticate on primary with, empty - primary doesn't need it
+	tlsConfig   *tls.Config   //configuration of TLS connection to primary, nil - connection is plain
+}
+
+//replicaLink - replica connected to primary.
+type replicaLink struct {
+	ackOffset int64
+	ackTime   time.Time
+}
+
+func newReplication() *replication {
+	return &replication{Mut: &sync.Mutex{}, replID: newReplID(), replicas: make(map[*client]*replicaLink)}
+}
+
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//isReplica - check if server is replica of some primary.
+func (repl *replication) isReplica() bool {
+	return atomic.LoadInt32(&repl.replica) == 1
+}
+
+//feed - appends command to replication stream and sends it to replicas.
+//Nothing is done until the first replica connects.
+func (repl *replication) feed(cmd *command) {
+	repl.Mut.Lock()
+	defer repl.Mut.Unlock()
+
+	if repl.backlog == nil {
+		return
+	}
+
+	record := encodeCommand(cmd)
+	repl.backlog.write(record)
+	repl.offset += int64(len(record))
+
+	for replica := range repl.replicas {
+		//replica, that exceeds output buffer limit, is disconnected and will resync
+		replica.push.send(record)
+	}
+}
+
+//dropReplicas - disconnects all replicas and forgets the stream, so they resync from scratch.
+//Called when the database is replaced entirely.
+func (repl *replication) dropReplicas() {
+	repl.Mut.Lock()
+	defer repl.Mut.Unlock()
+
+	for replica := range repl.replicas {
+		replica.conn.Close()
+	}
+	repl.replicas = make(map[*client]*replicaLink)
+	repl.backlog = nil
+	repl.replID = newReplID()
+}
+
+//removeReplica - forgets replica, that disconnected.
+func (repl *replication) removeReplica(client *client) {
+	repl.Mut.Lock()
+	delete(repl.replicas, client)
+	repl.Mut.Unlock()
+}
+
+//replicationCron - pings replicas, so they can detect broken link with primary.
+func (KVCache *KVCache) replicationCron() {
+	for KVCache.shutdown.sleep(replPingInterval) {
+		KVCache.repl.Mut.Lock()
+		hasReplicas := len(KVCache.repl.replicas) > 0
+		KVCache.repl.Mut.Unlock()
+
+		if hasReplicas {
+			KVCache.repl.feed(&command{"ping", nil})
+		}
+	}
+}
+
+//replicationCommands - commands sent by replica to primary. They are not executed by getResponse,
+//but by handleConnection, as they need the client.
+var replicationCommands = map[string]func(*KVCache, *client, *command) error{
+	//psync - start replication: psync <replication id> <offset>. Use "?" and -1 to request full resync.
+	"psync": func(KVCache *KVCache, client *client, cmd *command) error {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return err
+		}
+
+		offset, err := strconv.ParseInt(cmd.args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("ERR: Offset is not an integer: %s;", cmd.args[1])
+		}
+
+		if client.push != nil {
+			return fmt.Errorf("ERR: psync is not allowed for client in push mode(subscribed or replica already);")
+		}
+
+		//other commands are stopped, so snapshot and offset of the stream match
+		KVCache.txMut.Lock()
+		defer KVCache.txMut.Unlock()
+
+		repl := KVCache.repl
+		repl.Mut.Lock()
+		defer repl.Mut.Unlock()
+
+		if repl.primaryAddr != "" {
+			return fmt.Errorf("ERR: Replica can't have replicas;")
+		}
+
+		if repl.backlog == nil {
+			repl.backlog = newReplBacklog(replBacklogSize, repl.offset)
+		}
+
+		var payload []byte
+		stream, ok := repl.backlog.since(offset)
+		if cmd.args[0] == repl.replID && ok {
+			payload = append([]byte("+CONTINUE "+repl.replID+"\r\n"), stream...)
+			log.Printf("LOG: Partial resync of replica from offset %d. Client addres: %s;", offset, client.conn.RemoteAddr())
+		} else {
+			snapshot := encodeSnapshot(KVCache)
+			payload = []byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", repl.replID, repl.offset, len(snapshot)))
+			payload = append(payload, snapshot...)
+			offset = repl.offset
+			log.Printf("LOG: Full resync of replica at offset %d. Client addres: %s;", offset, client.conn.RemoteAddr())
+		}
+
+		client.push = newPushQueue(client.conn, len(payload)+replBufferLimit)
+		go client.push.writeLoop()
+		repl.replicas[client] = &replicaLink{ackOffset: offset, ackTime: time.Now()}
+
+		return client.push.send(payload)
+	},
+
+	//replconf - replica reports applied offset: replconf ack <offset>. There is no reply.
+	"replconf": func(KVCache *KVCache, client *client, cmd *command) error {
+		if len(cmd.args) != 2 || strings.ToLower(cmd.args[0]) != "ack" {
+			return fmt.Errorf("ERR: Invalid arguments. Should be: replconf ack <offset>. %s;", cmd)
+		}
+
+		offset, err := strconv.ParseInt(cmd.args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("ERR: Offset is not an integer: %s;", cmd.args[1])
+		}
+
+		KVCache.repl.Mut.Lock()
+		defer KVCache.repl.Mut.Unlock()
+
+		if replica, ok := KVCache.repl.replicas[client]; ok {
+			replica.ackOffset = offset
+			replica.ackTime = time.Now()
+		}
+		return nil
+	},
+}
+
+//replicaofCommand - make server replica of primary: replicaof <host> <port>,
+//or stop replication and make server primary: replicaof no one.
+//Replica drops its database and loads the primary's one, writes from clients are rejected.
+func replicaofCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	err := validateArgsCount(cmd, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(cmd.args[0]) == "no" && strings.ToLower(cmd.args[1]) == "one" {
+		KVCache.stopReplication()
+		return okReply{}, nil
+	}
+
+	if _, err := strconv.ParseUint(cmd.args[1], 10, 16); err != nil {
+		return nil, fmt.Errorf("ERR: Port is not valid: %s;", cmd.args[1])
+	}
+
+	KVCache.startReplication(net.JoinHostPort(cmd.args[0], cmd.args[1]))
+	return okReply{}, nil
+}
+
+//roleCommand - return role of server with state of replication.
+//Primary: master, offset, list of replicas: address, acknowledged offset, seconds since the last acknowledgement.
+//Replica: slave, host and port of primary, state of link, offset, seconds since the last data from primary.
+func roleCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	err := validateArgsCount(cmd, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	repl := KVCache.repl
+	repl.Mut.Lock()
+	defer repl.Mut.Unlock()
+
+	if repl.primaryAddr == "" {
+		replicas := arrayReply{}
+		for replica, link := range repl.replicas {
+			replicas = append(replicas, arrayReply{bulkReply(replica.conn.RemoteAddr().String()),
+				intReply(link.ackOffset), intReply(time.Since(link.ackTime) / time.Second)})
+		}
+		return arrayReply{bulkReply("master"), intReply(repl.offset), replicas}, nil
+	}
+
+	host, port, _ := net.SplitHostPort(repl.primaryAddr)
+	portNumber, _ := strconv.Atoi(port)
+	lag := intReply(-1)
+	if !repl.lastIO.IsZero() {
+		lag = intReply(time.Since(repl.lastIO) / time.Second)
+	}
+	return arrayReply{bulkReply("slave"), bulkReply(host), intReply(portNumber), bulkReply(repl.state), intReply(repl.offset), lag}, nil
+}
+
+//startReplication - makes server replica of primary at addr.
+func (KVCache *KVCache) startReplication(addr string) {
+	KVCache.stopReplication()
+
+	//replicas of this server would get stream of other primary, so they are dropped
+	KVCache.repl.dropReplicas()
+
+	repl := KVCache.repl
+	repl.Mut.Lock()
+	repl.primaryAddr = addr
+	atomic.StoreInt32(&repl.replica, 1)
+	repl.state = replStateConnecting
+	repl.lastIO = time.Time{}
+	repl.stop = make(chan struct{})
+	stop := repl.stop
+	repl.Mut.Unlock()
+
+	log.Printf("LOG: Replication from primary %s started;", addr)
+	go KVCache.replicate(addr, stop)
+}
+
+//stopReplication - breaks link with primary, server becomes primary itself.
+func (KVCache *KVCache) stopReplication() {
+	repl := KVCache.repl
+	repl.Mut.Lock()
+	defer repl.Mut.Unlock()
+
+	if repl.primaryAddr == "" {
+		return
+	}
+
+	close(repl.stop)
+	if repl.link != nil {
+		repl.link.Close()
+	}
+
+	log.Printf("LOG: Replication from primary %s stopped;", repl.primaryAddr)
+	repl.primaryAddr = ""
+	atomic.StoreInt32(&repl.replica, 0)
+	repl.link = nil
+	repl.replID = newReplID()
+}
+
+//replicate - keeps server in sync with primary, reconnecting when link is broken, until stop is closed.
+func (KVCache *KVCache) replicate(addr string, stop chan struct{}) {
+	for {
+		err := KVCache.syncWithPrimary(addr, stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("ERR: Replication link with primary %s is broken: %s;", addr, err)
+
+		KVCache.repl.Mut.Lock()
+		KVCache.repl.state = replStateConnecting
+		KVCache.repl.Mut.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(replRetryInterval):
+		}
+	}
+}
+
+//syncWithPrimary - connects to primary, resyncs and applies stream of commands until link is broken.
+func (KVCache *KVCache) syncWithPrimary(addr string, stop chan struct{}) error {
+	var conn net.Conn
+	var err error
+	if KVCache.repl.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: replDialTimeout}, "tcp", addr, KVCache.repl.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, replDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	repl := KVCache.repl
+	repl.Mut.Lock()
+	select {
+	case <-stop:
+		repl.Mut.Unlock()
+		return nil
+	default:
+	}
+	repl.link = conn
+	repl.state = replStateSync
+	replID, offset := repl.replID, repl.offset
+	user, password := repl.primaryUser, repl.primaryAuth
+	repl.Mut.Unlock()
+
+	reader := bufio.NewReader(conn)
+	if password != "" {
+		_, err = conn.Write(encodeRESP(arrayReply{bulkReply("auth"), bulkReply(user), bulkReply(password)}))
+		if err != nil {
+			return err
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("authentication on primary failed: %q", strings.TrimSpace(line))
+		}
+	}
+
+	if offset == 0 {
+		replID, offset = "?", -1
+	}
+
+	_, err = conn.Write(encodeRESP(arrayReply{bulkReply("psync"), bulkReply(replID), bulkReply(strconv.FormatInt(offset, 10))}))
+	if err != nil {
+		return err
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		offset, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad offset in reply to psync: %q", line)
+		}
+
+		err = KVCache.loadFromPrimary(reader)
+		if err != nil {
+			return err
+		}
+
+		repl.Mut.Lock()
+		repl.replID, repl.offset = fields[1], offset
+		repl.Mut.Unlock()
+		log.Printf("LOG: Full resync with primary %s is done, offset: %d;", addr, offset)
+
+	case len(fields) == 2 && fields[0] == "+CONTINUE":
+		log.Printf("LOG: Partial resync with primary %s from offset %d;", addr, offset)
+
+	default:
+		return fmt.Errorf("unexpected reply to psync: %q", strings.TrimSpace(line))
+	}
+
+	repl.Mut.Lock()
+	repl.state = replStateConnected
+	repl.lastIO = time.Now()
+	repl.Mut.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go KVCache.ackPrimary(conn, done)
+
+	for {
+		cmd, err := decodeCommand(reader)
+		if err != nil {
+			return err
+		}
+
+		if cmd.name != "ping" {
+			executor, ok := commands[cmd.name]
+			if !ok {
+				return fmt.Errorf("unknown command in replication stream: %s", cmd)
+			}
+
+			KVCache.txMut.RLock()
+			executeWrite(KVCache, cmd, executor)
+			KVCache.txMut.RUnlock()
+		}
+
+		repl.Mut.Lock()
+		repl.offset += int64(len(encodeCommand(cmd)))
+		repl.lastIO = time.Now()
+		repl.Mut.Unlock()
+	}
+}
+
+//loadFromPrimary - reads snapshot sent by primary and replaces the database with it.
+func (KVCache *KVCache) loadFromPrimary(reader *bufio.Reader) error {
+	line, err := readUntil(reader, '\n', maxRequestLengthDigits)
+	if err != nil {
+		return err
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+	if !strings.HasPrefix(line, "$") || err != nil || length < 0 {
+		return fmt.Errorf("bad length of snapshot: %q", line)
+	}
+
+	//memory is allocated as snapshot is received, not in advance for length, that primary has sent
+	var data bytes.Buffer
+	_, err = io.CopyN(&data, reader, int64(length))
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, err := decodeSnapshot(data.Bytes())
+	if err != nil {
+		return fmt.Errorf("bad snapshot from primary: %s", err)
+	}
+
+	KVCache.txMut.Lock()
+	defer KVCache.txMut.Unlock()
+
+	KVCache.loadSnapshot(entries)
+	propagate(KVCache, &command{"restore", nil}, nil)
+
+	return nil
+}
+
+//ackPrimary - reports applied offset to primary every second until done is closed.
+func (KVCache *KVCache) ackPrimary(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		KVCache.repl.Mut.Lock()
+		offset := KVCache.repl.offset
+		KVCache.repl.Mut.Unlock()
+
+		_, err := conn.Write(encodeRESP(arrayReply{bulkReply("replconf"), bulkReply("ack"), bulkReply(strconv.FormatInt(offset, 10))}))
+		if err != nil {
+			return
+		}
+	}
+}
+
+//replBacklog - ring buffer with the last bytes of replication stream.
+type replBacklog struct {
+	buf    []byte
+	end    int64 //offset of stream after the last byte in buffer
+	length int   //amount of bytes of stream in buffer
+}
+
+func newReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{buf: make([]byte, size), end: offset}
+}
+
+func (b *replBacklog) write(data []byte) {
+	for len(data) > 0 {
+		n := copy(b.buf[b.end%int64(len(b.buf)):], data)
+		data = data[n:]
+		b.end += int64(n)
+		b.length += n
+	}
+
+	if b.length > len(b.buf) {
+		b.length = len(b.buf)
+	}
+}
+
+//since - returns stream from offset, false - if buffer doesn't have it.
+func (b *replBacklog) since(offset int64) ([]byte, bool) {
+	if offset > b.end || offset < b.end-int64(b.length) {
+		return nil, false
+	}
+
+	data := make([]byte, b.end-offset)
+	n := copy(data, b.buf[offset%int64(len(b.buf)):])
+	copy(data[n:], b.buf)
+
+	return data, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplicaHidesExpiredKeys(t *testing.T) {
+	rc := newTestCache(t)
+
+	_, err := run(t, rc, "set", "k", "v", "px", "100000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.setExpiration("k", time.Now().Add(-time.Second))
+
+	//replica doesn't see expired key, but keeps it until del from primary
+	atomic.StoreInt32(&rc.repl.replica, 1)
+	for _, args := range [][]string{{"get", "k"}, {"exists", "k"}, {"get", "k"}} {
+		result, err := run(t, rc, args...)
+		if _, ok := err.(*nilError); !ok && result != intReply(0) {
+			t.Errorf("%v on replica: got %v, %v, want no key", args, result, err)
+		}
+	}
+	if _, ok := rc.lookup("k"); !ok {
+		t.Fatal("replica removed expired key")
+	}
+
+	//primary removes it
+	atomic.StoreInt32(&rc.repl.replica, 0)
+	_, err = run(t, rc, "get", "k")
+	if _, ok := err.(*nilError); !ok {
+		t.Errorf("get on primary: got error %v, want nil reply", err)
+	}
+	if _, ok := rc.lookup("k"); ok {
+		t.Error("primary didn't remove expired key")
+	}
+}
+
+func TestLoadFromPrimary(t *testing.T) {
+	primary := newTestCache(t)
+	fill(t, primary)
+	snapshot := encodeSnapshot(primary)
+
+	replica := newTestCache(t)
+	err := replica.loadFromPrimary(bufio.NewReader(strings.NewReader(fmt.Sprintf("$%d\r\n%s", len(snapshot), snapshot))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dump(t, replica), dump(t, primary); !reflect.DeepEqual(got, want) {
+		t.Errorf("replica's database:\n%q\nwant:\n%q", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"huge length", "$9223372036854775807\r\n" + string(snapshot), io.ErrUnexpectedEOF},
+		{"length beyond end of stream", "$400000000\r\n" + string(snapshot), io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		err := newTestCache(t).loadFromPrimary(bufio.NewReader(strings.NewReader(test.input)))
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	err = newTestCache(t).loadFromPrimary(bufio.NewReader(strings.NewReader("$-1\r\n")))
+	if err == nil || !strings.HasPrefix(err.Error(), "bad length of snapshot") {
+		t.Errorf("negative length: got error %v", err)
+	}
+}
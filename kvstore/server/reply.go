@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+//reply - result of the command. Every protocol renders it in its own way:
+//netstring clients get plain text, RESP clients get typed replies.
+//nil reply means absence of value.
+type reply interface{}
+
+//okReply - command succeeded and has nothing to return. Netstring: "true", RESP: +OK
+type okReply struct{}
+
+//statusReply - short single line message. Netstring: as is, RESP: simple string
+type statusReply string
+
+//boolReply - netstring: "true"/"false", RESP: integer 1/0
+type boolReply bool
+
+//intReply - netstring: number as text, RESP: integer
+type intReply int64
+
+//bulkReply - binary safe string. Netstring: as is, RESP: bulk string
+type bulkReply string
+
+//arrayReply - list of replies. Netstring: numbered lines, RESP: array
+type arrayReply []reply
+
+//errorReply - error as element of array reply(results of transaction). Netstring: message, RESP: error
+type errorReply struct {
+	err error
+}
+
+//nilError - error reported to RESP clients as nil reply. Netstring clients get the message itself.
+type nilError struct {
+	msg string
+}
+
+func (err *nilError) Error() string {
+	return err.msg
+}
+
+//noSuchElement - returns error for absent key.
+func noSuchElement(key string) error {
+	return &nilError{"ERR: NO SUCH ELEMENT: key = " + key + ";"}
+}
+
+//replyStrings - returns strings from bulk reply or array of bulk replies.
+func replyStrings(r reply) []string {
+	switch r := r.(type) {
+	case bulkReply:
+		return []string{string(r)}
+	case arrayReply:
+		items := make([]string, 0, len(r))
+		for _, el := range r {
+			if s, ok := el.(bulkReply); ok {
+				items = append(items, string(s))
+			}
+		}
+		return items
+	}
+
+	return nil
+}
+
+//formatReply - renders reply as text for netstring clients.
+func formatReply(r reply) string {
+	return formatReplyIndent(r, "")
+}
+
+func formatReplyIndent(r reply, indent string) string {
+	switch r := r.(type) {
+	case nil:
+		return "(nil)"
+	case okReply:
+		return "true"
+	case statusReply:
+		return string(r)
+	case boolReply:
+		return strconv.FormatBool(bool(r))
+	case intReply:
+		return strconv.FormatInt(int64(r), 10)
+	case bulkReply:
+		return string(r)
+	case errorReply:
+		return r.err.Error()
+	case arrayReply:
+		if len(r) == 0 {
+			return "(empty list)"
+		}
+
+		lines := make([]string, len(r))
+		for i, el := range r {
+			prefix := strconv.Itoa(i+1) + ") "
+			lines[i] = prefix + formatReplyIndent(el, indent+strings.Repeat(" ", len(prefix)))
+		}
+		return strings.Join(lines, "\n"+indent)
+	}
+
+	return ""
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//RESP2 - protocol of Redis. Requests are arrays of bulk strings:
+//
+//	*<count>\r\n$<length>\r\n<argument>\r\n...
+//
+//or inline commands - single line with arguments separated by spaces.
+
+const (
+	maxRESPArgs      = 1024 * 1024
+	maxRESPBulkBytes = 512 * 1024 * 1024
+
+	defaultMaxRequestSize  = maxRESPBulkBytes //default of -max-request-size
+	maxRequestLengthDigits = 24               //max length of line with length of request or argument
+)
+
+//errRequestTooLarge - request is larger than -max-request-size. Connection is closed, as the rest of request can't be skipped safely.
+func errRequestTooLarge(size, maxSize int) error {
+	return fmt.Errorf("ERR: Protocol error: request is too large: %d bytes, max request size: %d bytes;", size, maxSize)
+}
+
+//aliases - names of Redis commands, which are implemented here under other names.
+var aliases = map[string]string{
+	"expire": "ex",
+}
+
+//requestError - request could not be parsed. The client is told about it, but connection is not closed.
+type requestError struct {
+	err error
+}
+
+func (err *requestError) Error() string {
+	return err.err.Error()
+}
+
+//readRESPCommand - reads single request in RESP format, which is not larger than maxSize bytes(sum of arguments).
+//Returns *requestError - if inline request can't be parsed, other errors - if connection can't be used anymore.
+func readRESPCommand(reader *bufio.Reader, maxSize int) (*command, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] != '*' {
+		return readInlineCommand(reader, maxSize)
+	}
+
+	count, err := readRESPNumber(reader, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	if count < 1 || count > maxRESPArgs {
+		return nil, fmt.Errorf("ERR: Protocol error: invalid multibulk length: %d;", count)
+	}
+
+	size := 0
+	args := make([]string, count)
+	for i := range args {
+		length, err := readRESPNumber(reader, '$')
+		if err != nil {
+			return nil, err
+		}
+
+		if length < 0 || length > maxRESPBulkBytes {
+			return nil, fmt.Errorf("ERR: Protocol error: invalid bulk length: %d;", length)
+		}
+
+		size += length
+		if size > maxSize {
+			return nil, errRequestTooLarge(size, maxSize)
+		}
+
+		arg := make([]byte, length+2)
+		_, err = io.ReadFull(reader, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, fmt.Errorf("ERR: Protocol error: bulk string is not terminated by CRLF;")
+		}
+		args[i] = string(arg[:length])
+	}
+
+	return &command{args[0], args[1:]}, nil
+}
+
+//readInlineCommand - reads request sent as single line(for example, by telnet).
+func readInlineCommand(reader *bufio.Reader, maxSize int) (*command, error) {
+	line, err := readUntil(reader, '\n', maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return nil, &requestError{fmt.Errorf("ERR: empty request;")}
+	}
+
+	cmd, err := parseRequest(line)
+	if err != nil {
+		return nil, &requestError{err}
+	}
+
+	return cmd, nil
+}
+
+//readRESPNumber - reads line like "*3\r\n" or "$5\r\n" and returns the number.
+func readRESPNumber(reader *bufio.Reader, prefix byte) (int, error) {
+	line, err := readUntil(reader, '\n', maxRequestLengthDigits)
+	if err != nil {
+		return -1, err
+	}
+
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return -1, fmt.Errorf("ERR: Protocol error: expected '%c', got: %q;", prefix, line)
+	}
+
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil {
+		return -1, fmt.Errorf("ERR: Protocol error: invalid number: %q;", line)
+	}
+
+	return n, nil
+}
+
+//readUntil - reads data up to and including delim, which is not longer than maxSize bytes(without delim).
+//Unlike ReadString it doesn't buffer unlimited amount of data, if delim never comes.
+func readUntil(reader *bufio.Reader, delim byte, maxSize int) (string, error) {
+	var data []byte
+	for {
+		chunk, err := reader.ReadSlice(delim)
+		if len(data)+len(chunk) > maxSize+1 {
+			return "", fmt.Errorf("ERR: Protocol error: too long line, max length: %d bytes;", maxSize)
+		}
+		data = append(data, chunk...)
+
+		if err != bufio.ErrBufferFull {
+			return string(data), err
+		}
+	}
+}
+
+//encodeRESP - encodes reply in RESP format.
+func encodeRESP(r reply) []byte {
+	return appendRESP(nil, r)
+}
+
+func appendRESP(buf []byte, r reply) []byte {
+	switch r := r.(type) {
+	case nil:
+		return append(buf, "$-1\r\n"...)
+	case okReply:
+		return append(buf, "+OK\r\n"...)
+	case statusReply:
+		return append(append(append(buf, '+'), oneLine(string(r))...), "\r\n"...)
+	case boolReply:
+		if r {
+			return append(buf, ":1\r\n"...)
+		}
+		return append(buf, ":0\r\n"...)
+	case intReply:
+		return append(strconv.AppendInt(append(buf, ':'), int64(r), 10), "\r\n"...)
+	case bulkReply:
+		buf = append(strconv.AppendInt(append(buf, '$'), int64(len(r)), 10), "\r\n"...)
+		return append(append(buf, r...), "\r\n"...)
+	case errorReply:
+		return append(buf, encodeRESPError(r.err)...)
+	case arrayReply:
+		buf = append(strconv.AppendInt(append(buf, '*'), int64(len(r)), 10), "\r\n"...)
+		for _, el := range r {
+			buf = appendRESP(buf, el)
+		}
+		return buf
+	}
+
+	return append(buf, "-ERR unknown reply type\r\n"...)
+}
+
+//encodeRESPError - encodes error in RESP format. Messages without error code get "ERR" prefix.
+func encodeRESPError(err error) []byte {
+	if _, ok := err.(*nilError); ok {
+		return encodeRESP(nil)
+	}
+
+	msg := oneLine(err.Error())
+	if !hasErrorCode(msg) {
+		msg = "ERR " + msg
+	}
+
+	return []byte("-" + msg + "\r\n")
+}
+
+//hasErrorCode - check if message starts with upper case word like "ERR" followed by space or colon.
+func hasErrorCode(msg string) bool {
+	i := 0
+	for i < len(msg) && msg[i] >= 'A' && msg[i] <= 'Z' {
+		i++
+	}
+
+	return i > 0 && (i == len(msg) || msg[i] == ' ' || msg[i] == ':')
+}
+
+//oneLine - replaces line breaks, which are not allowed in simple strings and errors.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		input   string
+		maxSize int
+		want    *command
+		err     string //prefix of error, empty - no error
+		request bool   //error is *requestError, connection stays open
+	}{
+		{"*1\r\n$4\r\nping\r\n", 100, &command{"ping", []string{}}, "", false},
+		{"*3\r\n$3\r\nset\r\n$1\r\nk\r\n$5\r\na b\r\n\r\n", 100, &command{"set", []string{"k", "a b\r\n"}}, "", false},
+		{"*2\r\n$3\r\nget\r\n$0\r\n\r\n", 100, &command{"get", []string{""}}, "", false},
+		{"set k 'a b'\r\n", 100, &command{"set", []string{"k", "a b"}}, "", false},
+		{"get k\n", 100, &command{"get", []string{"k"}}, "", false},
+		{"\r\n", 100, nil, "ERR: empty request", true},
+		{"set k 'v\r\n", 100, nil, "Unclosed quote", true},
+		{"*0\r\n", 100, nil, "ERR: Protocol error: invalid multibulk length", false},
+		{"*x\r\n", 100, nil, "ERR: Protocol error: invalid number", false},
+		{"*1\r\n:4\r\nping\r\n", 100, nil, "ERR: Protocol error: expected '$'", false},
+		{"*1\r\n$-1\r\n", 100, nil, "ERR: Protocol error: invalid bulk length", false},
+		{"*1\r\n$4\r\npingXX", 100, nil, "ERR: Protocol error: bulk string is not terminated by CRLF", false},
+		{"*2\r\n$3\r\nget\r\n$5\r\nabcde\r\n", 7, nil, "ERR: Protocol error: request is too large", false},
+		{"get " + strings.Repeat("k", 10) + "\r\n", 8, nil, "ERR: Protocol error: too long line", false},
+		{"*1\r\n$4\r\npi", 100, nil, "unexpected EOF", false},
+	}
+
+	for _, test := range tests {
+		cmd, err := readRESPCommand(bufio.NewReader(strings.NewReader(test.input)), test.maxSize)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", test.input, err)
+			} else if !reflect.DeepEqual(cmd, test.want) {
+				t.Errorf("%q: got %#v, want %#v", test.input, cmd, test.want)
+			}
+			continue
+		}
+
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want %s", test.input, err, test.err)
+			continue
+		}
+		var requestErr *requestError
+		if errors.As(err, &requestErr) != test.request {
+			t.Errorf("%q: error %v is request error: %t, want %t", test.input, err, !test.request, test.request)
+		}
+	}
+}
+
+func TestReadRESPCommandPipeline(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nget\r\n$1\r\na\r\nget b\r\n*1\r\n$4\r\nping\r\n"))
+	want := []*command{{"get", []string{"a"}}, {"get", []string{"b"}}, {"ping", []string{}}}
+
+	for _, w := range want {
+		cmd, err := readRESPCommand(reader, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cmd, w) {
+			t.Errorf("got %#v, want %#v", cmd, w)
+		}
+	}
+
+	_, err := readRESPCommand(reader, 100)
+	if err == nil {
+		t.Error("expected EOF after the last command")
+	}
+}
+
+func TestEncodeRESP(t *testing.T) {
+	tests := []struct {
+		reply reply
+		want  string
+	}{
+		{nil, "$-1\r\n"},
+		{okReply{}, "+OK\r\n"},
+		{statusReply("PONG"), "+PONG\r\n"},
+		{statusReply("a\r\nb"), "+a  b\r\n"},
+		{boolReply(true), ":1\r\n"},
+		{boolReply(false), ":0\r\n"},
+		{intReply(-42), ":-42\r\n"},
+		{bulkReply(""), "$0\r\n\r\n"},
+		{bulkReply("a\r\nb"), "$4\r\na\r\nb\r\n"},
+		{arrayReply{}, "*0\r\n"},
+		{arrayReply{intReply(1), nil, arrayReply{bulkReply("x")}}, "*3\r\n:1\r\n$-1\r\n*1\r\n$1\r\nx\r\n"},
+		{errorReply{errors.New("WRONGTYPE: bad")}, "-WRONGTYPE: bad\r\n"},
+		{struct{}{}, "-ERR unknown reply type\r\n"},
+	}
+
+	for _, test := range tests {
+		got := string(encodeRESP(test.reply))
+		if got != test.want {
+			t.Errorf("%#v: got %q, want %q", test.reply, got, test.want)
+		}
+	}
+}
+
+func TestEncodeRESPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("ERR: Unknown command"), "-ERR: Unknown command\r\n"},
+		{errors.New("NOAUTH Authentication required"), "-NOAUTH Authentication required\r\n"},
+		{errors.New("Unclosed quote"), "-ERR Unclosed quote\r\n"},
+		{errors.New("ERR"), "-ERR\r\n"},
+		{errors.New("line\nbreak"), "-ERR line break\r\n"},
+		{&nilError{"ERR: NO SUCH ELEMENT"}, "$-1\r\n"},
+	}
+
+	for _, test := range tests {
+		got := string(encodeRESPError(test.err))
+		if got != test.want {
+			t.Errorf("%v: got %q, want %q", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+//Scripts are written in Lua and executed atomically: no other command runs while script is running.
+//Script gets keys in KEYS table and other arguments in ARGV table, and executes commands with
+//call(name, arg, ...) (redis.call works too). call raises error, if command fails, pcall returns {err = message} instead.
+//Commands, that modify the database, are written to append-only log one by one, so script itself is not logged.
+//
+//Conversion of command's replies to Lua values: integer -> number, string -> string, nil -> false,
+//list -> table, status -> {ok = status}, error -> {err = message}.
+//Conversion of script's result back: number -> integer(fraction is dropped), string -> string, true -> 1, false or nil -> nil,
+//table -> list(up to the first nil), {ok = status} -> status, {err = message} -> error.
+
+const scriptTimeout = 5 * time.Second
+
+//scriptResultMaxDepth - maximum nesting of tables in result of script
+const scriptResultMaxDepth = 1000
+
+//errScriptResultDepth - result of script is nested too deep or contains itself
+var errScriptResultDepth = fmt.Errorf("ERR: Script result is too deep or recursive;")
+
+func init() {
+	userCommands["eval"] = evalCommand
+	userCommands["evalsha"] = evalshaCommand
+
+	//without user call() of script fails, clients get executors for their user, see commandExecutor
+	commands["eval"] = evalCommand("")
+	commands["evalsha"] = evalshaCommand("")
+	commands["script"] = scriptCommand
+
+	exclusiveCommands["eval"] = true
+	exclusiveCommands["evalsha"] = true
+}
+
+//evalCommand - returns executor of eval <script> <numkeys> [<key> ...] [<arg> ...], that runs script as user.
+func evalCommand(user string) func(*KVCache, *command) (reply, error) {
+	return func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		KVCache.scripts.add(cmd.args[0])
+		return runScript(KVCache, user, cmd.args[0], cmd.args[1:])
+	}
+}
+
+//evalshaCommand - returns executor of evalsha <sha1> <numkeys> [<key> ...] [<arg> ...], that runs cached script as user.
+func evalshaCommand(user string) func(*KVCache, *command) (reply, error) {
+	return func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		script, ok := KVCache.scripts.get(cmd.args[0])
+		if !ok {
+			return nil, fmt.Errorf("NOSCRIPT: No matching script. Please use eval;")
+		}
+		return runScript(KVCache, user, script, cmd.args[1:])
+	}
+}
+
+//scriptCommand - manage cache of scripts:
+//script load <script> - add script to cache without executing. Return its sha1 digest to use with evalsha.
+//script exists <sha1> [<sha1> ...] - check if scripts are in cache.
+//script flush - remove all scripts from cache.
+func scriptCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	if len(cmd.args) == 0 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: script load|exists|flush. %s;", cmd)
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "load":
+		if len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: script load <script>. %s;", cmd)
+		}
+
+		L := lua.NewState(lua.Options{SkipOpenLibs: true})
+		defer L.Close()
+
+		_, err := L.LoadString(cmd.args[1])
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Error compiling script: %s;", oneLine(err.Error()))
+		}
+		return bulkReply(KVCache.scripts.add(cmd.args[1])), nil
+
+	case "exists":
+		if len(cmd.args) < 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: script exists <sha1> [<sha1> ...]. %s;", cmd)
+		}
+
+		result := make(arrayReply, len(cmd.args)-1)
+		for i, sha := range cmd.args[1:] {
+			_, ok := KVCache.scripts.get(sha)
+			result[i] = boolReply(ok)
+		}
+		return result, nil
+
+	case "flush":
+		KVCache.scripts.flush()
+		return okReply{}, nil
+	}
+
+	return nil, fmt.Errorf("ERR: Unknown subcommand: %s. Should be load, exists or flush. %s;", cmd.args[0], cmd)
+}
+
+//scriptCache - scripts by sha1 digest of their text.
+type scriptCache struct {
+	Mut   *sync.RWMutex
+	bySHA map[string]string
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{&sync.RWMutex{}, make(map[string]string)}
+}
+
+//add - adds script to cache, returns its sha1 digest.
+func (cache *scriptCache) add(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	cache.Mut.Lock()
+	cache.bySHA[sha] = script
+	cache.Mut.Unlock()
+
+	return sha
+}
+
+func (cache *scriptCache) get(sha string) (string, bool) {
+	cache.Mut.RLock()
+	defer cache.Mut.RUnlock()
+
+	script, ok := cache.bySHA[strings.ToLower(sha)]
+	return script, ok
+}
+
+func (cache *scriptCache) flush() {
+	cache.Mut.Lock()
+	cache.bySHA = make(map[string]string)
+	cache.Mut.Unlock()
+}
+
+//runScript - executes script with arguments: <numkeys> [<key> ...] [<arg> ...]. Script runs commands as user.
+//Must be called with KVCache.txMut held exclusively.
+func runScript(KVCache *KVCache, user, script string, args []string) (reply, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return nil, fmt.Errorf("ERR: Number of keys is not an integer or is out of range: %s;", args[0])
+	}
+
+	L := newScriptState()
+	defer L.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	L.SetGlobal("KEYS", stringsTable(L, args[1:1+numKeys]))
+	L.SetGlobal("ARGV", stringsTable(L, args[1+numKeys:]))
+
+	call := L.NewFunction(func(L *lua.LState) int {
+		return scriptCall(KVCache, user, L, true)
+	})
+	pcall := L.NewFunction(func(L *lua.LState) int {
+		return scriptCall(KVCache, user, L, false)
+	})
+
+	L.SetGlobal("call", call)
+	redis := L.NewTable()
+	redis.RawSetString("call", call)
+	redis.RawSetString("pcall", pcall)
+	L.SetGlobal("redis", redis)
+
+	fn, err := L.LoadString(script)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: Error compiling script: %s;", oneLine(err.Error()))
+	}
+
+	L.Push(fn)
+	err = L.PCall(0, 1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: Error running script: %s;", scriptErrorMessage(err))
+	}
+
+	return fromLua(L.Get(-1), map[*lua.LTable]bool{})
+}
+
+//scriptErrorMessage - returns message of Lua error without stack traceback.
+func scriptErrorMessage(err error) string {
+	if apiErr, ok := err.(*lua.ApiError); ok && apiErr.Object != nil {
+		return strings.TrimSuffix(oneLine(apiErr.Object.String()), ";")
+	}
+
+	return strings.TrimSuffix(oneLine(err.Error()), ";")
+}
+
+//newScriptState - creates Lua interpreter with libraries, that don't have access to files and OS.
+func newScriptState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	return L
+}
+
+//scriptCall - executes command from script as user. Arguments of command are on the stack of L.
+//If raise is true, error of command is raised as Lua error, otherwise it is returned as {err = message}.
+func scriptCall(KVCache *KVCache, user string, L *lua.LState, raise bool) int {
+	if L.GetTop() == 0 {
+		L.RaiseError("Please specify at least one argument for call()")
+	}
+
+	args := make([]string, L.GetTop())
+	for i := range args {
+		switch value := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = string(value)
+		case lua.LNumber:
+			args[i] = value.String()
+		default:
+			L.RaiseError("Command arguments must be strings or integers")
+		}
+	}
+
+	cmd := &command{strings.ToLower(args[0]), args[1:]}
+	if name, ok := aliases[cmd.name]; ok {
+		cmd.name = name
+	}
+
+	_, ok := commands[cmd.name]
+	if !ok || exclusiveCommands[cmd.name] || cmd.name == "script" {
+		L.RaiseError("Unknown command or command not allowed from script: %s", cmd.name)
+	}
+
+	//script may run only commands and keys allowed to the client, that runs it
+	var result reply
+	err := KVCache.acl.check(user, cmd)
+	if err == nil {
+		result, err = execute(KVCache, cmd, commandExecutor(cmd.name, user))
+	}
+	if _, ok := err.(*nilError); ok {
+		result, err = nil, nil
+	}
+
+	if err != nil {
+		if raise {
+			L.RaiseError("%s", err)
+		}
+		result = errorReply{err}
+	}
+
+	L.Push(toLua(L, result))
+	return 1
+}
+
+//toLua - converts reply of command to Lua value.
+func toLua(L *lua.LState, r reply) lua.LValue {
+	switch r := r.(type) {
+	case nil:
+		return lua.LFalse
+	case okReply:
+		return statusTable(L, "ok", "OK")
+	case statusReply:
+		return statusTable(L, "ok", string(r))
+	case errorReply:
+		return statusTable(L, "err", r.err.Error())
+	case boolReply:
+		if r {
+			return lua.LNumber(1)
+		}
+		return lua.LNumber(0)
+	case intReply:
+		return lua.LNumber(r)
+	case bulkReply:
+		return lua.LString(r)
+	case arrayReply:
+		table := L.CreateTable(len(r), 0)
+		for _, el := range r {
+			table.Append(toLua(L, el))
+		}
+		return table
+	}
+
+	return lua.LFalse
+}
+
+//fromLua - converts result of script to reply.
+//path - tables, that contain value, to detect tables, that contain themselves.
+func fromLua(value lua.LValue, path map[*lua.LTable]bool) (reply, error) {
+	switch value := value.(type) {
+	case lua.LNumber:
+		return intReply(int64(value)), nil
+	case lua.LString:
+		return bulkReply(value), nil
+	case lua.LBool:
+		if value {
+			return intReply(1), nil
+		}
+		return nil, nil
+	case *lua.LTable:
+		if msg, ok := value.RawGetString("err").(lua.LString); ok {
+			return nil, fmt.Errorf("%s", string(msg))
+		}
+		if status, ok := value.RawGetString("ok").(lua.LString); ok {
+			return statusReply(status), nil
+		}
+
+		if path[value] || len(path) >= scriptResultMaxDepth {
+			return nil, errScriptResultDepth
+		}
+		path[value] = true
+		defer delete(path, value)
+
+		result := arrayReply{}
+		for i := 1; ; i++ {
+			el := value.RawGetInt(i)
+			if el == lua.LNil {
+				break
+			}
+
+			r, err := fromLua(el, path)
+			if err == errScriptResultDepth {
+				return nil, err
+			}
+			if err != nil {
+				r = errorReply{err}
+			}
+			result = append(result, r)
+		}
+		return result, nil
+	}
+
+	return nil, nil
+}
+
+func stringsTable(L *lua.LState, items []string) *lua.LTable {
+	table := L.CreateTable(len(items), 0)
+	for _, item := range items {
+		table.Append(lua.LString(item))
+	}
+
+	return table
+}
+
+func statusTable(L *lua.LState, field, value string) *lua.LTable {
+	table := L.CreateTable(0, 1)
+	table.RawSetString(field, lua.LString(value))
+
+	return table
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvalResult(t *testing.T) {
+	rc := newTestCache(t)
+
+	tests := []struct {
+		script string
+		want   reply
+	}{
+		{"return 1", intReply(1)},
+		{"return 'a'", bulkReply("a")},
+		{"return true", intReply(1)},
+		{"return false", nil},
+		{"return {ok = 'done'}", statusReply("done")},
+		{"return {1, 'a', {2}}", arrayReply{intReply(1), bulkReply("a"), arrayReply{intReply(2)}}},
+		{"local a = {1} return {a, a}", arrayReply{arrayReply{intReply(1)}, arrayReply{intReply(1)}}},
+	}
+
+	for _, test := range tests {
+		got, err := commands["eval"](rc, &command{"eval", []string{test.script, "0"}})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.script, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.script, got, test.want)
+		}
+	}
+}
+
+func TestEvalRecursiveResult(t *testing.T) {
+	rc := newTestCache(t)
+
+	scripts := []string{
+		"local t = {} t[1] = t return t",
+		"local t = {} t[1] = t t[2] = t t[3] = t return t",
+		"local a, b = {}, {} a[1] = b b[1] = a return a",
+		"local t = {} for i = 1, 2000 do t = {t} end return t",
+	}
+
+	for _, script := range scripts {
+		_, err := commands["eval"](rc, &command{"eval", []string{script, "0"}})
+		if err != errScriptResultDepth {
+			t.Errorf("%q: got error %v, want %v", script, err, errScriptResultDepth)
+		}
+	}
+}
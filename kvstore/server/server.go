@@ -2,16 +2,21 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 //getRequestLength - based on the netstring protocol, returns the request length from the request.
 //Returns error - if it was occured
 func getRequestLength(reader *bufio.Reader) (int, error) {
-	requestLength, err := reader.ReadString(':')
+	requestLength, err := readUntil(reader, ':', maxRequestLengthDigits)
 	if err != nil {
 		return -1, fmt.Errorf("ERR: Request length reading error. Request length: %s. IO err: %s;", requestLength, err)
 	}
@@ -27,23 +32,25 @@ func getRequestLength(reader *bufio.Reader) (int, error) {
 	return n, nil
 }
 
-//readRequest -  reads and returns client's reaquest.
+//readRequest -  reads and returns client's reaquest, which is not larger than maxSize bytes.
 //Returns error - if it was occured
-func readRequest(conn net.Conn) (string, error) {
-
-	connReader := bufio.NewReader(conn)
-
-	requestLength, err := getRequestLength(connReader)
+func readRequest(client *client, maxSize int) (string, error) {
+	requestLength, err := getRequestLength(client.reader)
 	if err != nil {
-		return "", fmt.Errorf(err.Error()+"Client addres: %s;", conn.RemoteAddr())
+		return "", fmt.Errorf(err.Error()+"Client addres: %s;", client.conn.RemoteAddr())
+	}
+
+	if requestLength < 0 || requestLength > maxSize {
+		return "", errRequestTooLarge(requestLength, maxSize)
 	}
 
 	request := make([]byte, requestLength)
 
-	_, err = connReader.Read(request)
+	//request may come in several packets, and the next pipelined requests stay in reader
+	_, err = io.ReadFull(client.reader, request)
 
 	if err != nil {
-		return "", fmt.Errorf("ERR: Request reading error. Request: %s. Client addres: %s. IO err: %s;", string(request), conn.RemoteAddr(), err)
+		return "", fmt.Errorf("ERR: Request reading error. Request: %s. Client addres: %s. IO err: %s;", string(request), client.conn.RemoteAddr(), err)
 	}
 
 	return string(request), nil
@@ -124,64 +131,393 @@ func parseRequest(request string) (*command, error) {
 //getResponse - generates a response based on user's request.
 //Return:
 //response, nil - if successful;
-//"", error - if not.
-func getResponse(conn net.Conn, cmd *command, rc *KVCache) (string, error) {
-	executor, ok := commands[cmd.name]
-	if !ok {
-		return "", fmt.Errorf("ERR:Unknown command: %s. Client addres: %s;", cmd.name, conn.RemoteAddr())
+//nil, error - if not.
+func getResponse(client *client, cmd *command, rc *KVCache) (reply, error) {
+	cmd.name = strings.ToLower(cmd.name)
+	if name, ok := aliases[cmd.name]; ok {
+		cmd.name = name
+	}
+
+	if _, ok := commands[cmd.name]; !ok {
+		return nil, fmt.Errorf("ERR:Unknown command: %s. Client addres: %s;", cmd.name, client.conn.RemoteAddr())
+	}
+
+	if exclusiveCommands[cmd.name] {
+		rc.txMut.Lock()
+		defer rc.txMut.Unlock()
+	} else {
+		rc.txMut.RLock()
+		defer rc.txMut.RUnlock()
 	}
 
+	return execute(rc, cmd, commandExecutor(cmd.name, client.user))
+}
+
+//userCommands - commands, that act with permissions of client's user(scripts run commands as the user).
+//They return executor for the user, commandExecutor makes it on every call.
+var userCommands = map[string]func(user string) func(*KVCache, *command) (reply, error){}
+
+//commandExecutor - returns executor of command called by user.
+func commandExecutor(name, user string) func(*KVCache, *command) (reply, error) {
+	if executorFor, ok := userCommands[name]; ok {
+		return executorFor(user)
+	}
+	return commands[name]
+}
+
+//exclusiveCommands - commands, that execute other commands and must not be interleaved with commands of other clients
+//(like scripts), or need the whole database unchanged. They are executed with KVCache.txMut held exclusively.
+var exclusiveCommands = map[string]bool{
+	"restore":    true,
+	"rewriteaof": true,
+}
+
+//execute - executes command with shards of its keys locked. Expired keys of command are removed before(see exparation.go),
+//replica hides them from read command instead.
+//Commands, that modify the database, lock them exclusively, update versions of keys and are propagated
+//to append-only log and replicas. Replica rejects them. If memory limit is reached, keys are evicted before(see memory.go).
+func execute(rc *KVCache, cmd *command, executor func(*KVCache, *command) (reply, error)) (reply, error) {
+	if !writeCommands[cmd.name] {
+		keys := commandKeys(cmd)
+		unlock := rc.lockKeys(keys, false)
+		restore := func() {}
+
+		if rc.hasExpired(keys) {
+			unlock()
+			unlock = rc.lockKeys(keys, true)
+			if rc.repl.isReplica() {
+				restore = rc.hideExpired(keys)
+			} else {
+				rc.expireKeys(keys)
+			}
+		}
+		defer unlock()
+		defer restore()
+
+		rc.countLookups(keys)
+		result, err := executor(rc, cmd)
+		rc.touch(keys)
+		return result, err
+	}
+
+	if rc.repl.isReplica() {
+		return nil, errReadOnly
+	}
+
+	err := rc.freeMemory(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeWrite(rc, cmd, executor)
+}
+
+//executeWrite - executes command, that may modify the database. Replica applies commands from primary with it.
+func executeWrite(rc *KVCache, cmd *command, executor func(*KVCache, *command) (reply, error)) (reply, error) {
+	keys := commandKeys(cmd)
+	shards := rc.shardsOf(keys)
+	unlock := rc.lockShards(shards, true)
+	defer unlock()
+
+	if !rc.repl.isReplica() {
+		rc.expireKeys(keys)
+	}
+
+	dirty := rc.dirtyOf(shards)
 	result, err := executor(rc, cmd)
+	rc.account(keys)
+	rc.touch(keys)
+
+	//command is logged if it changed anything, even when it reports error (like getset without previous value).
+	//Commands without keys(restore) are exclusive and logged if they succeed.
+	if rc.dirtyOf(shards) != dirty || (len(shards) == 0 && err == nil) {
+		rc.touchKeys(cmd)
+		propagate(rc, cmd, result)
+	}
+
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	return result, nil
 }
 
 //handleConnection - when client connected handle the connection.
-func handleConnection(rc *KVCache, conn net.Conn) {
+//protocol - protocol of the listener: protocolNetstring, protocolRESP or protocolAuto
+//(then it is detected by the first byte from client).
+func handleConnection(rc *KVCache, conn net.Conn, protocol string) {
 	defer conn.Close()
-	defer log.Printf("LOG: the end of socket for client: %s;", conn.RemoteAddr())
+	defer debugf("LOG: the end of socket for client: %s;", conn.RemoteAddr())
+
+	if !rc.shutdown.addClient(conn) {
+		return
+	}
+	defer rc.shutdown.removeClient(conn)
+	atomic.AddInt64(&rc.stats.connections, 1)
+
+	client := &client{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn), protocol: protocol,
+		channels: make(map[string]bool), patterns: make(map[string]bool), watched: make(map[string]int64), user: rc.acl.newClientUser()}
+	defer client.closePush(rc)
+	defer client.flush()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		user, err := tlsHandshake(rc, tlsConn)
+		if err != nil {
+			log.Printf("ERR: TLS handshake failed. Client addres: %s. ERR: %s;", conn.RemoteAddr(), err)
+			return
+		}
+		if user != "" {
+			client.user = user
+			log.Printf("LOG: Client %s authenticated by certificate as %s;", conn.RemoteAddr(), user)
+		}
+	}
+
+	if protocol == protocolAuto {
+		first, err := client.reader.Peek(1)
+		if err != nil {
+			log.Printf("ERR: Can't detect protocol. Client addres: %s. IO err: %s;", conn.RemoteAddr(), err)
+			return
+		}
+
+		client.protocol = protocolRESP
+		if first[0] >= '0' && first[0] <= '9' {
+			client.protocol = protocolNetstring
+		}
+	}
 
 	for {
-		request, err := readRequest(conn)
+		//on shutdown client is disconnected after reply to its current command
+		if rc.shutdown.closed() {
+			break
+		}
+
+		//replies to pipelined requests are batched in writer and sent, when there are no more requests to read
+		if client.reader.Buffered() == 0 {
+			err := client.flush()
+			if err != nil {
+				log.Printf("ERR: Response send error: %s. Client addres: %s;", err, conn.RemoteAddr())
+				break
+			}
+		}
+
+		cmd, err := client.readCommand(int(atomic.LoadInt64(&rc.maxRequestSize)))
+
+		if _, ok := err.(*requestError); ok {
+			log.Printf("ERR: %v; Client addres: %s;\n", err, conn.RemoteAddr())
+			err = client.writeError(err)
+			if err != nil {
+				log.Printf("ERR: <Parse err> send error: %s; Client addres: %s;", err, conn.RemoteAddr())
+			}
+			continue
+		}
+
+		if err != nil && rc.shutdown.closed() {
+			//reading is interrupted by shutdown
+			break
+		}
 
 		if err != nil {
-			log.Printf("%s Request: %s; ", err, request)
+			log.Printf("%s Client addres: %s;", err, conn.RemoteAddr())
+
+			//client is told about protocol error(like too large request), before connection is closed
+			if _, ok := err.(net.Error); !ok && err != io.EOF {
+				client.writeError(err)
+			}
 			break
 		}
-		log.Printf("LOG: client: %s, request: %s;", conn.RemoteAddr(), request)
+		logged := loggedCommand(cmd)
+		debugf("LOG: client: %s, request: %s", conn.RemoteAddr(), logged)
 
-		cmd, err := parseRequest(request)
+		err = rc.acl.check(client.user, cmd)
 		if err != nil {
-			log.Printf("ERR: %v; Client addres: %s;\n", err, conn.RemoteAddr())
-			_, err = conn.Write([]byte(makeNetstring(err.Error())))
+			log.Printf("%s Client addres: %s;", err, conn.RemoteAddr())
+			err = client.writeError(err)
+			if err != nil {
+				log.Printf("ERR: %s Response error <<send error>>: %s;", logged, err)
+			}
+			continue
+		}
+
+		name := strings.ToLower(cmd.name)
+		if client.subscriptions() > 0 && !pushModeCommands[name] {
+			err = client.writeError(pushModeError(cmd))
+			if err != nil {
+				log.Printf("ERR: %s Response error <<send error>>: %s;", logged, err)
+			}
+			continue
+		}
+
+		start := time.Now()
+		executor, ok := pubsubCommands[name]
+		if !ok {
+			executor, ok = replicationCommands[name]
+		}
+		if ok && client.tx == nil {
+			//these commands switch client to push mode, so replies to previous requests are sent first
+			client.flush()
+			err = executor(rc, client, cmd)
+			rc.stats.recordCommand(name, time.Since(start), err)
 			if err != nil {
-				log.Printf("ERR: Request: %s; <Parse err> send error: %s;", request, err)
+				log.Println(err)
+
+				//error is sent through the same output buffer, so it fails too, if the connection is broken
+				err = client.writeError(err)
+				if err != nil {
+					log.Printf("ERR: %s Response error <<send error>>: %s;", logged, err)
+					break
+				}
 			}
 			continue
 		}
 
-		response, err := getResponse(conn, cmd, rc)
+		var response reply
+		queued := false
+		if executor, ok := transactionCommands[name]; ok {
+			response, err = executor(rc, client, cmd)
+		} else if executor, ok := authCommands[name]; ok {
+			response, err = executor(rc, client, cmd)
+		} else if client.tx != nil {
+			response, err = client.tx.enqueue(cmd)
+			queued = true
+		} else if _, ok := blockingCommands[name]; ok {
+			//client gets replies to previous requests, while it waits
+			client.flush()
+			response, err = blockingPop(rc, client, cmd)
+		} else {
+			response, err = getResponse(client, cmd, rc)
+		}
+		//queued commands are counted as exec
+		if !queued {
+			rc.stats.recordCommand(name, time.Since(start), err)
+		}
 		if err != nil {
 			log.Println(err)
 
-			_, err = conn.Write([]byte(makeNetstring(err.Error())))
+			err = client.writeError(err)
 			if err != nil {
-				log.Printf("ERR: Request: %s; Response error <<send error>>: %s;", request, err)
+				log.Printf("ERR: %s Response error <<send error>>: %s;", logged, err)
 			}
 			continue
 		}
 
-		_, err = conn.Write([]byte(makeNetstring(response)))
+		err = client.writeReply(response)
+		if err != nil {
+			log.Printf("ERR: %s Rsponse send error: %s;", logged, err)
+		}
+
+		debugf("LOG: %s Response: %s; Client addres: %s;", logged, formatReply(response), conn.RemoteAddr())
+	}
+
+}
+
+//client - connection with single client.
+type client struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer    //replies are buffered here until flush, unless client is in push mode
+	protocol string           //protocolNetstring or protocolRESP
+	push     *pushQueue       //output buffer, nil - until client subscribes to something
+	channels map[string]bool  //channels client is subscribed to
+	patterns map[string]bool  //patterns client is subscribed to
+	tx       *transaction     //commands queued after multi, nil - if client is not in transaction
+	watched  map[string]int64 //watched keys with their versions
+	user     string           //user, client acts as, empty - if client is not authenticated, see acl.go
+}
+
+//readCommand - reads next request from client, which is not larger than maxSize bytes, and parses it.
+//Returns *requestError - if request can't be parsed, but connection still may be used.
+func (client *client) readCommand(maxSize int) (*command, error) {
+	if client.protocol == protocolRESP {
+		return readRESPCommand(client.reader, maxSize)
+	}
+
+	request, err := readRequest(client, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := parseRequest(request)
+	if err != nil {
+		return nil, &requestError{err}
+	}
+
+	return cmd, nil
+}
+
+//watchDisconnect - watches connection, while client waits for blocking command and doesn't send anything.
+//Returned channel is closed if client disconnects. stop must be called before the next read from client.
+func (client *client) watchDisconnect() (disconnected <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, err := client.reader.Peek(1)
 		if err != nil {
-			log.Printf("ERR: Request: %s; Rsponse send error: %s;", request, err)
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				close(closed)
+			}
 		}
+	}()
+
+	stop = func() {
+		//deadline in the past interrupts Peek, which is waiting for data
+		client.conn.SetReadDeadline(time.Now())
+		<-done
+		client.conn.SetReadDeadline(time.Time{})
+	}
+
+	return closed, stop
+}
+
+//writeReply - sends reply to client in its protocol.
+func (client *client) writeReply(r reply) error {
+	return client.write(client.encodeReply(r))
+}
+
+//writeError - sends error to client in its protocol.
+func (client *client) writeError(err error) error {
+	if client.protocol == protocolRESP {
+		return client.write(encodeRESPError(err))
+	}
+
+	return client.write([]byte(makeNetstring(err.Error())))
+}
+
+//encodeReply - encodes reply in client's protocol.
+func (client *client) encodeReply(r reply) []byte {
+	if client.protocol == protocolRESP {
+		return encodeRESP(r)
+	}
+
+	return []byte(makeNetstring(formatReply(r)))
+}
+
+//write - sends data to client. In push mode data is queued to client's output buffer,
+//so it is not mixed with published messages, otherwise it is buffered until flush.
+func (client *client) write(data []byte) error {
+	if client.push != nil {
+		return client.push.send(data)
+	}
+
+	_, err := client.writer.Write(data)
+	return err
+}
+
+//flush - sends buffered replies to client.
+func (client *client) flush() error {
+	return client.writer.Flush()
+}
 
-		log.Printf("LOG: Request: %s; Response: %s; Client addres: %s;", request, response, conn.RemoteAddr())
+//closePush - unsubscribes client from everything, forgets it as replica and stops writing of its output buffer.
+func (client *client) closePush(rc *KVCache) {
+	if client.push == nil {
+		return
 	}
 
+	rc.pubsub.unsubscribeAll(client)
+	rc.repl.removeReplica(client)
+	client.push.stop()
 }
 
 func makeNetstring(str string) string {
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//newTestCache - returns database with default parameters, without listeners, append-only log and autosave.
+func newTestCache(t *testing.T) *KVCache {
+	t.Helper()
+
+	config := &config{}
+	err := newFlagSet("kvstore", config).Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newKVCache(config)
+}
+
+//run - executes command the way client's command is executed.
+func run(t *testing.T, rc *KVCache, args ...string) (reply, error) {
+	t.Helper()
+
+	name := args[0]
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if _, ok := commands[name]; !ok {
+		t.Fatalf("unknown command: %s", name)
+	}
+	return getResponse(&client{user: defaultUserName}, &command{args[0], args[1:]}, rc)
+}
+
+//dump - returns commands, that recreate the database, as sorted log records, so databases can be compared.
+//Arguments, which order depends on order of map iteration, are sorted.
+func dump(t *testing.T, rc *KVCache) []string {
+	t.Helper()
+
+	records := []string{}
+	for _, cmd := range datasetCommands(rc) {
+		args := append([]string{}, cmd.args[1:]...)
+		switch cmd.name {
+		case "hset":
+			pairs := make([]string, 0, len(args)/2)
+			for i := 0; i+1 < len(args); i += 2 {
+				pairs = append(pairs, args[i]+"="+args[i+1])
+			}
+			args = pairs
+			sort.Strings(args)
+		case "sadd":
+			sort.Strings(args)
+		}
+		records = append(records, string(encodeCommand(&command{cmd.name, append([]string{cmd.args[0]}, args...)})))
+	}
+	sort.Strings(records)
+
+	return records
+}
+
+//fill - stores keys of all types with and without expiration date in the database.
+func fill(t *testing.T, rc *KVCache) {
+	t.Helper()
+
+	for _, args := range [][]string{
+		{"set", "string", "value with spaces\r\n"},
+		{"set", "empty", ""},
+		{"set", "volatile", "v"},
+		{"ex", "volatile", "1000"},
+		{"hset", "hash", "f1", "v1", "f2", "v2"},
+		{"rpush", "list", "a", "b", "c"},
+		{"sadd", "set", "x", "y", "z"},
+		{"zadd", "zset", "1", "a", "2.5", "b", "-3", "c"},
+		{"set", "deleted", "v"},
+		{"del", "deleted"},
+	} {
+		_, err := run(t, rc, args...)
+		if err != nil {
+			t.Fatalf("%v: %s", args, err)
+		}
+	}
+}
+
+//startServer - starts listener on random local port, which handles clients with protocol, and returns its address.
+//TLS listener - if tlsConfig isn't nil.
+func startServer(t *testing.T, rc *KVCache, tlsConfig *tls.Config, protocol string) string {
+	t.Helper()
+
+	l, err := listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serve(rc, l, protocol)
+
+	return l.Addr().String()
+}
+
+//dialTest - connects to server at addr. Connection is closed, when test ends.
+func dialTest(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return conn, bufio.NewReader(conn)
+}
+
+func TestPipelining(t *testing.T) {
+	rc := newTestCache(t)
+	conn, reader := dialTest(t, startServer(t, rc, nil, protocolAuto))
+
+	//all requests are sent at once, RESP and inline requests are mixed
+	const n = 500
+	var requests strings.Builder
+	for i := 0; i < n; i++ {
+		value := strconv.Itoa(i)
+		requests.Write(encodeRESP(arrayReply{bulkReply("set"), bulkReply("k" + value), bulkReply(value)}))
+		requests.WriteString("get k" + value + "\r\n")
+	}
+	_, err := conn.Write([]byte(requests.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		value := strconv.Itoa(i)
+		want := fmt.Sprintf("+OK\r\n$%d\r\n%s\r\n", len(value), value)
+		got := make([]byte, len(want))
+		_, err := io.ReadFull(reader, got)
+		if err != nil {
+			t.Fatalf("reply %d: %s", i, err)
+		}
+		if string(got) != want {
+			t.Fatalf("replies to set and get of k%d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestPipeliningNetstring(t *testing.T) {
+	rc := newTestCache(t)
+	conn, reader := dialTest(t, startServer(t, rc, nil, protocolAuto))
+
+	const n = 500
+	var requests strings.Builder
+	for i := 0; i < n; i++ {
+		requests.WriteString(makeNetstring("set k v" + strconv.Itoa(i)))
+		requests.WriteString(makeNetstring("get k"))
+	}
+	_, err := conn.Write([]byte(requests.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2*n; i++ {
+		want := formatReply(okReply{})
+		if i%2 == 1 {
+			want = formatReply(bulkReply("v" + strconv.Itoa(i/2)))
+		}
+
+		length, err := reader.ReadString(':')
+		if err != nil {
+			t.Fatalf("reply %d: %s", i, err)
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(length, ":"))
+		if err != nil {
+			t.Fatalf("reply %d: bad length %q", i, length)
+		}
+		got := make([]byte, size)
+		_, err = io.ReadFull(reader, got)
+		if err != nil {
+			t.Fatalf("reply %d: %s", i, err)
+		}
+		if string(got) != want {
+			t.Fatalf("reply %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestMaxRequestSize(t *testing.T) {
+	rc := newTestCache(t)
+	atomic.StoreInt64(&rc.maxRequestSize, 100)
+	conn, reader := dialTest(t, startServer(t, rc, nil, protocolRESP))
+
+	//replies to requests before too large one are sent, then connection is closed
+	requests := "ping\r\n" + string(encodeRESP(arrayReply{bulkReply("set"), bulkReply("k"), bulkReply(strings.Repeat("v", 200))})) + "ping\r\n"
+	_, err := conn.Write([]byte(requests))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"+PONG", "-ERR: Protocol error: request is too large"} {
+		line, err := reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, want) {
+			t.Fatalf("got reply %q, %v, want %s", line, err, want)
+		}
+	}
+	line, err := reader.ReadString('\n')
+	if err != io.EOF {
+		t.Errorf("after too large request: got %q, %v, want closed connection", line, err)
+	}
+	if _, ok := rc.lookup("k"); ok {
+		t.Error("too large request is executed")
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+func init() {
+	for name, executor := range setCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["sadd"] = true
+	writeCommands["srem"] = true
+	writeCommands["spop"] = true
+	writeCommands["sinterstore"] = true
+	writeCommands["sunionstore"] = true
+	writeCommands["sdiffstore"] = true
+}
+
+//maxRandomMembers - max amount of members srandmember returns for negative count, when members may repeat
+const maxRandomMembers = 1 << 20
+
+/*setCommands - commands to work with sets: unordered collections of unique strings*/
+var setCommands = map[string]func(*KVCache, *command) (reply, error){
+	//sadd - add members to set. Creates set if key doesn't exist.
+	//Return amount of added members(not counting already present ones).
+	"sadd": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		counter := 0
+		for _, member := range cmd.args[1:] {
+			if _, ok := set.Set[member]; !ok {
+				set.Set[member] = struct{}{}
+				counter++
+			}
+		}
+		KVCache.addDirty(cmd.args[0], counter)
+
+		return intReply(counter), nil
+	},
+
+	//srem - remove members from set. Set without members is deleted.
+	//Return amount of removed members.
+	"srem": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil || set == nil {
+			return intReply(0), err
+		}
+
+		counter := 0
+		for _, member := range cmd.args[1:] {
+			if _, ok := set.Set[member]; ok {
+				delete(set.Set, member)
+				counter++
+			}
+		}
+
+		deleteIfEmptySet(KVCache, cmd.args[0], set)
+		KVCache.addDirty(cmd.args[0], counter)
+
+		return intReply(counter), nil
+	},
+
+	//smembers - return all members of set.
+	"smembers": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil || set == nil {
+			return arrayReply{}, err
+		}
+
+		return membersReply(set.Set), nil
+	},
+
+	//sismember - check if member is in set.
+	"sismember": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil || set == nil {
+			return boolReply(false), err
+		}
+
+		_, ok := set.Set[cmd.args[1]]
+		return boolReply(ok), nil
+	},
+
+	//scard - return amount of members in set, 0 - if there is no such key.
+	"scard": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil || set == nil {
+			return intReply(0), err
+		}
+
+		return intReply(len(set.Set)), nil
+	},
+
+	//spop - remove and return random member of set: spop <key> [count].
+	//Without count return single member(nil - if set is empty), with count - list of up to count members.
+	"spop": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) != 1 && len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: spop <key> [count]. %s;", cmd)
+		}
+
+		count := 1
+		if len(cmd.args) == 2 {
+			n, err := strconv.Atoi(cmd.args[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("ERR: Count is not a positive integer: %s;", cmd.args[1])
+			}
+			count = n
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil {
+			return nil, err
+		}
+
+		if set == nil {
+			if len(cmd.args) == 2 {
+				return arrayReply{}, nil
+			}
+			return nil, nil
+		}
+
+		members := randomMembers(set.Set, count)
+		for _, member := range members {
+			delete(set.Set, member)
+		}
+
+		deleteIfEmptySet(KVCache, cmd.args[0], set)
+		KVCache.addDirty(cmd.args[0], len(members))
+
+		if len(cmd.args) == 1 {
+			return bulkReply(members[0]), nil
+		}
+		return stringsReply(members), nil
+	},
+
+	//srandmember - return random members of set without removing them: srandmember <key> [count].
+	//Positive count - up to count different members, negative - exactly -count members, which may repeat.
+	"srandmember": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) != 1 && len(cmd.args) != 2 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: srandmember <key> [count]. %s;", cmd)
+		}
+
+		count := 1
+		if len(cmd.args) == 2 {
+			n, err := strconv.Atoi(cmd.args[1])
+			if err != nil {
+				return nil, fmt.Errorf("ERR: Count is not an integer: %s;", cmd.args[1])
+			}
+			if n < -maxRandomMembers {
+				return nil, fmt.Errorf("ERR: Count is out of range(max %d repeated members): %s;", maxRandomMembers, cmd.args[1])
+			}
+			count = n
+		}
+
+		set, err := lookupSet(KVCache, cmd.args[0], false)
+		if err != nil {
+			return nil, err
+		}
+
+		if set == nil {
+			if len(cmd.args) == 2 {
+				return arrayReply{}, nil
+			}
+			return nil, nil
+		}
+
+		if len(cmd.args) == 1 {
+			return bulkReply(randomMembers(set.Set, 1)[0]), nil
+		}
+
+		if count >= 0 {
+			return stringsReply(randomMembers(set.Set, count)), nil
+		}
+
+		all := make([]string, 0, len(set.Set))
+		for member := range set.Set {
+			all = append(all, member)
+		}
+
+		members := make([]string, -count)
+		for i := range members {
+			members[i] = all[rand.Intn(len(all))]
+		}
+		return stringsReply(members), nil
+	},
+
+	//sinter - return members present in all of sets.
+	"sinter": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, intersection, false)
+	},
+
+	//sunion - return members present in any of sets.
+	"sunion": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, union, false)
+	},
+
+	//sdiff - return members of the first set, that are not present in any of the others.
+	"sdiff": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, difference, false)
+	},
+
+	//sinterstore - like sinter, but stores result to destination: sinterstore <destination> <key> [<key> ...].
+	//Return amount of members in result.
+	"sinterstore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, intersection, true)
+	},
+
+	//sunionstore - like sunion, but stores result to destination: sunionstore <destination> <key> [<key> ...].
+	//Return amount of members in result.
+	"sunionstore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, union, true)
+	},
+
+	//sdiffstore - like sdiff, but stores result to destination: sdiffstore <destination> <key> [<key> ...].
+	//Return amount of members in result.
+	"sdiffstore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return setOperation(KVCache, cmd, difference, true)
+	},
+}
+
+//setOperation - applies operation to sets, which keys are in cmd.args. If store is true,
+//the first argument is destination key: result is saved there(destination is deleted, if result is empty).
+func setOperation(KVCache *KVCache, cmd *command, operation func([]map[string]struct{}) map[string]struct{}, store bool) (reply, error) {
+	keys := cmd.args
+	if store {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+		keys = cmd.args[1:]
+	} else {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		set, err := lookupSet(KVCache, key, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if set != nil {
+			sets[i] = set.Set
+		}
+	}
+
+	result := operation(sets)
+	if !store {
+		return membersReply(result), nil
+	}
+
+	destination := cmd.args[0]
+	KVCache.removeExpiration(destination)
+	if len(result) == 0 {
+		KVCache.remove(destination)
+	} else {
+		value := newSetValue()
+		value.Set = result
+		KVCache.store(destination, value)
+	}
+	KVCache.addDirty(destination, 1)
+
+	return intReply(len(result)), nil
+}
+
+//intersection - members present in all sets. nil set is empty set.
+func intersection(sets []map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{})
+
+	smallest := sets[0]
+	for _, set := range sets {
+		if set == nil {
+			return result
+		}
+		if len(set) < len(smallest) {
+			smallest = set
+		}
+	}
+
+	for member := range smallest {
+		inAll := true
+		for _, set := range sets {
+			if _, ok := set[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+
+		if inAll {
+			result[member] = struct{}{}
+		}
+	}
+
+	return result
+}
+
+//union - members present in any of sets.
+func union(sets []map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{})
+	for _, set := range sets {
+		for member := range set {
+			result[member] = struct{}{}
+		}
+	}
+
+	return result
+}
+
+//difference - members of the first set, that are not present in others.
+func difference(sets []map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{})
+	for member := range sets[0] {
+		result[member] = struct{}{}
+	}
+
+	for _, set := range sets[1:] {
+		for member := range set {
+			delete(result, member)
+		}
+	}
+
+	return result
+}
+
+//randomMembers - returns up to count different random members of set.
+func randomMembers(set map[string]struct{}, count int) []string {
+	if count > len(set) {
+		count = len(set)
+	}
+
+	//indexes of chosen members in the order of map iteration
+	chosen := make(map[int]bool, count)
+	for _, i := range rand.Perm(len(set))[:count] {
+		chosen[i] = true
+	}
+
+	members := make([]string, 0, count)
+	i := 0
+	for member := range set {
+		if chosen[i] {
+			members = append(members, member)
+		}
+		i++
+	}
+
+	return members
+}
+
+//deleteIfEmptySet - deletes set from database, if it has no members.
+//Must be called with shard of key locked.
+func deleteIfEmptySet(KVCache *KVCache, key string, set *Value) {
+	if len(set.Set) == 0 {
+		KVCache.remove(key)
+	}
+}
+
+//lookupSet - returns set stored under key. If there is no such key, returns nil,
+//or creates new set, when create is true. Returns errWrongType - if key holds value of other type.
+//Must be called with shard of key locked.
+func lookupSet(KVCache *KVCache, key string, create bool) (*Value, error) {
+	value, ok := KVCache.lookup(key)
+	if ok && value.Type != typeSet {
+		return nil, errWrongType
+	}
+
+	if !ok && create {
+		value = newSetValue()
+		KVCache.store(key, value)
+	}
+
+	return value, nil
+}
+
+func membersReply(set map[string]struct{}) arrayReply {
+	result := make(arrayReply, 0, len(set))
+	for member := range set {
+		result = append(result, bulkReply(member))
+	}
+
+	return result
+}
+
+func stringsReply(items []string) arrayReply {
+	result := make(arrayReply, len(items))
+	for i, item := range items {
+		result[i] = bulkReply(item)
+	}
+
+	return result
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSRandMember(t *testing.T) {
+	rc := newTestCache(t)
+	_, err := run(t, rc, "sadd", "s", "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		count    string
+		length   int  //length of result
+		distinct bool //members may not repeat
+		err      string
+	}{
+		{"0", 0, true, ""},
+		{"2", 2, true, ""},
+		{"10", 3, true, ""},
+		{"-5", 5, false, ""},
+		{strconv.Itoa(-maxRandomMembers), maxRandomMembers, false, ""},
+		{strconv.Itoa(-maxRandomMembers - 1), 0, false, "ERR: Count is out of range"},
+		{"-9223372036854775808", 0, false, "ERR: Count is out of range"},
+		{"x", 0, false, "ERR: Count is not an integer"},
+	}
+
+	for _, test := range tests {
+		result, err := run(t, rc, "srandmember", "s", test.count)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("srandmember s %s: got error %v, want %s", test.count, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("srandmember s %s: unexpected error: %s", test.count, err)
+			continue
+		}
+
+		members := replyStrings(result)
+		if len(members) != test.length {
+			t.Errorf("srandmember s %s: got %d members, want %d", test.count, len(members), test.length)
+		}
+		seen := make(map[string]bool)
+		for _, member := range members {
+			if member != "a" && member != "b" && member != "c" {
+				t.Errorf("srandmember s %s: unknown member %q", test.count, member)
+				break
+			}
+			if test.distinct && seen[member] {
+				t.Errorf("srandmember s %s: member %q is repeated", test.count, member)
+			}
+			seen[member] = true
+		}
+	}
+
+	result, err := run(t, rc, "srandmember", "s")
+	if member, ok := result.(bulkReply); err != nil || !ok || !strings.Contains("abc", string(member)) {
+		t.Errorf("srandmember s: got %#v, %v", result, err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//The database is split into shards by hash of key, each shard has its own lock, so commands with different keys
+//don't wait for each other. Commands don't lock the database themselves: execute locks shards of command's keys
+//(see keyspec.go) before executing it - exclusively for write commands, shared for others.
+//Shards are always locked in ascending order of their indexes, so commands with several keys can't deadlock.
+//Commands without keys(save, showall, ...) lock shards themselves, if they need the data.
+//Operations, that need the whole database unchanged(restore, start of append-only log rewrite, full resync of replica),
+//are executed with KVCache.txMut held exclusively, so no other command is in progress.
+
+const (
+	defaultShardCount = 64
+
+	scanBucketBits = 8                   //keys of shard are grouped by the highest bits of their hash for scan
+	scanBuckets    = 1 << scanBucketBits //amount of scan buckets in every shard
+)
+
+//shard - part of the database with its own lock.
+type shard struct {
+	Mut       *sync.RWMutex
+	data      map[string]*Value
+	expKeys   *onExpiration         //keys of the shard with set expiration date
+	dirty     int64                 //amount of changes made to the shard
+	memory    int64                 //estimated memory used by keys of the shard, see memory.go
+	scan      []map[string]struct{} //keys of the shard by scan buckets, nil map - bucket is empty, see keyspace.go
+	deletions int64                 //amount of deletions of keys from the shard, gives version to missing keys, see transaction.go
+}
+
+func newShard() *shard {
+	return &shard{&sync.RWMutex{}, make(map[string]*Value), newOnExpiration(), 0, 0, make([]map[string]struct{}, scanBuckets), 0}
+}
+
+//index - adds new key to its scan bucket.
+func (shard *shard) index(key string) {
+	bucket := scanBucket(key)
+	if shard.scan[bucket] == nil {
+		shard.scan[bucket] = make(map[string]struct{})
+	}
+	shard.scan[bucket][key] = struct{}{}
+}
+
+//unindex - removes key from its scan bucket.
+func (shard *shard) unindex(key string) {
+	bucket := scanBucket(key)
+	delete(shard.scan[bucket], key)
+	if len(shard.scan[bucket]) == 0 {
+		shard.scan[bucket] = nil
+	}
+}
+
+//newShards - creates n empty shards.
+func newShards(n int) []*shard {
+	if n < 1 {
+		n = 1
+	}
+
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return shards
+}
+
+//keyHash - returns FNV-1a hash of key.
+func keyHash(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return hash
+}
+
+//shardIndex - returns index of shard, key belongs to.
+func (KVCache *KVCache) shardIndex(key string) int {
+	return int(keyHash(key) % uint32(len(KVCache.shards)))
+}
+
+//scanBucket - returns index of scan bucket of key in its shard. It depends on key only, so key stays in the same bucket,
+//however the shard grows.
+func scanBucket(key string) int {
+	return int(keyHash(key) >> (32 - scanBucketBits))
+}
+
+func (KVCache *KVCache) shardOf(key string) *shard {
+	return KVCache.shards[KVCache.shardIndex(key)]
+}
+
+//lookup - returns value stored under key. Must be called with shard of key locked.
+func (KVCache *KVCache) lookup(key string) (*Value, bool) {
+	value, ok := KVCache.shardOf(key).data[key]
+	return value, ok
+}
+
+//store - stores value under key. Expiration date of key is kept. Must be called with shard of key locked exclusively.
+//Memory of new value is counted by account after command is executed.
+func (KVCache *KVCache) store(key string, value *Value) {
+	shard := KVCache.shardOf(key)
+	previous, ok := shard.data[key]
+	if !ok {
+		shard.index(key)
+	} else if previous != value {
+		KVCache.addMemory(key, -previous.Size)
+		previous.Size = 0
+	}
+
+	if value.Access == 0 {
+		value.Access = unixMilli(time.Now())
+		value.Freq = lfuInitVal
+	}
+	shard.data[key] = value
+}
+
+//remove - deletes key and its expiration date. Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) remove(key string) {
+	shard := KVCache.shardOf(key)
+	if value, ok := shard.data[key]; ok {
+		KVCache.addMemory(key, -value.Size)
+		value.Size = 0
+		shard.unindex(key)
+		shard.deletions++
+	}
+	delete(shard.data, key)
+	shard.expKeys.removeExpirationFromKey(key)
+}
+
+//setExpiration - sets expiration date of key. Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) setExpiration(key string, deadline time.Time) {
+	KVCache.shardOf(key).expKeys.addExpirationForKey(key, deadline)
+	KVCache.expiry.schedule(deadline)
+}
+
+//removeExpiration - removes expiration date of key. Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) removeExpiration(key string) {
+	KVCache.shardOf(key).expKeys.removeExpirationFromKey(key)
+}
+
+//expirationOf - returns expiration date of key, false - if it is not set. Must be called with shard of key locked.
+func (KVCache *KVCache) expirationOf(key string) (time.Time, bool) {
+	return KVCache.shardOf(key).expKeys.expirationOf(key)
+}
+
+//addDirty - counts n changes made to key. Must be called with shard of key locked exclusively.
+func (KVCache *KVCache) addDirty(key string, n int) {
+	KVCache.shardOf(key).dirty += int64(n)
+	atomic.AddInt64(&KVCache.dirty, int64(n))
+}
+
+//shardsOf - returns indexes of shards of keys in ascending order without repeats.
+func (KVCache *KVCache) shardsOf(keys []string) []int {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, KVCache.shardIndex(key))
+	}
+	sort.Ints(indexes)
+
+	unique := indexes[:0]
+	for i, index := range indexes {
+		if i == 0 || index != indexes[i-1] {
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
+
+//lockShards - locks shards by indexes(sorted) exclusively, if write is true, or shared.
+//Returns function, that unlocks them.
+func (KVCache *KVCache) lockShards(indexes []int, write bool) func() {
+	for _, index := range indexes {
+		if write {
+			KVCache.shards[index].Mut.Lock()
+		} else {
+			KVCache.shards[index].Mut.RLock()
+		}
+	}
+
+	return func() {
+		for i := len(indexes) - 1; i >= 0; i-- {
+			if write {
+				KVCache.shards[indexes[i]].Mut.Unlock()
+			} else {
+				KVCache.shards[indexes[i]].Mut.RUnlock()
+			}
+		}
+	}
+}
+
+//lockKeys - locks shards of keys. Returns function, that unlocks them.
+func (KVCache *KVCache) lockKeys(keys []string, write bool) func() {
+	return KVCache.lockShards(KVCache.shardsOf(keys), write)
+}
+
+//lockAll - locks all shards. Returns function, that unlocks them.
+func (KVCache *KVCache) lockAll(write bool) func() {
+	indexes := make([]int, len(KVCache.shards))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	return KVCache.lockShards(indexes, write)
+}
+
+//dirtyOf - returns amount of changes made to shards by indexes. Must be called with the shards locked.
+func (KVCache *KVCache) dirtyOf(indexes []int) int64 {
+	var dirty int64
+	for _, index := range indexes {
+		dirty += KVCache.shards[index].dirty
+	}
+	return dirty
+}
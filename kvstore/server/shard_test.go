@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//TestConcurrentCrossShardCommands - commands with keys in different shards are run concurrently,
+//run it with -race. Shards must stay consistent and the commands must not deadlock.
+func TestConcurrentCrossShardCommands(t *testing.T) {
+	rc := newTestCache(t)
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			random := rand.New(rand.NewSource(seed))
+			key := func() string { return keys[random.Intn(len(keys))] }
+			client := &client{user: defaultUserName}
+			for i := 0; i < 2000; i++ {
+				var args []string
+				switch random.Intn(5) {
+				case 0:
+					args = []string{"set", key(), "v"}
+				case 1:
+					args = []string{"rename", key(), key()}
+				case 2:
+					args = []string{"renamenx", key(), key()}
+				case 3:
+					args = []string{"del", key(), key(), key()}
+				case 4:
+					args = []string{"exists", key(), key()}
+				}
+				//rename of missing key reports error, it is expected
+				getResponse(client, &command{args[0], args[1:]}, rc)
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	size, err := run(t, rc, "dbsize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := run(t, rc, "keys", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.(arrayReply)) != int(size.(intReply)) {
+		t.Errorf("keys * returned %d keys, dbsize: %d", len(listed.(arrayReply)), size)
+	}
+
+	var memory int64
+	for _, shard := range rc.shards {
+		for key, value := range shard.data {
+			if rc.shardOf(key) != shard {
+				t.Errorf("key %s is stored in wrong shard", key)
+			}
+			memory += value.Size
+		}
+	}
+	if used := atomic.LoadInt64(&rc.memory.used); used != memory {
+		t.Errorf("used memory: %d, sum of sizes of keys: %d", used, memory)
+	}
+}
+
+//newBenchmarkCache - returns database with default parameters, but with given amount of shards.
+func newBenchmarkCache(b *testing.B, shards int) *KVCache {
+	b.Helper()
+
+	config := &config{}
+	err := newFlagSet("kvstore", config).Parse([]string{"-shards", strconv.Itoa(shards)})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return newKVCache(config)
+}
+
+//BenchmarkExecuteParallel - many clients run set and get on different keys, with a single lock for the whole
+//database(-shards 1) and with default amount of shards. Goroutines = parallelism * GOMAXPROCS.
+func BenchmarkExecuteParallel(b *testing.B) {
+	for _, shards := range []int{1, defaultShardCount} {
+		for _, parallelism := range []int{100, 1000} {
+			b.Run(fmt.Sprintf("shards=%d/parallelism=%d", shards, parallelism), func(b *testing.B) {
+				rc := newBenchmarkCache(b, shards)
+				var clients int64
+
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					client := &client{user: defaultUserName}
+					key := "key" + strconv.FormatInt(atomic.AddInt64(&clients, 1), 10)
+					for i := 0; pb.Next(); i++ {
+						cmd := &command{"get", []string{key}}
+						if i%2 == 0 {
+							cmd = &command{"set", []string{key, "value"}}
+						}
+						getResponse(client, cmd, rc)
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Graceful shutdown: shutdown command, SIGINT or SIGTERM stop the server:
+// 1. listeners are closed, so new clients are not accepted;
+// 2. clients get replies to commands in flight and are disconnected(blocked commands return as on timeout),
+//    clients, which are still busy after -shutdown-timeout, are disconnected forcibly;
+// 3. replication, autosave, expiration and other background goroutines are stopped;
+// 4. append-only log is fsynced and closed, the database is saved to -dbfilename with shutdown save,
+//    or if autosave is on(unless shutdown nosave is used).
+//The server exits with status 0, or 1 - if data couldn't be persisted.
+
+const (
+	shutdownSave    = "save"
+	shutdownNoSave  = "nosave"
+	shutdownDefault = "" //save only if autosave is on
+
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+func init() {
+	commands["shutdown"] = shutdownCommand
+}
+
+//shutdownState - connected clients and background goroutines, that are stopped on shutdown.
+type shutdownState struct {
+	Mut        *sync.Mutex
+	closing    chan struct{}     //closed when shutdown starts
+	isClosing  int32             //1 - if closing is closed, changed atomically
+	stop       chan struct{}     //closed when background goroutines should return
+	request    chan string       //shutdown mode requested by shutdown command
+	clients    map[net.Conn]bool //connected clients
+	active     *sync.WaitGroup   //handleConnection goroutines
+	background *sync.WaitGroup   //background goroutines
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{Mut: &sync.Mutex{}, closing: make(chan struct{}), stop: make(chan struct{}), request: make(chan string, 1),
+		clients: make(map[net.Conn]bool), active: &sync.WaitGroup{}, background: &sync.WaitGroup{}}
+}
+
+//addClient - registers connection of new client. Returns false - if the server is shutting down and client should be
+//disconnected. removeClient must be called, when client is disconnected.
+func (state *shutdownState) addClient(conn net.Conn) bool {
+	state.Mut.Lock()
+	defer state.Mut.Unlock()
+
+	if state.closed() {
+		return false
+	}
+
+	state.clients[conn] = true
+	state.active.Add(1)
+	return true
+}
+
+func (state *shutdownState) removeClient(conn net.Conn) {
+	state.Mut.Lock()
+	delete(state.clients, conn)
+	state.Mut.Unlock()
+
+	state.active.Done()
+}
+
+//goBackground - runs f in background goroutine, which should return, when stop is closed.
+//Returns false - if the server is shutting down and f is not started.
+func (state *shutdownState) goBackground(f func()) bool {
+	state.Mut.Lock()
+	defer state.Mut.Unlock()
+
+	if state.closed() {
+		return false
+	}
+
+	state.background.Add(1)
+	go func() {
+		defer state.background.Done()
+		f()
+	}()
+	return true
+}
+
+//closed - check if shutdown has started.
+func (state *shutdownState) closed() bool {
+	return atomic.LoadInt32(&state.isClosing) == 1
+}
+
+//disconnectClients - starts shutdown: interrupts reading of requests, so every client is disconnected
+//after its current command. Returns false - if shutdown has already started.
+func (state *shutdownState) disconnectClients() bool {
+	state.Mut.Lock()
+	defer state.Mut.Unlock()
+
+	if state.closed() {
+		return false
+	}
+
+	atomic.StoreInt32(&state.isClosing, 1)
+	close(state.closing)
+	for conn := range state.clients {
+		conn.SetReadDeadline(time.Now())
+	}
+	return true
+}
+
+//waitClients - waits until all clients are disconnected, but not longer than timeout, then closes connections left.
+func (state *shutdownState) waitClients(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		state.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	state.Mut.Lock()
+	log.Printf("LOG: %d clients are still busy after %v, closing their connections;", len(state.clients), timeout)
+	for conn := range state.clients {
+		conn.Close()
+	}
+	state.Mut.Unlock()
+}
+
+//sleep - waits for d. Returns false - if the server is shutting down and background goroutine should return.
+func (state *shutdownState) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-state.stop:
+		return false
+	}
+}
+
+//shutdownCommand - stop the server gracefully: shutdown [save|nosave].
+//save - save the database to dbfilename, nosave - don't save it, by default it is saved, if autosave is on.
+//Append-only log is fsynced in any case. Client gets OK and is disconnected, when its connection is closed.
+func shutdownCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	if len(cmd.args) > 1 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: shutdown [save|nosave]. %s;", cmd)
+	}
+
+	mode := shutdownDefault
+	if len(cmd.args) == 1 {
+		mode = strings.ToLower(cmd.args[0])
+		if mode != shutdownSave && mode != shutdownNoSave {
+			return nil, fmt.Errorf("ERR: Syntax error. Should be: shutdown [save|nosave]. %s;", cmd)
+		}
+	}
+
+	select {
+	case KVCache.shutdown.request <- mode:
+	default:
+		return nil, fmt.Errorf("ERR: The server is already shutting down;")
+	}
+
+	return okReply{}, nil
+}
+
+//shutdownServer - stops the server gracefully(see the top of the file). Returns exit status.
+func (KVCache *KVCache) shutdownServer(mode string, listeners []net.Listener, timeout time.Duration) int {
+	log.Printf("LOG: Shutting down the server;")
+
+	for _, l := range listeners {
+		logErr(l.Close())
+	}
+
+	if !KVCache.shutdown.disconnectClients() {
+		return 1
+	}
+	KVCache.shutdown.waitClients(timeout)
+	log.Printf("LOG: All clients are disconnected;")
+
+	KVCache.stopReplication()
+	close(KVCache.shutdown.stop)
+	KVCache.shutdown.background.Wait()
+
+	//no command runs anymore
+	KVCache.txMut.Lock()
+
+	status := 0
+	if KVCache.aof != nil {
+		err := KVCache.aof.close()
+		if err != nil {
+			log.Println(err)
+			status = 1
+		} else {
+			log.Printf("LOG: Append-only log is synced and closed: %s;", KVCache.aof.path)
+		}
+	}
+
+	KVCache.Mut.RLock()
+	autosaveIsOn := KVCache.autosaveIndicator
+	KVCache.Mut.RUnlock()
+
+	if mode == shutdownSave || (mode == shutdownDefault && autosaveIsOn) {
+		path := KVCache.dbFilename()
+		err := writeSnapshot(KVCache, path)
+		if err != nil {
+			log.Printf("ERR: Can't save the database on shutdown. %s", err)
+			status = 1
+		} else {
+			log.Printf("LOG: Database saved to file: %s;", path)
+		}
+	}
+
+	log.Printf("LOG: The server is stopped, exit status: %d;", status)
+	return status
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  string
+		autosave bool
+		saved    bool
+	}{
+		{"save", "shutdown save", false, true},
+		{"nosave", "shutdown nosave", true, false},
+		{"default, autosave is off", "shutdown", false, false},
+		{"default, autosave is on", "shutdown", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, aofPath := newTestLog(t)
+			dbPath := filepath.Join(t.TempDir(), "dump")
+			rc.config.dbFilename = dbPath
+			rc.autosaveIndicator = tt.autosave
+
+			fill(t, rc)
+			want := dump(t, rc)
+
+			l, err := listen("tcp", "127.0.0.1:0", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go serve(rc, l, protocolRESP)
+			conn, reader := dialTest(t, l.Addr().String())
+
+			_, err = conn.Write([]byte(tt.request + "\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil || line != "+OK\r\n" {
+				t.Fatalf("reply to %s: got %q, %v", tt.request, line, err)
+			}
+
+			status := rc.shutdownServer(<-rc.shutdown.request, []net.Listener{l}, time.Second)
+			if status != 0 {
+				t.Errorf("exit status: got %d, want 0", status)
+			}
+
+			//client is disconnected, new clients are not accepted
+			_, err = reader.ReadByte()
+			if err != io.EOF {
+				t.Errorf("read from client after shutdown: got %v, want EOF", err)
+			}
+			if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+				conn.Close()
+				t.Errorf("new client is accepted after shutdown")
+			}
+
+			//append-only log is closed with all commands in it
+			rc.aof.Mut.Lock()
+			closed := rc.aof.closed
+			rc.aof.Mut.Unlock()
+			if !closed {
+				t.Errorf("append-only log is not closed")
+			}
+			replayed, _ := replay(t, aofPath)
+			if got := dump(t, replayed); !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed append-only log:\n%q\nwant:\n%q", got, want)
+			}
+
+			if !tt.saved {
+				if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+					t.Errorf("database is saved on %s: %v", tt.request, err)
+				}
+				return
+			}
+
+			restored := newTestCache(t)
+			_, err = run(t, restored, "restore", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dump(t, restored); !reflect.DeepEqual(got, want) {
+				t.Errorf("database saved on shutdown:\n%q\nwant:\n%q", got, want)
+			}
+		})
+	}
+}
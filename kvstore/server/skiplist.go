@@ -0,0 +1,252 @@
+package main
+
+import "math/rand"
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25 //probability of node to have one more level
+)
+
+//skiplist - list of members ordered by score(and by member for equal scores).
+//Each level of node keeps span: amount of nodes it jumps over, so rank of node is found in O(log n) too.
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+//scoreRange - range of scores, which bounds may be excluded.
+type scoreRange struct {
+	min, max     float64
+	minExclusive bool
+	maxExclusive bool
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{header: newSkiplistNode(skiplistMaxLevel, 0, ""), level: 1}
+}
+
+func newSkiplistNode(level int, score float64, member string) *skiplistNode {
+	return &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+//less - check if (score, member) goes before the node
+func (node *skiplistNode) less(score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+//insert - adds member with score. Member must not be present in the list.
+func (sl *skiplist) insert(score float64, member string) *skiplistNode {
+	update := make([]*skiplistNode, skiplistMaxLevel)
+	rank := make([]int, skiplistMaxLevel)
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkiplistLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = newSkiplistNode(level, score, member)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+
+	return x
+}
+
+//delete - removes member with score. Return false - if there is no such member.
+func (sl *skiplist) delete(score float64, member string) bool {
+	update := make([]*skiplistNode, skiplistMaxLevel)
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	sl.deleteNode(x, update)
+	return true
+}
+
+func (sl *skiplist) deleteNode(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+//rank - returns 1-based position of member with score, 0 - if there is no such member.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !(score < x.level[i].forward.score ||
+			(score == x.level[i].forward.score && member < x.level[i].forward.member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if x != sl.header && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+//byRank - returns node by its 1-based position, nil - if rank is out of range.
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			if x == sl.header {
+				return nil
+			}
+			return x
+		}
+	}
+
+	return nil
+}
+
+//firstInRange - returns the first node with score in range, nil - if there is no such node.
+func (sl *skiplist) firstInRange(r scoreRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+//deleteRangeByScore - removes all nodes with score in range. Deleted members are passed to onDelete.
+//Return amount of deleted nodes.
+func (sl *skiplist) deleteRangeByScore(r scoreRange, onDelete func(member string)) int {
+	update := make([]*skiplistNode, skiplistMaxLevel)
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	removed := 0
+	x = x.level[0].forward
+	for x != nil && r.belowMax(x.score) {
+		next := x.level[0].forward
+		sl.deleteNode(x, update)
+		onDelete(x.member)
+		removed++
+		x = next
+	}
+
+	return removed
+}
+
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minExclusive {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxExclusive {
+		return score < r.max
+	}
+	return score <= r.max
+}
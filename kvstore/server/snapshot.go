@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+//Snapshot file format (version 1):
+//
+//	"KVSNAP" | version(1 byte) | entry... | snapshotEOF(1 byte) | crc32 of everything before(4 bytes, little endian)
+//
+//entry:
+//
+//	type(1 byte) | expiration date as unix milliseconds(varint, 0 - not set) | key | value
+//
+//key and string value are written as uvarint length followed by bytes.
+//Hash value is uvarint amount of fields followed by strings of field and value for each of them.
+//List value is uvarint amount of elements followed by strings of elements, set value - the same for members.
+//Sorted set value is uvarint amount of members followed by string of member and 8 bytes of score(little endian bits of float64).
+
+const (
+	snapshotMagic   = "KVSNAP"
+	snapshotVersion = 1
+
+	snapshotString    = 0    //entry with string value
+	snapshotHash      = 1    //entry with hash value
+	snapshotList      = 2    //entry with list value
+	snapshotSet       = 3    //entry with set value
+	snapshotSortedSet = 4    //entry with sorted set value
+	snapshotEOF       = 0xFF //marks the end of entries
+)
+
+//snapshotEntry - single key of the database read from snapshot.
+type snapshotEntry struct {
+	key      string
+	value    *Value
+	deadline time.Time //expiration date, zero - if not set
+}
+
+//writeSnapshot - saves database to file. Data is written to temporary file, which replaces the target
+//only when it is completely written, so crash while saving doesn't damage previous snapshot.
+func writeSnapshot(KVCache *KVCache, path string) (err error) {
+	start, dirty := time.Now(), atomic.LoadInt64(&KVCache.dirty)
+	defer func() {
+		KVCache.stats.recordSave(dirty, time.Since(start), err)
+	}()
+
+	data := encodeSnapshot(KVCache)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("ERR: CAN'T CREATE FILE: %s. ERR: %s;", path, err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR: WRITING TO FILE ERR: %s;", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR: CAN'T REPLACE FILE: %s. ERR: %s;", path, err)
+	}
+
+	//rename becomes durable only after directory itself is synced
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+//encodeSnapshot - encodes whole database to snapshot format.
+func encodeSnapshot(KVCache *KVCache) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(snapshotMagic)
+	buf.WriteByte(snapshotVersion)
+
+	unlock := KVCache.lockAll(false)
+	for _, shard := range KVCache.shards {
+		for key, value := range shard.data {
+			var deadline int64
+			if expTime, ok := shard.expKeys.expirationOf(key); ok && value.ExpireIsSet {
+				deadline = unixMilli(expTime)
+			}
+
+			switch value.Type {
+			case typeHash:
+				buf.WriteByte(snapshotHash)
+				writeVarint(buf, deadline)
+				writeSnapshotString(buf, key)
+				writeUvarint(buf, uint64(len(value.Hash)))
+				for field, fieldValue := range value.Hash {
+					writeSnapshotString(buf, field)
+					writeSnapshotString(buf, fieldValue)
+				}
+
+			case typeList:
+				buf.WriteByte(snapshotList)
+				writeVarint(buf, deadline)
+				writeSnapshotString(buf, key)
+				writeUvarint(buf, uint64(value.List.len))
+				for _, element := range value.List.items() {
+					writeSnapshotString(buf, element)
+				}
+
+			case typeSet:
+				buf.WriteByte(snapshotSet)
+				writeVarint(buf, deadline)
+				writeSnapshotString(buf, key)
+				writeUvarint(buf, uint64(len(value.Set)))
+				for member := range value.Set {
+					writeSnapshotString(buf, member)
+				}
+
+			case typeSortedSet:
+				buf.WriteByte(snapshotSortedSet)
+				writeVarint(buf, deadline)
+				writeSnapshotString(buf, key)
+				writeUvarint(buf, uint64(value.ZSet.list.length))
+				for node := value.ZSet.list.header.level[0].forward; node != nil; node = node.level[0].forward {
+					writeSnapshotString(buf, node.member)
+					binary.Write(buf, binary.LittleEndian, math.Float64bits(node.score))
+				}
+
+			default:
+				buf.WriteByte(snapshotString)
+				writeVarint(buf, deadline)
+				writeSnapshotString(buf, key)
+				writeSnapshotString(buf, value.Value)
+			}
+		}
+	}
+	unlock()
+
+	buf.WriteByte(snapshotEOF)
+
+	checksum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(checksum)
+
+	return buf.Bytes()
+}
+
+//readSnapshot - reads database from file. Both snapshot format and legacy json dumps are accepted.
+func readSnapshot(path string) ([]*snapshotEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: CAN'T READ DATA FROM FILE: %s. ERR: %s;", path, err)
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: READING FROM FILE: %s. ERR: %s;", path, err)
+	}
+
+	if bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return decodeSnapshot(data)
+	}
+
+	return decodeLegacySnapshot(data)
+}
+
+//decodeSnapshot - verifies checksum and decodes entries of snapshot.
+func decodeSnapshot(data []byte) ([]*snapshotEntry, error) {
+	if len(data) < len(snapshotMagic)+1+1+4 {
+		return nil, fmt.Errorf("ERR: SNAPSHOT IS TOO SHORT: %d bytes;", len(data))
+	}
+
+	body, checksum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(checksum) {
+		return nil, fmt.Errorf("ERR: SNAPSHOT CHECKSUM MISMATCH;")
+	}
+
+	version := body[len(snapshotMagic)]
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("ERR: UNSUPPORTED SNAPSHOT VERSION: %d;", version)
+	}
+
+	reader := bytes.NewReader(body[len(snapshotMagic)+1:])
+	entries := make([]*snapshotEntry, 0)
+
+	for {
+		entryType, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("ERR: SNAPSHOT IS DAMAGED: %s;", err)
+		}
+
+		if entryType == snapshotEOF {
+			break
+		}
+
+		entry, err := decodeSnapshotEntry(reader, entryType)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: SNAPSHOT IS DAMAGED: %s;", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("ERR: SNAPSHOT IS DAMAGED: %d bytes after the end of entries;", reader.Len())
+	}
+
+	return entries, nil
+}
+
+//decodeSnapshotEntry - decodes single entry, which type byte is already read.
+func decodeSnapshotEntry(reader *bytes.Reader, entryType byte) (*snapshotEntry, error) {
+	deadline, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := readSnapshotString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &snapshotEntry{key: key}
+	if deadline != 0 {
+		entry.deadline = fromUnixMilli(deadline)
+	}
+
+	switch entryType {
+	case snapshotString:
+		value, err := readSnapshotString(reader)
+		if err != nil {
+			return nil, err
+		}
+		entry.value = newValue(value, false)
+
+	case snapshotHash:
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.value = newHashValue()
+		for i := uint64(0); i < n; i++ {
+			field, err := readSnapshotString(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			entry.value.Hash[field], err = readSnapshotString(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case snapshotList:
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.value = newListValue()
+		for i := uint64(0); i < n; i++ {
+			element, err := readSnapshotString(reader)
+			if err != nil {
+				return nil, err
+			}
+			entry.value.List.pushBack(element)
+		}
+
+	case snapshotSet:
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.value = newSetValue()
+		for i := uint64(0); i < n; i++ {
+			member, err := readSnapshotString(reader)
+			if err != nil {
+				return nil, err
+			}
+			entry.value.Set[member] = struct{}{}
+		}
+
+	case snapshotSortedSet:
+		n, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.value = newSortedSetValue()
+		for i := uint64(0); i < n; i++ {
+			member, err := readSnapshotString(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			var bits uint64
+			err = binary.Read(reader, binary.LittleEndian, &bits)
+			if err != nil {
+				return nil, err
+			}
+
+			score := math.Float64frombits(bits)
+			if math.IsNaN(score) {
+				return nil, fmt.Errorf("score of %s is not a number", member)
+			}
+			entry.value.ZSet.add(score, member)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown entry type: %d", entryType)
+	}
+
+	return entry, nil
+}
+
+//decodeLegacySnapshot - decodes json dump made by previous versions of save command.
+//Expiration dates were kept only in ExpKeys.ByTimeMap there.
+func decodeLegacySnapshot(data []byte) ([]*snapshotEntry, error) {
+	var legacy struct {
+		DataStore map[string]struct {
+			Value       string
+			ExpireIsSet bool
+		}
+		ExpKeys struct {
+			ByTimeMap map[time.Time][]string
+		}
+	}
+
+	err := json.Unmarshal(data, &legacy)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: UNMARSHAL ERR: %s;", err)
+	}
+
+	deadlines := make(map[string]time.Time)
+	for expTime, keys := range legacy.ExpKeys.ByTimeMap {
+		for _, key := range keys {
+			deadlines[key] = expTime
+		}
+	}
+
+	entries := make([]*snapshotEntry, 0, len(legacy.DataStore))
+	for key, value := range legacy.DataStore {
+		entry := &snapshotEntry{key: key, value: newValue(value.Value, false)}
+		if value.ExpireIsSet {
+			entry.deadline = deadlines[key]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+//loadSnapshot - replaces content of database with entries. Already expired entries are skipped.
+func (KVCache *KVCache) loadSnapshot(entries []*snapshotEntry) {
+	shards := newShards(len(KVCache.shards))
+	now := time.Now()
+	version := atomic.AddInt64(&KVCache.dirty, 1)
+
+	for _, entry := range entries {
+		shard := shards[KVCache.shardIndex(entry.key)]
+		if !entry.deadline.IsZero() {
+			if !entry.deadline.After(now) {
+				continue
+			}
+			entry.value.ExpireIsSet = true
+			shard.expKeys.addExpirationForKey(entry.key, entry.deadline)
+		}
+		entry.value.Version = version
+		entry.value.Size = sizeOf(entry.key, entry.value)
+		entry.value.Access = unixMilli(now)
+		entry.value.Freq = lfuInitVal
+		if previous, ok := shard.data[entry.key]; ok {
+			shard.memory -= previous.Size
+		} else {
+			shard.index(entry.key)
+		}
+		shard.data[entry.key] = entry.value
+		shard.memory += entry.value.Size
+	}
+
+	//content of shards is replaced, not shards themselves, as commands may wait for their locks
+	unlock := KVCache.lockAll(true)
+	for i, shard := range KVCache.shards {
+		shard.data = shards[i].data
+		shard.expKeys = shards[i].expKeys
+		shard.scan = shards[i].scan
+		shard.dirty++
+		shard.deletions++
+		atomic.AddInt64(&KVCache.memory.used, shards[i].memory-shard.memory)
+		shard.memory = shards[i].memory
+	}
+	unlock()
+
+	KVCache.expiry.wake()
+}
+
+func writeVarint(buf *bytes.Buffer, n int64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	buf.Write(tmp[:binary.PutVarint(tmp, n)])
+}
+
+func writeUvarint(buf *bytes.Buffer, n uint64) {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	buf.Write(tmp[:binary.PutUvarint(tmp, n)])
+}
+
+func writeSnapshotString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readSnapshotString(reader *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if n > uint64(reader.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	s := make([]byte, n)
+	_, err = io.ReadFull(reader, s)
+	return string(s), err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	rc := newTestCache(t)
+	fill(t, rc)
+	want := dump(t, rc)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump")
+	err := os.WriteFile(path, []byte("previous snapshot"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = run(t, rc, "save", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//previous file is replaced, temporary file is not left
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "dump" {
+		t.Errorf("files after save: %v, want only dump", files)
+	}
+
+	loaded := newTestCache(t)
+	_, err = run(t, loaded, "set", "replaced", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run(t, loaded, "restore", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := dump(t, loaded)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored database:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestSnapshotLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump")
+	err := os.WriteFile(path, []byte(`{"DataStore": {"k": {"Value": "v", "ExpireIsSet": false}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := newTestCache(t)
+	_, err = run(t, rc, "restore", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := run(t, rc, "get", "k")
+	if err != nil || value != bulkReply("v") {
+		t.Errorf("get k after restore of json dump: got %q, %v", value, err)
+	}
+}
+
+func TestSnapshotDamaged(t *testing.T) {
+	rc := newTestCache(t)
+	fill(t, rc)
+	data := encodeSnapshot(rc)
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0x01
+
+	badVersion := append([]byte{}, data...)
+	badVersion[len(snapshotMagic)] = snapshotVersion + 1
+	binary.LittleEndian.PutUint32(badVersion[len(badVersion)-4:], crc32.ChecksumIEEE(badVersion[:len(badVersion)-4]))
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"flipped bit", flipped, "ERR: SNAPSHOT CHECKSUM MISMATCH"},
+		{"truncated", data[:len(data)-1], "ERR: SNAPSHOT CHECKSUM MISMATCH"},
+		{"too short", []byte(snapshotMagic), "ERR: SNAPSHOT IS TOO SHORT"},
+		{"unknown version", badVersion, "ERR: UNSUPPORTED SNAPSHOT VERSION"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeSnapshot(test.data)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
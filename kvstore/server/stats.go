@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Statistics of the server are collected while it runs and reported by info command and /metrics(see metrics.go). Counters are changed atomically
+//where events happen, calls and latency of commands are counted by handleConnection for every executed command
+//(commands of transactions and scripts are counted as exec and eval). Keyspace hits and misses are lookups
+//of keys by read commands, that found or didn't find the key.
+//info [section] - return bulk string of lines <field>:<value> grouped under "# <Section>" headers, as Redis does.
+//Sections: server, clients, memory, persistence, stats, replication, commandstats, keyspace.
+//Without section(or with default) all sections but commandstats are returned, all - every section.
+
+func init() {
+	commands["info"] = infoCommand
+}
+
+//serverStats - counters of the server since start.
+type serverStats struct {
+	startTime      time.Time
+	connections    int64 //clients connected since start, changed atomically
+	processed      int64 //commands executed since start, changed atomically
+	keyspaceHits   int64 //changed atomically
+	keyspaceMisses int64 //changed atomically
+	expiredKeys    int64 //keys removed, because they expired, changed atomically
+	saveErrors     int64 //failed saves of the database, changed atomically
+	rewriteErrors  int64 //failed rewrites of append-only log, changed atomically
+	saves          histogram
+	rewrites       histogram
+
+	//key - name of command. The map is filled once with every command and isn't changed, so it is read without lock
+	commands map[string]*commandStats
+
+	Mut          *sync.Mutex   //guards fields below
+	lastSave     time.Time     //time of the last successful save, zero - if there was none
+	lastSaveErr  bool          //the last save failed
+	saveDuration time.Duration //duration of the last save
+	dirtyAtSave  int64         //KVCache.dirty, when the last successful save started
+}
+
+//commandStats - calls of single command, changed atomically.
+type commandStats struct {
+	latency histogram //durations of calls
+	failed  int64     //calls, that returned error
+}
+
+//durationBuckets - upper bounds of histogram buckets in seconds
+var durationBuckets = [...]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//histogram - distribution of durations by durationBuckets, changed atomically.
+type histogram struct {
+	buckets [len(durationBuckets)]int64 //amount of durations in bucket, that are larger than bound of previous one
+	count   int64
+	sum     int64 //total duration in nanoseconds
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(durationBuckets[:], d.Seconds())
+	if i < len(durationBuckets) {
+		atomic.AddInt64(&h.buckets[i], 1)
+	}
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+//newServerStats - creates stats with counters for every command. Must be called after all commands are registered.
+func newServerStats() *serverStats {
+	stats := &serverStats{startTime: time.Now(), Mut: &sync.Mutex{}, commands: make(map[string]*commandStats)}
+	for name := range commandNames() {
+		stats.commands[name] = &commandStats{}
+	}
+	for name := range authCommands {
+		stats.commands[name] = &commandStats{}
+	}
+	for name := range blockingCommands {
+		stats.commands[name] = &commandStats{}
+	}
+	return stats
+}
+
+//recordCommand - counts call of command, which took duration and returned err. Unknown commands are not counted,
+//nil replies(*nilError) are not failures.
+func (stats *serverStats) recordCommand(name string, duration time.Duration, err error) {
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	cs, ok := stats.commands[name]
+	if !ok {
+		return
+	}
+
+	atomic.AddInt64(&stats.processed, 1)
+	cs.latency.observe(duration)
+	if _, isNil := err.(*nilError); err != nil && !isNil {
+		atomic.AddInt64(&cs.failed, 1)
+	}
+}
+
+//recordSave - counts save of the database, which started, when dirty changes were made, and took duration.
+func (stats *serverStats) recordSave(dirty int64, duration time.Duration, err error) {
+	stats.saves.observe(duration)
+	if err != nil {
+		atomic.AddInt64(&stats.saveErrors, 1)
+	}
+
+	stats.Mut.Lock()
+	defer stats.Mut.Unlock()
+
+	stats.saveDuration = duration
+	stats.lastSaveErr = err != nil
+	if err == nil {
+		stats.lastSave = time.Now()
+		stats.dirtyAtSave = dirty
+	}
+}
+
+//saveState - returns time of the last successful save(zero - if there was none), whether the last save failed,
+//its duration and KVCache.dirty, when the last successful save started.
+func (stats *serverStats) saveState() (time.Time, bool, time.Duration, int64) {
+	stats.Mut.Lock()
+	defer stats.Mut.Unlock()
+
+	return stats.lastSave, stats.lastSaveErr, stats.saveDuration, stats.dirtyAtSave
+}
+
+//recordRewrite - counts rewrite of append-only log, which took duration.
+func (stats *serverStats) recordRewrite(duration time.Duration, err error) {
+	stats.rewrites.observe(duration)
+	if err != nil {
+		atomic.AddInt64(&stats.rewriteErrors, 1)
+	}
+}
+
+//clientCounts - returns amounts of connected clients and clients blocked in blpop/brpop.
+func (KVCache *KVCache) clientCounts() (int, int) {
+	KVCache.shutdown.Mut.Lock()
+	connected := len(KVCache.shutdown.clients)
+	KVCache.shutdown.Mut.Unlock()
+
+	KVCache.blocked.Mut.Lock()
+	defer KVCache.blocked.Mut.Unlock()
+
+	blocked := 0
+	for _, waiting := range KVCache.blocked.byKey {
+		blocked += len(waiting)
+	}
+	return connected, blocked
+}
+
+//keyCounts - returns amounts of keys and keys with expiration date. Shards are locked one by one.
+func (KVCache *KVCache) keyCounts() (int, int) {
+	keys, expires := 0, 0
+	for i, shard := range KVCache.shards {
+		unlock := KVCache.lockShards([]int{i}, false)
+		keys += len(shard.data)
+		shard.expKeys.Mut.Lock()
+		expires += len(shard.expKeys.ByKeyMap)
+		shard.expKeys.Mut.Unlock()
+		unlock()
+	}
+	return keys, expires
+}
+
+//countLookups - counts keys of read command as keyspace hits or misses. Must be called with shards of keys locked.
+func (KVCache *KVCache) countLookups(keys []string) {
+	for _, key := range keys {
+		if _, ok := KVCache.lookup(key); ok {
+			atomic.AddInt64(&KVCache.stats.keyspaceHits, 1)
+		} else {
+			atomic.AddInt64(&KVCache.stats.keyspaceMisses, 1)
+		}
+	}
+}
+
+/*infoSections - sections of info command in order of output*/
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "commandstats", "keyspace"}
+
+/*infoBuilders - functions, that write fields of info sections*/
+var infoBuilders = map[string]func(*KVCache, *infoWriter){
+	"server": func(KVCache *KVCache, info *infoWriter) {
+		uptime := time.Since(KVCache.stats.startTime)
+		info.field("go_version", runtime.Version())
+		info.field("process_id", os.Getpid())
+		info.field("tcp_port", KVCache.config.port)
+		info.field("config_file", KVCache.config.configFile)
+		info.field("shards", len(KVCache.shards))
+		info.field("uptime_in_seconds", int64(uptime/time.Second))
+		info.field("uptime_in_days", int64(uptime/(24*time.Hour)))
+	},
+
+	"clients": func(KVCache *KVCache, info *infoWriter) {
+		connected, blocked := KVCache.clientCounts()
+		info.field("connected_clients", connected)
+		info.field("blocked_clients", blocked)
+	},
+
+	"memory": func(KVCache *KVCache, info *infoWriter) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		used := atomic.LoadInt64(&KVCache.memory.used)
+		max, policy, _ := KVCache.memory.settings()
+		info.field("used_memory", used)
+		info.field("used_memory_human", humanBytes(used))
+		info.field("used_memory_heap", mem.HeapAlloc)
+		info.field("used_memory_heap_human", humanBytes(int64(mem.HeapAlloc)))
+		info.field("used_memory_sys", mem.Sys)
+		info.field("maxmemory", max)
+		info.field("maxmemory_human", humanBytes(max))
+		info.field("maxmemory_policy", policy)
+	},
+
+	"persistence": func(KVCache *KVCache, info *infoWriter) {
+		lastSave, lastSaveErr, saveDuration, dirtyAtSave := KVCache.stats.saveState()
+
+		lastSaveTime, lastSaveStatus := int64(0), "ok"
+		if !lastSave.IsZero() {
+			lastSaveTime = lastSave.Unix()
+		}
+		if lastSaveErr {
+			lastSaveStatus = "err"
+		}
+
+		KVCache.Mut.RLock()
+		autosaveIsOn, interval, dbFilename := KVCache.autosaveIndicator, KVCache.config.autosave, KVCache.config.dbFilename
+		KVCache.Mut.RUnlock()
+
+		info.field("dbfilename", dbFilename)
+		info.field("changes_since_last_save", atomic.LoadInt64(&KVCache.dirty)-dirtyAtSave)
+		info.field("last_save_time", lastSaveTime)
+		info.field("last_save_status", lastSaveStatus)
+		info.field("last_save_duration_ms", int64(saveDuration/time.Millisecond))
+		info.field("autosave_enabled", boolField(autosaveIsOn))
+		info.field("autosave_interval_sec", interval)
+		info.field("save_errors", atomic.LoadInt64(&KVCache.stats.saveErrors))
+
+		info.field("aof_enabled", boolField(KVCache.aof != nil))
+		if KVCache.aof != nil {
+			fsync, size, baseSize, rewriting := KVCache.aof.state()
+			info.field("aof_fsync", fsync)
+			info.field("aof_current_size", size)
+			info.field("aof_base_size", baseSize)
+			info.field("aof_rewrite_in_progress", boolField(rewriting))
+			info.field("aof_errors", atomic.LoadInt64(&KVCache.aof.errors))
+		}
+	},
+
+	"stats": func(KVCache *KVCache, info *infoWriter) {
+		KVCache.pubsub.Mut.RLock()
+		channels, patterns := len(KVCache.pubsub.channels), len(KVCache.pubsub.patterns)
+		KVCache.pubsub.Mut.RUnlock()
+
+		info.field("total_connections_received", atomic.LoadInt64(&KVCache.stats.connections))
+		info.field("total_commands_processed", atomic.LoadInt64(&KVCache.stats.processed))
+		info.field("keyspace_hits", atomic.LoadInt64(&KVCache.stats.keyspaceHits))
+		info.field("keyspace_misses", atomic.LoadInt64(&KVCache.stats.keyspaceMisses))
+		info.field("expired_keys", atomic.LoadInt64(&KVCache.stats.expiredKeys))
+		info.field("evicted_keys", atomic.LoadInt64(&KVCache.memory.evicted))
+		info.field("pubsub_channels", channels)
+		info.field("pubsub_patterns", patterns)
+	},
+
+	"replication": func(KVCache *KVCache, info *infoWriter) {
+		repl := KVCache.repl
+		repl.Mut.Lock()
+		defer repl.Mut.Unlock()
+
+		if repl.primaryAddr == "" {
+			info.field("role", "master")
+			info.field("connected_slaves", len(repl.replicas))
+		} else {
+			info.field("role", "slave")
+			info.field("master_addr", repl.primaryAddr)
+			info.field("master_link_status", repl.state)
+		}
+		info.field("master_replid", repl.replID)
+		info.field("master_repl_offset", repl.offset)
+	},
+
+	"commandstats": func(KVCache *KVCache, info *infoWriter) {
+		names := make([]string, 0, len(KVCache.stats.commands))
+		for name, cs := range KVCache.stats.commands {
+			if atomic.LoadInt64(&cs.latency.count) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			cs := KVCache.stats.commands[name]
+			calls := atomic.LoadInt64(&cs.latency.count)
+			usec := atomic.LoadInt64(&cs.latency.sum) / int64(time.Microsecond)
+			info.field("cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d",
+				calls, usec, float64(usec)/float64(calls), atomic.LoadInt64(&cs.failed)))
+		}
+	},
+
+	"keyspace": func(KVCache *KVCache, info *infoWriter) {
+		keys, expires := KVCache.keyCounts()
+		if keys > 0 {
+			info.field("db0", fmt.Sprintf("keys=%d,expires=%d", keys, expires))
+		}
+	},
+}
+
+//infoWriter - builds reply of info command.
+type infoWriter struct {
+	strings.Builder
+}
+
+func (info *infoWriter) field(name string, value interface{}) {
+	fmt.Fprintf(info, "%s:%v\r\n", name, value)
+}
+
+//infoCommand - return statistics of the server: info [section](see the top of the file).
+func infoCommand(KVCache *KVCache, cmd *command) (reply, error) {
+	if len(cmd.args) > 1 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: info [section]. %s;", cmd)
+	}
+
+	section := "default"
+	if len(cmd.args) == 1 {
+		section = strings.ToLower(cmd.args[0])
+	}
+
+	info := &infoWriter{}
+	for _, name := range infoSections {
+		selected := section == name || section == "all" || section == "everything" || (section == "default" && name != "commandstats")
+		if !selected {
+			continue
+		}
+
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
+		}
+		fmt.Fprintf(info, "# %s\r\n", strings.ToUpper(name[:1])+name[1:])
+		infoBuilders[name](KVCache, info)
+	}
+
+	return bulkReply(info.String()), nil
+}
+
+func boolField(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+//humanBytes - returns amount of bytes with suffix B, K, M or G.
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
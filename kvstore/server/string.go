@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	for name, executor := range stringCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["incr"] = true
+	writeCommands["decr"] = true
+	writeCommands["incrby"] = true
+	writeCommands["decrby"] = true
+	writeCommands["incrbyfloat"] = true
+	writeCommands["append"] = true
+	writeCommands["setrange"] = true
+	writeCommands["setnx"] = true
+	writeCommands["getdel"] = true
+	writeCommands["getex"] = true
+}
+
+//maxStringLength - max length of string value, setrange can make
+const maxStringLength = 512 << 20
+
+/*stringCommands - commands, that change string values in place, so clients don't need get and set, which race with other clients*/
+var stringCommands = map[string]func(*KVCache, *command) (reply, error){
+	//incr - increment integer value of key by one. Absent key is considered as 0.
+	//Return new value, or error if value is not an integer or it would overflow.
+	"incr": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return incrBy(KVCache, cmd.args[0], 1)
+	},
+
+	//decr - decrement integer value of key by one. Absent key is considered as 0.
+	"decr": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return incrBy(KVCache, cmd.args[0], -1)
+	},
+
+	//incrby - increment integer value of key by number.
+	"incrby": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		increment, err := strconv.ParseInt(cmd.args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Increment is not an integer or out of range: %s;", cmd.args[1])
+		}
+
+		return incrBy(KVCache, cmd.args[0], increment)
+	},
+
+	//decrby - decrement integer value of key by number.
+	"decrby": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		decrement, err := strconv.ParseInt(cmd.args[1], 10, 64)
+		if err != nil || decrement == math.MinInt64 {
+			return nil, fmt.Errorf("ERR: Decrement is not an integer or out of range: %s;", cmd.args[1])
+		}
+
+		return incrBy(KVCache, cmd.args[0], -decrement)
+	},
+
+	//incrbyfloat - increment value of key by floating point number(negative - to decrement).
+	//Return new value, or error if value is not a number or result is not finite.
+	"incrbyfloat": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		increment, err := parseFloatValue(cmd.args[1])
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Increment is not a valid float: %s;", cmd.args[1])
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var current float64
+		if value != nil {
+			current, err = parseFloatValue(value.Value)
+			if err != nil {
+				return nil, fmt.Errorf("ERR: Value is not a valid float: key = %s;", cmd.args[0])
+			}
+		}
+
+		current += increment
+		if math.IsNaN(current) || math.IsInf(current, 0) {
+			return nil, fmt.Errorf("ERR: Increment would produce NaN or Infinity: key = %s;", cmd.args[0])
+		}
+
+		result := strconv.FormatFloat(current, 'f', -1, 64)
+		storeString(KVCache, cmd.args[0], value, result)
+		KVCache.notifyKeyspaceEvent(notifyString, "incrbyfloat", cmd.args[0])
+
+		return bulkReply(result), nil
+	},
+
+	//append - append string to value of key. Absent key is considered as empty string.
+	//Return length of value after append.
+	"append": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		result := cmd.args[1]
+		if value != nil {
+			if len(value.Value)+len(cmd.args[1]) > maxStringLength {
+				return nil, fmt.Errorf("ERR: String exceeds maximum allowed size(%d bytes): key = %s;", maxStringLength, cmd.args[0])
+			}
+			result = value.Value + cmd.args[1]
+		}
+
+		storeString(KVCache, cmd.args[0], value, result)
+		KVCache.notifyKeyspaceEvent(notifyString, "append", cmd.args[0])
+
+		return intReply(len(result)), nil
+	},
+
+	//strlen - return length of value of key, 0 - if there is no such key.
+	"strlen": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil || value == nil {
+			return intReply(0), err
+		}
+
+		return intReply(len(value.Value)), nil
+	},
+
+	//getrange - return substring of value of key from start to end(inclusive, negative index counts from the end).
+	//Return empty string - if there is no such key or range is empty.
+	"getrange": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		start, end, err := validateRange(cmd.args[1], cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil || value == nil {
+			return bulkReply(""), err
+		}
+
+		start, end = normalizeRange(start, end, len(value.Value))
+		if start > end {
+			return bulkReply(""), nil
+		}
+
+		return bulkReply(value.Value[start : end+1]), nil
+	},
+
+	//setrange - overwrite part of value of key starting at offset. Value is padded with zero bytes, if it is shorter than offset.
+	//Return length of value after change.
+	"setrange": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		offset, err := strconv.Atoi(cmd.args[1])
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("ERR: Offset is not an integer or out of range: %s;", cmd.args[1])
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		current := ""
+		if value != nil {
+			current = value.Value
+		}
+
+		//empty string changes nothing, absent key is not created
+		if len(cmd.args[2]) == 0 {
+			return intReply(len(current)), nil
+		}
+
+		if offset > maxStringLength-len(cmd.args[2]) {
+			return nil, fmt.Errorf("ERR: String exceeds maximum allowed size(%d bytes): key = %s;", maxStringLength, cmd.args[0])
+		}
+
+		if len(current) < offset+len(cmd.args[2]) {
+			current += strings.Repeat("\x00", offset+len(cmd.args[2])-len(current))
+		}
+		result := current[:offset] + cmd.args[2] + current[offset+len(cmd.args[2]):]
+
+		storeString(KVCache, cmd.args[0], value, result)
+		KVCache.notifyKeyspaceEvent(notifyString, "setrange", cmd.args[0])
+
+		return intReply(len(result)), nil
+	},
+
+	//setnx - set value to key, only if there is no such key.
+	//Return true/false - if value is set/key already exists.
+	"setnx": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := KVCache.lookup(cmd.args[0]); ok {
+			return boolReply(false), nil
+		}
+
+		KVCache.store(cmd.args[0], newValue(cmd.args[1], false))
+		KVCache.addDirty(cmd.args[0], 1)
+		KVCache.notifyKeyspaceEvent(notifyString, "set", cmd.args[0])
+
+		return boolReply(true), nil
+	},
+
+	//getdel - return value of key and delete the key.
+	//Return nil,*nilError - if there is no such key.
+	"getdel": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, noSuchElement(cmd.args[0])
+		}
+
+		KVCache.remove(cmd.args[0])
+		KVCache.addDirty(cmd.args[0], 1)
+		KVCache.notifyKeyspaceEvent(notifyGeneric, "del", cmd.args[0])
+
+		return bulkReply(value.Value), nil
+	},
+
+	//getex - return value of key and change its expiration date:
+	//getex <key> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|pxat <unix-milliseconds>|persist]
+	//Without options expiration date is not changed.
+	//Return nil,*nilError - if there is no such key.
+	"getex": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		syntaxErr := fmt.Errorf("ERR: Syntax error. Should be: getex <key> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|"+
+			"pxat <unix-milliseconds>|persist]. %s;", cmd)
+
+		var deadline time.Time
+		persistKey := false
+		switch {
+		case len(cmd.args) == 1:
+
+		case len(cmd.args) == 2 && strings.ToLower(cmd.args[1]) == "persist":
+			persistKey = true
+
+		case len(cmd.args) == 3:
+			option := strings.ToLower(cmd.args[1])
+			if option != "ex" && option != "px" && option != "exat" && option != "pxat" {
+				return nil, syntaxErr
+			}
+
+			unit := time.Second
+			if option[0] == 'p' {
+				unit = time.Millisecond
+			}
+
+			deadline, err = validateExpireTime(cmd.args[2], unit, !strings.HasSuffix(option, "at"), false)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, syntaxErr
+		}
+
+		value, err := lookupString(KVCache, cmd.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, noSuchElement(cmd.args[0])
+		}
+
+		if persistKey {
+			persist(KVCache, cmd.args[0])
+		} else if !deadline.IsZero() {
+			expire(KVCache, cmd.args[0], deadline)
+		}
+
+		return bulkReply(value.Value), nil
+	},
+}
+
+//incrBy - executes incr, decr, incrby and decrby: adds increment to integer value of key.
+//Must be called with shard of key locked exclusively.
+func incrBy(KVCache *KVCache, key string, increment int64) (reply, error) {
+	value, err := lookupString(KVCache, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var current int64
+	if value != nil {
+		current, err = strconv.ParseInt(value.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR: Value is not an integer or out of range: key = %s;", key)
+		}
+	}
+
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return nil, fmt.Errorf("ERR: Increment or decrement would overflow: key = %s;", key)
+	}
+
+	current += increment
+	storeString(KVCache, key, value, strconv.FormatInt(current, 10))
+	KVCache.notifyKeyspaceEvent(notifyString, "incrby", key)
+
+	return intReply(current), nil
+}
+
+//lookupString - returns string value stored under key, nil - if there is no such key.
+//Returns errWrongType - if key holds value of other type. Must be called with shard of key locked.
+func lookupString(KVCache *KVCache, key string) (*Value, error) {
+	value, ok := KVCache.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if value.Type != typeString {
+		return nil, errWrongType
+	}
+
+	return value, nil
+}
+
+//storeString - changes string value of key in place, so its expiration date is kept,
+//or creates new value, if value is nil. Must be called with shard of key locked exclusively.
+func storeString(KVCache *KVCache, key string, value *Value, s string) {
+	if value == nil {
+		KVCache.store(key, newValue(s, false))
+	} else {
+		value.Value = s
+	}
+	KVCache.addDirty(key, 1)
+}
+
+//parseFloatValue - parses finite floating point number.
+func parseFloatValue(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("ERR: Value is not finite: %s;", s)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+//TLS: with -tls-cert and -tls-key all listeners accept TLS connections only. -tls-min-version sets the oldest allowed
+//version, -tls-ciphers restricts cipher suites of TLS 1.2 and older(suites of TLS 1.3 are not configurable).
+//With -tls-cacert clients may present certificates signed by the CA(-tls-auth-clients optional) or must present them(yes).
+//Client with verified certificate acts as ACL user named as common name of the certificate, if such user exists
+//and is enabled, so it needs no auth. Otherwise it acts as "default" user or should authenticate as usual.
+//Replica with -tls-replication connects to primary over TLS: it verifies primary's certificate with -tls-cacert
+//and presents its own -tls-cert.
+
+const (
+	tlsAuthClientsNo       = "no"
+	tlsAuthClientsOptional = "optional"
+	tlsAuthClientsYes      = "yes"
+
+	defaultTLSMinVersion  = "1.2"
+	defaultTLSAuthClients = tlsAuthClientsOptional
+
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+/*tlsVersions - versions of TLS allowed as -tls-min-version*/
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//newTLSConfig - returns configuration of TLS listeners, nil - if TLS is off.
+func newTLSConfig(config *config) (*tls.Config, error) {
+	if config.tlsCert == "" && config.tlsKey == "" {
+		return nil, nil
+	}
+
+	if config.tlsCert == "" || config.tlsKey == "" {
+		return nil, fmt.Errorf("ERR: Both -tls-cert and -tls-key should be set;")
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.tlsCert, config.tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: CAN'T LOAD TLS CERTIFICATE: %s, KEY: %s. ERR: %s;", config.tlsCert, config.tlsKey, err)
+	}
+
+	minVersion, ok := tlsVersions[config.tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("ERR: Unknown TLS version: %s. Should be one of: 1.0, 1.1, 1.2, 1.3;", config.tlsMinVersion)
+	}
+
+	ciphers, err := parseCipherSuites(config.tlsCiphers)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: minVersion, CipherSuites: ciphers}
+
+	switch strings.ToLower(config.tlsAuthClients) {
+	case tlsAuthClientsNo:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case tlsAuthClientsOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case tlsAuthClientsYes:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("ERR: Unknown value of -tls-auth-clients: %s. Should be one of: %s, %s, %s;",
+			config.tlsAuthClients, tlsAuthClientsNo, tlsAuthClientsOptional, tlsAuthClientsYes)
+	}
+
+	if config.tlsCACert != "" {
+		tlsConfig.ClientCAs, err = loadCertPool(config.tlsCACert)
+		if err != nil {
+			return nil, err
+		}
+	} else if tlsConfig.ClientAuth != tls.NoClientCert {
+		//without CA client certificates can't be verified
+		tlsConfig.ClientAuth = tls.NoClientCert
+		if strings.ToLower(config.tlsAuthClients) == tlsAuthClientsYes {
+			return nil, fmt.Errorf("ERR: -tls-auth-clients yes needs -tls-cacert;")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+//replicationTLSConfig - returns configuration of connection to primary: primary is verified with CA of the listener,
+//replica presents certificate of the listener.
+func replicationTLSConfig(listener *tls.Config) *tls.Config {
+	return &tls.Config{Certificates: listener.Certificates, RootCAs: listener.ClientCAs,
+		MinVersion: listener.MinVersion, CipherSuites: listener.CipherSuites}
+}
+
+//parseCipherSuites - parses comma separated names of cipher suites(see crypto/tls), empty - default suites.
+func parseCipherSuites(names string) ([]uint16, error) {
+	if names == "" {
+		return nil, nil
+	}
+
+	var ciphers []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ciphers = append(ciphers, suite.ID)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("ERR: Unknown or insecure cipher suite: %s;", name)
+		}
+	}
+
+	return ciphers, nil
+}
+
+//loadCertPool - loads PEM encoded CA certificates from file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ERR: CAN'T READ CA CERTIFICATE: %s. ERR: %s;", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ERR: No certificates in CA file: %s;", path)
+	}
+
+	return pool, nil
+}
+
+//tlsHandshake - completes handshake with client, so its certificate is known before the first command.
+//Returns ACL user of verified client certificate, empty - if there is no certificate or no such user.
+func tlsHandshake(rc *KVCache, conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err := conn.Handshake()
+	if err != nil {
+		return "", err
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return "", nil
+	}
+
+	name := state.PeerCertificates[0].Subject.CommonName
+	if !rc.acl.enabled(name) {
+		return "", nil
+	}
+
+	return name, nil
+}
+
+//listen - starts listener on port, TLS listener - if tlsConfig isn't nil.
+func listen(protocol, port string, tlsConfig *tls.Config) (net.Listener, error) {
+	l, err := net.Listen(protocol, port)
+	if err != nil || tlsConfig == nil {
+		return l, err
+	}
+
+	return tls.NewListener(l, tlsConfig), nil
+}
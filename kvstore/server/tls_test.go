@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testCert - certificate with private key, signed by test CA.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+//newTestCert - returns certificate with common name cn signed by parent, self-signed CA certificate - if parent is nil.
+//Its PEM certificate and key are written to dir as <cn>.pem and <cn>.key.
+func newTestCert(t *testing.T, dir, cn string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for name, data := range map[string][]byte{cn + ".pem": certPEM, cn + ".key": keyPEM} {
+		err := os.WriteFile(filepath.Join(dir, name), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert, key, pair}
+}
+
+//startTLSServer - starts server with TLS listener and returns its address. Client certificates are verified
+//with ca.pem from dir. User "alice" may run get, "default" user needs password.
+func startTLSServer(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	config := &config{}
+	err := newFlagSet("kvstore", config).Parse(append([]string{"-tls-cert", filepath.Join(dir, "server.pem"),
+		"-tls-key", filepath.Join(dir, "server.key"), "-tls-cacert", filepath.Join(dir, "ca.pem")}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := newKVCache(config)
+	_, err = run(t, rc, "set", "k", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, rules := range map[string][]string{
+		"default": {"resetpass", ">secret"},
+		"alice":   {"on", "nopass", "+get", "allkeys"},
+	} {
+		err := rc.acl.setUser(name, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return startServer(t, rc, tlsConfig, protocolRESP)
+}
+
+//tlsGet - connects to server over TLS with client configuration and returns the first line of reply to get k.
+func tlsGet(addr string, clientConfig *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("*2\r\n$3\r\nget\r\n$1\r\nk\r\n"))
+	if err != nil {
+		return "", err
+	}
+
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	newTestCert(t, dir, "server", ca)
+	alice := newTestCert(t, dir, "alice", ca)
+	bob := newTestCert(t, dir, "bob", ca)
+	stranger := newTestCert(t, t.TempDir(), "alice", newTestCert(t, t.TempDir(), "ca", nil))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(cert *testCert, maxVersion uint16) *tls.Config {
+		clientConfig := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+		if cert != nil {
+			clientConfig.Certificates = []tls.Certificate{cert.tls}
+		}
+		return clientConfig
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		client *tls.Config
+		want   string //prefix of reply, empty - connection must fail
+	}{
+		{"certificate of user", nil, client(alice, 0), "$1"},
+		{"no certificate", nil, client(nil, 0), "-NOAUTH"},
+		{"certificate without user", nil, client(bob, 0), "-NOAUTH"},
+		{"certificate of other CA", nil, client(stranger, 0), ""},
+		{"auth clients yes, certificate of user", []string{"-tls-auth-clients", "yes"}, client(alice, 0), "$1"},
+		{"auth clients yes, no certificate", []string{"-tls-auth-clients", "yes"}, client(nil, 0), ""},
+		{"auth clients no, certificate is ignored", []string{"-tls-auth-clients", "no"}, client(alice, 0), "-NOAUTH"},
+		{"min version 1.3, client 1.3", []string{"-tls-min-version", "1.3"}, client(alice, tls.VersionTLS13), "$1"},
+		{"min version 1.3, client 1.2", []string{"-tls-min-version", "1.3"}, client(alice, tls.VersionTLS12), ""},
+		{"min version 1.2, client 1.2", []string{"-tls-min-version", "1.2"}, client(alice, tls.VersionTLS12), "$1"},
+	}
+
+	for _, test := range tests {
+		addr := startTLSServer(t, dir, test.args...)
+		reply, err := tlsGet(addr, test.client)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: got reply %q, want failed connection", test.name, reply)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if !strings.HasPrefix(reply, test.want) {
+			t.Errorf("%s: got reply %q, want %s", test.name, reply, test.want)
+		}
+	}
+}
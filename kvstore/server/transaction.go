@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+//Transactions: commands sent after multi are queued and executed atomically by exec.
+//Keys may be watched before multi: if any of them is modified by other client before exec, transaction is aborted.
+//Each write command stores KVCache.dirty to Value.Version of keys it modified, so watch remembers versions of keys
+//and exec compares them with current ones.
+
+//transaction - commands queued by client after multi.
+type transaction struct {
+	queue  []*command
+	failed bool //some command could not be queued, so exec is aborted
+}
+
+//transactionCommands - commands to control transactions. They are not executed by getResponse,
+//but by handleConnection, as they need the client's state.
+var transactionCommands = map[string]func(*KVCache, *client, *command) (reply, error){
+	//multi - start transaction: the next commands are queued until exec or discard.
+	"multi": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if client.tx != nil {
+			return nil, fmt.Errorf("ERR: multi calls can not be nested;")
+		}
+
+		client.tx = &transaction{}
+		return okReply{}, nil
+	},
+
+	//exec - execute queued commands atomically and unwatch all keys.
+	//Return list of commands' results, nil - if any of watched keys was modified(then nothing is executed).
+	"exec": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		tx, watched := client.tx, client.watched
+		if tx == nil {
+			return nil, fmt.Errorf("ERR: exec without multi;")
+		}
+		client.tx = nil
+		client.watched = make(map[string]int64)
+
+		if tx.failed {
+			return nil, fmt.Errorf("EXECABORT: Transaction discarded because of previous errors;")
+		}
+
+		KVCache.txMut.Lock()
+		defer KVCache.txMut.Unlock()
+
+		if KVCache.modifiedSince(watched) {
+			return nil, nil
+		}
+
+		results := make(arrayReply, len(tx.queue))
+		for i, queued := range tx.queue {
+			result, err := execute(KVCache, queued, commandExecutor(queued.name, client.user))
+			if err != nil {
+				results[i] = errorReply{err}
+				continue
+			}
+			results[i] = result
+		}
+
+		return results, nil
+	},
+
+	//discard - drop queued commands and unwatch all keys.
+	"discard": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if client.tx == nil {
+			return nil, fmt.Errorf("ERR: discard without multi;")
+		}
+
+		client.tx = nil
+		client.watched = make(map[string]int64)
+		return okReply{}, nil
+	},
+
+	//watch - watch keys: the next exec is aborted, if any of them is modified before it.
+	"watch": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if client.tx != nil {
+			return nil, fmt.Errorf("ERR: watch inside multi is not allowed;")
+		}
+
+		unlock := KVCache.lockKeys(cmd.args, false)
+		defer unlock()
+
+		for _, key := range cmd.args {
+			if _, ok := client.watched[key]; !ok {
+				client.watched[key] = KVCache.keyVersion(key)
+			}
+		}
+
+		return okReply{}, nil
+	},
+
+	//unwatch - forget all watched keys.
+	"unwatch": func(KVCache *KVCache, client *client, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		client.watched = make(map[string]int64)
+		return okReply{}, nil
+	},
+}
+
+//enqueue - adds command to transaction. Commands, that can't be executed by exec, make it fail.
+func (tx *transaction) enqueue(cmd *command) (reply, error) {
+	name := strings.ToLower(cmd.name)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+
+	_, blocking := blockingCommands[name]
+	_, pubsub := pubsubCommands[name]
+	_, replication := replicationCommands[name]
+	if blocking || pubsub || replication {
+		tx.failed = true
+		return nil, fmt.Errorf("ERR: Command is not allowed in transaction: %s;", name)
+	}
+
+	if _, ok := commands[name]; !ok {
+		tx.failed = true
+		return nil, fmt.Errorf("ERR:Unknown command: %s;", name)
+	}
+
+	tx.queue = append(tx.queue, &command{name, cmd.args})
+	return statusReply("QUEUED"), nil
+}
+
+//keyVersion - returns version of key: value of KVCache.dirty when key was modified the last time.
+//Missing key has negative version, that changes with every deletion of key from its shard, so key, that was created and
+//deleted again, is seen as modified. Must be called with shard of key locked.
+func (KVCache *KVCache) keyVersion(key string) int64 {
+	value, ok := KVCache.lookup(key)
+	if !ok {
+		return -1 - KVCache.shardOf(key).deletions
+	}
+
+	return value.Version
+}
+
+//touchKeys - updates versions of keys modified by write command. Must be called with shards of the keys locked exclusively.
+func (KVCache *KVCache) touchKeys(cmd *command) {
+	version := atomic.LoadInt64(&KVCache.dirty)
+
+	for _, key := range modifiedKeys(cmd) {
+		if value, ok := KVCache.lookup(key); ok {
+			value.Version = version
+		}
+	}
+}
+
+//modifiedSince - check if any of watched keys has other version now.
+func (KVCache *KVCache) modifiedSince(watched map[string]int64) bool {
+	keys := make([]string, 0, len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+
+	unlock := KVCache.lockKeys(keys, false)
+	defer unlock()
+
+	for key, version := range watched {
+		if KVCache.keyVersion(key) != version {
+			return true
+		}
+	}
+
+	return false
+}
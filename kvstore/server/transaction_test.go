@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool       //watched key exists before watch
+		commands [][]string //commands of other client between watch and exec
+		aborted  bool
+	}{
+		{"missing key not modified", false, nil, false},
+		{"missing key created", false, [][]string{{"set", "k", "v"}}, true},
+		{"missing key created and deleted", false, [][]string{{"set", "k", "v"}, {"del", "k"}}, true},
+		{"missing key deleted", false, [][]string{{"del", "k"}}, false},
+		{"key not modified", true, [][]string{{"get", "k"}}, false},
+		{"key modified", true, [][]string{{"set", "k", "changed"}}, true},
+		{"key deleted and created", true, [][]string{{"del", "k"}, {"set", "k", "v"}}, true},
+	}
+
+	for _, test := range tests {
+		rc := newTestCache(t)
+		if test.existing {
+			_, err := run(t, rc, "set", "k", "v")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		client := &client{user: defaultUserName, watched: make(map[string]int64)}
+		for _, cmd := range []*command{{"watch", []string{"k"}}, {"multi", nil}} {
+			_, err := transactionCommands[cmd.name](rc, client, cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := client.tx.enqueue(&command{"set", []string{"k", "tx"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, args := range test.commands {
+			_, err := run(t, rc, args...)
+			if err != nil {
+				t.Fatalf("%s: %v: %s", test.name, args, err)
+			}
+		}
+
+		result, err := transactionCommands["exec"](rc, client, &command{"exec", nil})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if aborted := result == nil; aborted != test.aborted {
+			t.Errorf("%s: exec result %#v, aborted: %t, want %t", test.name, result, aborted, test.aborted)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Expiration dates are absolute and kept with millisecond precision. Commands with relative time(ex, pexpire, set ... ex)
+//are written to append-only log and sent to replicas with absolute date(pexpireat, set ... pxat),
+//so replay doesn't prolong key's life.
+
+func init() {
+	for name, executor := range ttlCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["pexpire"] = true
+	writeCommands["pexpireat"] = true
+	writeCommands["persist"] = true
+}
+
+/*ttlCommands - commands to set, get and remove expiration date of keys(ex and expireat are in commands.go)*/
+var ttlCommands = map[string]func(*KVCache, *command) (reply, error){
+	//pexpire - set expiration date of key in milliseconds from now. If it is 0, key is deleted at once.
+	//Return true/false - if expiration is set/there is no such key.
+	"pexpire": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline, err := validateExpireTime(cmd.args[1], time.Millisecond, true, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return expire(KVCache, cmd.args[0], deadline), nil
+	},
+
+	//pexpireat - set expiration date of key as unix time in milliseconds. If the date has already passed, key is deleted at once.
+	//Return true/false - if expiration is set/there is no such key.
+	"pexpireat": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline, err := validateExpireTime(cmd.args[1], time.Millisecond, false, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return expire(KVCache, cmd.args[0], deadline), nil
+	},
+
+	//ttl - return time to live of key in seconds, -1 - if key has no expiration date, -2 - if there is no such key.
+	"ttl": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return timeToLive(KVCache, cmd, time.Second)
+	},
+
+	//pttl - return time to live of key in milliseconds, -1 - if key has no expiration date, -2 - if there is no such key.
+	"pttl": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return timeToLive(KVCache, cmd, time.Millisecond)
+	},
+
+	//persist - remove expiration date of key.
+	//Return true/false - if expiration is removed/key has no expiration date or there is no such key.
+	"persist": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return boolReply(persist(KVCache, cmd.args[0])), nil
+	},
+}
+
+//persist - removes expiration date of key. Returns false - if key has no expiration date or there is no such key.
+//Must be called with shard of key locked exclusively.
+func persist(KVCache *KVCache, key string) bool {
+	value, ok := KVCache.lookup(key)
+	if !ok || !value.ExpireIsSet {
+		return false
+	}
+
+	value.ExpireIsSet = false
+	KVCache.removeExpiration(key)
+	KVCache.addDirty(key, 1)
+	KVCache.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+
+	return true
+}
+
+//expire - sets expiration date of key. If the date has already passed, key is deleted at once.
+//Return true/false - if expiration is set/there is no such key. Must be called with shard of key locked exclusively.
+func expire(KVCache *KVCache, key string, deadline time.Time) reply {
+	value, ok := KVCache.lookup(key)
+	if !ok {
+		return boolReply(false)
+	}
+
+	if !deadline.After(time.Now()) {
+		KVCache.remove(key)
+		KVCache.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	} else {
+		value.ExpireIsSet = true
+		KVCache.setExpiration(key, deadline)
+		KVCache.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	}
+	KVCache.addDirty(key, 1)
+
+	return boolReply(true)
+}
+
+//timeToLive - executes ttl and pttl: returns time left till expiration of key in units.
+func timeToLive(KVCache *KVCache, cmd *command, unit time.Duration) (reply, error) {
+	err := validateArgsCount(cmd, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := KVCache.lookup(cmd.args[0])
+	if !ok {
+		return intReply(-2), nil
+	}
+
+	deadline, ok := KVCache.expirationOf(cmd.args[0])
+	if !ok || !value.ExpireIsSet {
+		return intReply(-1), nil
+	}
+
+	left := time.Until(deadline)
+	if left < 0 {
+		left = 0
+	}
+
+	return intReply((left + unit/2) / unit), nil
+}
+
+//setOptions - options of set: set <key> <value> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|pxat <unix-milliseconds>|keepttl]
+//[nx|xx] [get]
+type setOptions struct {
+	deadline time.Time //expiration date, zero - key has no expiration date
+	keepTTL  bool      //keep expiration date of previous value
+	nx       bool      //set only if there is no such key
+	xx       bool      //set only if key exists
+	get      bool      //return previous value
+}
+
+//parseSetOptions - parses arguments of set after key and value.
+func parseSetOptions(cmd *command) (*setOptions, error) {
+	err := validateArgsMin(cmd, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	syntaxErr := fmt.Errorf("ERR: Syntax error. Should be: set <key> <value> [ex <seconds>|px <milliseconds>|exat <unix-seconds>|"+
+		"pxat <unix-milliseconds>|keepttl] [nx|xx] [get]. %s;", cmd)
+
+	options := &setOptions{}
+	expiration := false
+	for i := 2; i < len(cmd.args); i++ {
+		option := strings.ToLower(cmd.args[i])
+
+		switch option {
+		case "nx":
+			options.nx = true
+		case "xx":
+			options.xx = true
+		case "get":
+			options.get = true
+
+		case "keepttl":
+			if expiration {
+				return nil, syntaxErr
+			}
+			options.keepTTL, expiration = true, true
+
+		case "ex", "px", "exat", "pxat":
+			if expiration || i+1 == len(cmd.args) {
+				return nil, syntaxErr
+			}
+
+			unit := time.Second
+			if option[0] == 'p' {
+				unit = time.Millisecond
+			}
+
+			options.deadline, err = validateExpireTime(cmd.args[i+1], unit, !strings.HasSuffix(option, "at"), false)
+			if err != nil {
+				return nil, err
+			}
+			expiration = true
+			i++
+
+		default:
+			return nil, syntaxErr
+		}
+	}
+
+	if options.nx && options.xx {
+		return nil, syntaxErr
+	}
+
+	return options, nil
+}
+
+//validateExpireTime - parses expiration time in units(time.Second or time.Millisecond) and returns expiration date.
+//relative - time is counted from now, otherwise it is unix time. allowZero - 0 is valid time(key expires at once).
+//Time is limited by the longest time.Duration, so expiration date in milliseconds can't overflow.
+func validateExpireTime(Value string, unit time.Duration, relative, allowZero bool) (time.Time, error) {
+	n, err := strconv.ParseInt(Value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ERR: Expire time is not an integer: %s;", Value)
+	}
+
+	if n < 0 || (n == 0 && !allowZero) || n > math.MaxInt64/int64(unit) {
+		return time.Time{}, fmt.Errorf("ERR: Invalid expire time: %d. Use positive value;", n)
+	}
+
+	if relative {
+		return time.Now().Add(time.Duration(n) * unit), nil
+	}
+
+	perSecond := int64(time.Second / unit)
+	return time.Unix(n/perSecond, n%perSecond*int64(unit)), nil
+}
+
+//unixMilli - returns date as unix time in milliseconds.
+func unixMilli(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+}
+
+//fromUnixMilli - returns date from unix time in milliseconds.
+func fromUnixMilli(ms int64) time.Time {
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+}
+
+//formatUnixMilli - formats date as unix time in milliseconds.
+func formatUnixMilli(t time.Time) string {
+	return strconv.FormatInt(unixMilli(t), 10)
+}
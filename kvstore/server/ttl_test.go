@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateExpireTime(t *testing.T) {
+	tests := []struct {
+		value     string
+		unit      time.Duration
+		relative  bool
+		allowZero bool
+		ok        bool
+	}{
+		{"10", time.Second, true, false, true},
+		{"0", time.Second, true, true, true},
+		{"0", time.Second, true, false, false},
+		{"0", time.Millisecond, false, true, true},
+		{"0", time.Millisecond, false, false, false},
+		{"-1", time.Second, true, true, false},
+		{"x", time.Second, true, true, false},
+		{"9223372036", time.Second, false, false, true},
+		{"9223372037", time.Second, false, false, false},
+		{"9223372036854", time.Millisecond, false, false, true},
+		{"9223372036855", time.Millisecond, false, false, false},
+		{"9223372036855", time.Millisecond, true, false, false},
+		{"9223372036854775807", time.Millisecond, false, true, false},
+	}
+
+	for _, test := range tests {
+		deadline, err := validateExpireTime(test.value, test.unit, test.relative, test.allowZero)
+		if (err == nil) != test.ok {
+			t.Errorf("validateExpireTime(%s, %s, %t, %t): got error %v, want ok %t",
+				test.value, test.unit, test.relative, test.allowZero, err, test.ok)
+			continue
+		}
+		if err == nil && unixMilli(deadline) < 0 {
+			t.Errorf("validateExpireTime(%s, %s, %t, %t): deadline overflows: %d",
+				test.value, test.unit, test.relative, test.allowZero, unixMilli(deadline))
+		}
+	}
+}
+
+func TestSetExpireOptions(t *testing.T) {
+	rc := newTestCache(t)
+
+	for _, args := range [][]string{
+		{"set", "k", "v", "ex", "0"},
+		{"set", "k", "v", "px", "0"},
+		{"set", "k", "v", "exat", "0"},
+		{"set", "k", "v", "pxat", "9223372036854775807"},
+	} {
+		_, err := run(t, rc, args...)
+		if err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+		if _, ok := rc.lookup("k"); ok {
+			t.Fatalf("%v: key is stored", args)
+		}
+	}
+
+	_, err := run(t, rc, "set", "k", "v", "px", "100000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := run(t, rc, "pttl", "k")
+	if err != nil || result.(intReply) <= 0 || result.(intReply) > 100000 {
+		t.Errorf("pttl after set px 100000: got %v, %v", result, err)
+	}
+
+	//expire 0 is still allowed, key expires at once
+	result, err = run(t, rc, "expire", "k", "0")
+	if err != nil || result != boolReply(true) {
+		t.Errorf("expire k 0: got %v, %v", result, err)
+	}
+	if _, err := run(t, rc, "get", "k"); err == nil {
+		t.Error("key exists after expire k 0")
+	}
+}
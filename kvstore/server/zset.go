@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	for name, executor := range sortedSetCommands {
+		commands[name] = executor
+	}
+
+	writeCommands["zadd"] = true
+	writeCommands["zrem"] = true
+	writeCommands["zincrby"] = true
+	writeCommands["zremrangebyscore"] = true
+}
+
+//sortedSet - set of members ordered by score. Map gives score of member in O(1),
+//skiplist - ordered access and ranks in O(log n).
+type sortedSet struct {
+	dict map[string]float64
+	list *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{make(map[string]float64), newSkiplist()}
+}
+
+//add - sets score of member. Return true - if member is new.
+func (zset *sortedSet) add(score float64, member string) bool {
+	current, ok := zset.dict[member]
+	if ok {
+		if current == score {
+			return false
+		}
+		zset.list.delete(current, member)
+	}
+
+	zset.dict[member] = score
+	zset.list.insert(score, member)
+	return !ok
+}
+
+//remove - removes member. Return false - if there is no such member.
+func (zset *sortedSet) remove(member string) bool {
+	score, ok := zset.dict[member]
+	if !ok {
+		return false
+	}
+
+	delete(zset.dict, member)
+	zset.list.delete(score, member)
+	return true
+}
+
+/*sortedSetCommands - commands to work with sorted sets: sets, which members are ordered by score*/
+var sortedSetCommands = map[string]func(*KVCache, *command) (reply, error){
+	//zadd - add members with scores to sorted set, or update scores of existing ones.
+	//zadd <key> <score> <member> [<score> <member> ...]
+	//Return amount of added members(not counting updated ones).
+	"zadd": func(KVCache *KVCache, cmd *command) (reply, error) {
+		if len(cmd.args) < 3 || len(cmd.args)%2 != 1 {
+			return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: zadd <key> <score> <member> [<score> <member> ...]. %s;", cmd)
+		}
+
+		scores := make([]float64, 0, len(cmd.args)/2)
+		for i := 1; i < len(cmd.args); i += 2 {
+			score, err := validateScore(cmd.args[i])
+			if err != nil {
+				return nil, err
+			}
+			scores = append(scores, score)
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		counter := 0
+		for i, score := range scores {
+			if zset.ZSet.add(score, cmd.args[2+2*i]) {
+				counter++
+			}
+		}
+		KVCache.addDirty(cmd.args[0], 1)
+
+		return intReply(counter), nil
+	},
+
+	//zrem - remove members from sorted set. Sorted set without members is deleted.
+	//Return amount of removed members.
+	"zrem": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil {
+			return intReply(0), err
+		}
+
+		counter := 0
+		for _, member := range cmd.args[1:] {
+			if zset.ZSet.remove(member) {
+				counter++
+			}
+		}
+
+		deleteIfEmptySortedSet(KVCache, cmd.args[0], zset)
+		KVCache.addDirty(cmd.args[0], counter)
+
+		return intReply(counter), nil
+	},
+
+	//zscore - return score of member, nil - if there is no such member.
+	"zscore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil {
+			return nil, err
+		}
+
+		score, ok := zset.ZSet.dict[cmd.args[1]]
+		if !ok {
+			return nil, nil
+		}
+
+		return bulkReply(formatScore(score)), nil
+	},
+
+	//zincrby - increment score of member by increment. Absent member is added with score 0 before.
+	//zincrby <key> <increment> <member>
+	//Return new score.
+	"zincrby": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		increment, err := validateScore(cmd.args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], true)
+		if err != nil {
+			return nil, err
+		}
+
+		score := zset.ZSet.dict[cmd.args[2]] + increment
+		if math.IsNaN(score) {
+			deleteIfEmptySortedSet(KVCache, cmd.args[0], zset)
+			return nil, fmt.Errorf("ERR: Resulting score is not a number (NaN);")
+		}
+
+		zset.ZSet.add(score, cmd.args[2])
+		KVCache.addDirty(cmd.args[0], 1)
+
+		return bulkReply(formatScore(score)), nil
+	},
+
+	//zrange - return members from start to stop(inclusive) ordered by score from the lowest.
+	//zrange <key> <start> <stop> [withscores]
+	//Negative index counts from the end: -1 is the last member.
+	"zrange": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return rangeByRank(KVCache, cmd, false)
+	},
+
+	//zrevrange - like zrange, but members are ordered by score from the highest.
+	"zrevrange": func(KVCache *KVCache, cmd *command) (reply, error) {
+		return rangeByRank(KVCache, cmd, true)
+	},
+
+	//zrangebyscore - return members with score from min to max ordered by score from the lowest.
+	//zrangebyscore <key> <min> <max> [withscores] [limit <offset> <count>]
+	//Bound prefixed with "(" is excluded, -inf and +inf are allowed.
+	"zrangebyscore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsMin(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := validateScoreRange(cmd.args[1], cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		withScores := false
+		offset, count := 0, -1
+		for i := 3; i < len(cmd.args); i++ {
+			switch strings.ToLower(cmd.args[i]) {
+			case "withscores":
+				withScores = true
+			case "limit":
+				if i+2 >= len(cmd.args) {
+					return nil, fmt.Errorf("ERR: Syntax error: limit needs offset and count. %s;", cmd)
+				}
+				offset, err = strconv.Atoi(cmd.args[i+1])
+				if err == nil {
+					count, err = strconv.Atoi(cmd.args[i+2])
+				}
+				if err != nil {
+					return nil, fmt.Errorf("ERR: Offset or count is not an integer. %s;", cmd)
+				}
+				i += 2
+			default:
+				return nil, fmt.Errorf("ERR: Syntax error: %s. %s;", cmd.args[i], cmd)
+			}
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil || offset < 0 {
+			return arrayReply{}, err
+		}
+
+		result := arrayReply{}
+		node := zset.ZSet.list.firstInRange(r)
+		for ; node != nil && offset > 0; offset-- {
+			node = node.level[0].forward
+		}
+
+		for ; node != nil && r.belowMax(node.score) && count != 0; count-- {
+			result = append(result, bulkReply(node.member))
+			if withScores {
+				result = append(result, bulkReply(formatScore(node.score)))
+			}
+			node = node.level[0].forward
+		}
+
+		return result, nil
+	},
+
+	//zrank - return 0-based position of member ordered by score from the lowest, nil - if there is no such member.
+	"zrank": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil {
+			return nil, err
+		}
+
+		score, ok := zset.ZSet.dict[cmd.args[1]]
+		if !ok {
+			return nil, nil
+		}
+
+		return intReply(zset.ZSet.list.rank(score, cmd.args[1]) - 1), nil
+	},
+
+	//zcard - return amount of members in sorted set, 0 - if there is no such key.
+	"zcard": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil {
+			return intReply(0), err
+		}
+
+		return intReply(len(zset.ZSet.dict)), nil
+	},
+
+	//zremrangebyscore - remove members with score from min to max.
+	//zremrangebyscore <key> <min> <max>
+	//Return amount of removed members.
+	"zremrangebyscore": func(KVCache *KVCache, cmd *command) (reply, error) {
+		err := validateArgsCount(cmd, 3)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := validateScoreRange(cmd.args[1], cmd.args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+		if err != nil || zset == nil {
+			return intReply(0), err
+		}
+
+		removed := zset.ZSet.list.deleteRangeByScore(r, func(member string) {
+			delete(zset.ZSet.dict, member)
+		})
+
+		deleteIfEmptySortedSet(KVCache, cmd.args[0], zset)
+		KVCache.addDirty(cmd.args[0], removed)
+
+		return intReply(removed), nil
+	},
+}
+
+//rangeByRank - executes zrange and zrevrange.
+func rangeByRank(KVCache *KVCache, cmd *command, reverse bool) (reply, error) {
+	if len(cmd.args) != 3 && len(cmd.args) != 4 {
+		return nil, fmt.Errorf("ERR: Invalid number of arguments. Should be: %s <key> <start> <stop> [withscores]. %s;", cmd.name, cmd)
+	}
+
+	withScores := len(cmd.args) == 4
+	if withScores && strings.ToLower(cmd.args[3]) != "withscores" {
+		return nil, fmt.Errorf("ERR: Syntax error: %s. %s;", cmd.args[3], cmd)
+	}
+
+	start, stop, err := validateRange(cmd.args[1], cmd.args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	zset, err := lookupSortedSet(KVCache, cmd.args[0], false)
+	if err != nil || zset == nil {
+		return arrayReply{}, err
+	}
+
+	length := zset.ZSet.list.length
+	start, stop = normalizeRange(start, stop, length)
+	if stop < start {
+		return arrayReply{}, nil
+	}
+
+	var node *skiplistNode
+	if reverse {
+		node = zset.ZSet.list.byRank(length - start)
+	} else {
+		node = zset.ZSet.list.byRank(start + 1)
+	}
+
+	result := make(arrayReply, 0, stop-start+1)
+	for i := start; i <= stop && node != nil; i++ {
+		result = append(result, bulkReply(node.member))
+		if withScores {
+			result = append(result, bulkReply(formatScore(node.score)))
+		}
+
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+
+	return result, nil
+}
+
+//deleteIfEmptySortedSet - deletes sorted set from database, if it has no members.
+//Must be called with shard of key locked.
+func deleteIfEmptySortedSet(KVCache *KVCache, key string, zset *Value) {
+	if len(zset.ZSet.dict) == 0 {
+		KVCache.remove(key)
+	}
+}
+
+//lookupSortedSet - returns sorted set stored under key. If there is no such key, returns nil,
+//or creates new sorted set, when create is true. Returns errWrongType - if key holds value of other type.
+//Must be called with shard of key locked.
+func lookupSortedSet(KVCache *KVCache, key string, create bool) (*Value, error) {
+	value, ok := KVCache.lookup(key)
+	if ok && value.Type != typeSortedSet {
+		return nil, errWrongType
+	}
+
+	if !ok && create {
+		value = newSortedSetValue()
+		KVCache.store(key, value)
+	}
+
+	return value, nil
+}
+
+//validateScore validate if value is correct score: float number, -inf or +inf
+func validateScore(value string) (float64, error) {
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("ERR: Score is not a valid float: %s;", value)
+	}
+
+	return score, nil
+}
+
+//validateScoreRange validate min and max of score range. Bound prefixed with "(" is excluded.
+func validateScoreRange(min, max string) (scoreRange, error) {
+	r := scoreRange{}
+	var err error
+
+	if strings.HasPrefix(min, "(") {
+		r.minExclusive = true
+		min = min[1:]
+	}
+	if strings.HasPrefix(max, "(") {
+		r.maxExclusive = true
+		max = max[1:]
+	}
+
+	r.min, err = validateScore(min)
+	if err != nil {
+		return r, fmt.Errorf("ERR: Min or max is not a float: %s;", min)
+	}
+
+	r.max, err = validateScore(max)
+	if err != nil {
+		return r, fmt.Errorf("ERR: Min or max is not a float: %s;", max)
+	}
+
+	return r, nil
+}
+
+//formatScore - formats score the shortest way, that is parsed back to the same number.
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	if score == math.Trunc(score) && math.Abs(score) < 1e17 {
+		return strconv.FormatInt(int64(score), 10)
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
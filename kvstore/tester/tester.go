@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
 	"strconv"
 	"time"
+
+	"kvstore/connect"
 )
 
 //Program to make stresstest to server with database(redis format).
@@ -22,6 +25,7 @@ const (
 type config struct {
 	protocol string
 	addr     string
+	tls      connect.TLSOptions //connection over TLS
 }
 
 //commands []string - List of commands to test with
@@ -105,11 +109,12 @@ func getMetrics(command string, routinesCount int, logChan chan logStruct) strin
 //stressTester - open single connection to server, "atack" it with request-command 100-times(limitOfRequests),
 //measure response time from server and store it to metrics chan.
 func stressTester(config *config, command string, logUnit logStruct, logChan chan<- logStruct) {
-	conn, err := net.Dial(config.protocol, config.addr)
+	conn, err := connect.Dial(config.protocol, config.addr, &config.tls)
 
 	ifErrFatal(err)
 	defer conn.Close()
 
+	connReader := bufio.NewReader(conn)
 	for i := 0; i < limitOfRequests; i++ {
 
 		_, err := conn.Write(makeNetString(command))
@@ -120,15 +125,13 @@ func stressTester(config *config, command string, logUnit logStruct, logChan cha
 		}
 
 		start := time.Now()
-		connReader := bufio.NewReader(conn)
-
 		responseLength, err := getResponseLength(connReader)
 		if errHandler(err, logUnit, start, logChan) {
 			continue
 		}
 		response := make([]byte, responseLength)
 
-		_, err = connReader.Read(response)
+		_, err = io.ReadFull(connReader, response)
 		if errHandler(err, logUnit, start, logChan) {
 			continue
 		}
@@ -155,7 +158,7 @@ func errHandler(err error, logUnit logStruct, start time.Time, logChan chan<- lo
 
 func checkIfResponseIsError(response []byte) error {
 	if response[0] == 'E' {
-		return fmt.Errorf(string(response))
+		return fmt.Errorf("%s", response)
 	}
 	return nil
 }
@@ -184,17 +187,19 @@ func getResponseLength(connReader *bufio.Reader) (int, error) {
 }
 
 func getConfig(args []string) *config {
-	config := &config{defaultProtocol, defaultAddr}
+	config := &config{protocol: defaultProtocol, addr: defaultAddr}
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	config.tls.AddFlags(flags)
+	flags.Parse(args[1:])
+	args = flags.Args()
 
-	if len(args) == 2 {
-		config.addr = args[1]
-		return config
+	if len(args) >= 1 {
+		config.addr = args[0]
 	}
 
-	if len(args) == 3 {
-		config.addr = args[1]
-		config.protocol = args[2]
-		return config
+	if len(args) >= 2 {
+		config.protocol = args[1]
 	}
 
 	return config